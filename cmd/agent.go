@@ -2,28 +2,33 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
-	"math"
-	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	interval int
-	tagFlags []string
+	interval     int
+	tagFlags     []string
+	agentCheckIn string
+	agentSlug    string
+	agentOnce    bool
+	agentDryRun  bool
+	agentOutput  string
 )
 
 type cpuPayload struct {
@@ -61,6 +66,113 @@ type diskPayload struct {
 	UsedPercent float64 `json:"used_percent"`
 }
 
+type networkPayload struct {
+	Ts                string  `json:"ts"`
+	Event             string  `json:"event_type"`
+	Host              string  `json:"host"`
+	Interface         string  `json:"interface"`
+	BytesSentPerSec   float64 `json:"bytes_sent_per_sec"`
+	BytesRecvPerSec   float64 `json:"bytes_recv_per_sec"`
+	PacketsSentPerSec float64 `json:"packets_sent_per_sec"`
+	PacketsRecvPerSec float64 `json:"packets_recv_per_sec"`
+	ErrorsInPerSec    float64 `json:"errors_in_per_sec"`
+	ErrorsOutPerSec   float64 `json:"errors_out_per_sec"`
+	DropsInPerSec     float64 `json:"drops_in_per_sec"`
+	DropsOutPerSec    float64 `json:"drops_out_per_sec"`
+}
+
+type diskIOPayload struct {
+	Ts               string  `json:"ts"`
+	Event            string  `json:"event_type"`
+	Host             string  `json:"host"`
+	Device           string  `json:"device"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+	ReadsPerSec      float64 `json:"reads_per_sec"`
+	WritesPerSec     float64 `json:"writes_per_sec"`
+	IOTimePercent    float64 `json:"io_time_percent"`
+	AvgQueueDepth    float64 `json:"avg_queue_depth"`
+	InProgress       uint64  `json:"ios_in_progress"`
+}
+
+type processPayload struct {
+	Ts          string   `json:"ts"`
+	Event       string   `json:"event_type"`
+	Host        string   `json:"host"`
+	Process     string   `json:"process"`
+	Running     bool     `json:"running"`
+	PID         int32    `json:"pid"`
+	ProcessName string   `json:"process_name"`
+	CPUPercent  *float64 `json:"cpu_percent,omitempty"`
+	RSS         uint64   `json:"rss_bytes"`
+	OpenFDs     int32    `json:"open_fds"`
+	NumThreads  int32    `json:"num_threads"`
+}
+
+type processNotRunningPayload struct {
+	Ts      string `json:"ts"`
+	Event   string `json:"event_type"`
+	Host    string `json:"host"`
+	Process string `json:"process"`
+	Running bool   `json:"running"`
+}
+
+type containerPayload struct {
+	Ts                string   `json:"ts"`
+	Event             string   `json:"event_type"`
+	Host              string   `json:"host"`
+	Cgroup            string   `json:"cgroup"`
+	ContainerID       string   `json:"container_id,omitempty"`
+	ContainerName     string   `json:"container_name,omitempty"`
+	CPUPercent        *float64 `json:"cpu_percent,omitempty"`
+	MemoryBytes       uint64   `json:"memory_bytes"`
+	MemoryLimitBytes  *uint64  `json:"memory_limit_bytes,omitempty"`
+	MemoryUsedPercent *float64 `json:"memory_used_percent,omitempty"`
+	ReadBytesPerSec   *float64 `json:"read_bytes_per_sec,omitempty"`
+	WriteBytesPerSec  *float64 `json:"write_bytes_per_sec,omitempty"`
+	ReadsPerSec       *float64 `json:"reads_per_sec,omitempty"`
+	WritesPerSec      *float64 `json:"writes_per_sec,omitempty"`
+	Pids              uint64   `json:"pids"`
+}
+
+type hostPayload struct {
+	Ts    string `json:"ts"`
+	Event string `json:"event_type"`
+	Host  string `json:"host"`
+	hostInfo
+	UptimeSeconds uint64 `json:"uptime_seconds"`
+}
+
+// hostInfo is the part of hostPayload that's compared between collections
+// to tell whether the host has changed.
+type hostInfo struct {
+	OS                   string `json:"os"`
+	Platform             string `json:"platform,omitempty"`
+	PlatformFamily       string `json:"platform_family,omitempty"`
+	PlatformVersion      string `json:"platform_version,omitempty"`
+	KernelVersion        string `json:"kernel_version,omitempty"`
+	KernelArch           string `json:"kernel_arch,omitempty"`
+	CPUModel             string `json:"cpu_model,omitempty"`
+	CPUCores             int    `json:"cpu_cores"`
+	CPUThreads           int    `json:"cpu_threads"`
+	MemoryTotal          uint64 `json:"memory_total_bytes"`
+	BootTime             string `json:"boot_time"`
+	VirtualizationSystem string `json:"virtualization_system,omitempty"`
+	VirtualizationRole   string `json:"virtualization_role,omitempty"`
+	AgentVersion         string `json:"agent_version"`
+	AgentCommit          string `json:"agent_commit,omitempty"`
+}
+
+type agentHealthPayload struct {
+	Ts               string            `json:"ts"`
+	Event            string            `json:"event_type"`
+	Host             string            `json:"host"`
+	Healthy          bool              `json:"healthy"`
+	Collectors       []string          `json:"collectors"`
+	FailedCollectors []string          `json:"failed_collectors"`
+	CollectorErrors  map[string]string `json:"collector_errors,omitempty"`
+}
+
 // agentCmd represents the agent command
 var agentCmd = &cobra.Command{
 	Use:     "agent",
@@ -68,10 +180,21 @@ var agentCmd = &cobra.Command{
 	GroupID: GroupReportingAPI,
 	Long: `Start a persistent process that periodically reports host metrics to Honeybadger's Insights API.
 This command collects and reports system metrics such as CPU usage, memory usage, disk usage, and load averages.
-Metrics are aggregated and reported at a configurable interval (default: 60 seconds).`,
-	RunE: func(_ *cobra.Command, _ []string) error {
+Metrics are aggregated and reported at a configurable interval (default: 60 seconds).
+
+The agent stops after finishing any report in progress when it receives SIGINT
+or SIGTERM. Send SIGHUP to reload the config file (including agent.tags and
+agent.interval) without restarting.
+
+Use --once to run every collector a single time and exit, with a non-zero
+status if any collector failed. Collectors that report rates (network, disk
+I/O, container, and process CPU usage) take a first sample a second earlier
+to compute them from. Use --dry-run to print events to stdout as
+NDJSON instead of sending them, or --output-file to write them to a file.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		dryRun := agentDryRun || agentOutput != ""
 		apiKey := viper.GetString("api_key")
-		if apiKey == "" {
+		if apiKey == "" && !dryRun && !viper.IsSet("agent.destinations") {
 			return fmt.Errorf(
 				"API key not configured. Use --api-key flag or set HONEYBADGER_API_KEY environment variable",
 			)
@@ -83,37 +206,262 @@ Metrics are aggregated and reported at a configurable interval (default: 60 seco
 			return err
 		}
 
-		// Load config file tags and merge (CLI flags take precedence)
-		configTags, err := loadConfigTags()
+		// Log read positions are only kept in memory when events aren't
+		// being sent or the agent runs once, so that neither skips lines
+		// for a later run of the agent.
+		load := func() (agentSettings, error) {
+			settings, err := loadAgentSettings(cmd, flagTags)
+			if dryRun || agentOnce {
+				settings.logStatePath = ""
+			}
+			return settings, err
+		}
+
+		settings, err := load()
 		if err != nil {
 			return err
 		}
-		tags := mergeTags(configTags, flagTags)
 
-		ctx := context.Background()
-		ticker := time.NewTicker(time.Duration(interval) * time.Second)
-		defer ticker.Stop()
+		// Progress messages go to stderr when events are printed to stdout.
+		var messages io.Writer = os.Stdout
+		var sink io.Writer
+		var sp *spool
+		switch {
+		case agentOutput != "":
+			f, err := os.OpenFile(agentOutput, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600) // #nosec G304
+			if err != nil {
+				return fmt.Errorf("error opening output file: %w", err)
+			}
+			defer f.Close() // nolint:errcheck
+			sink = f
+		case agentDryRun:
+			sink = os.Stdout
+			messages = os.Stderr
+		default:
+			sp, err = loadSpool()
+			if err != nil {
+				return err
+			}
+		}
 
 		hostname, err := os.Hostname()
 		if err != nil {
 			hostname = "unknown"
 		}
 
-		fmt.Printf("Starting metrics agent, reporting every %d seconds...\n", interval)
+		a := newAgent(hostname, settings.tags, sp)
+		a.sink = sink
+		a.apply(settings)
 
-		for {
-			select {
-			case <-ctx.Done():
-				return nil
-			case <-ticker.C:
-				if err := reportMetrics(hostname, tags); err != nil {
-					fmt.Fprintf(os.Stderr, "Error reporting metrics: %v\n", err)
-				}
+		if agentOnce {
+			if a.prime() {
+				time.Sleep(onceSampleDelay)
 			}
+			return a.reportMetrics()
 		}
+
+		if address := viper.GetString("agent.status.address"); address != "" {
+			stopStatus, err := a.serveStatus(address)
+			if err != nil {
+				return err
+			}
+			defer stopStatus()
+		}
+
+		// Stop on SIGINT/SIGTERM (e.g. from systemd) and reload config on SIGHUP.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		defer signal.Stop(reload)
+
+		fmt.Fprintf(
+			messages,
+			"Starting metrics agent, reporting every %d seconds...\n",
+			int(a.interval/time.Second),
+		)
+
+		err = a.run(ctx, reload, func() (agentSettings, error) {
+			if err := rereadConfigFile(); err != nil {
+				return agentSettings{}, err
+			}
+			return load()
+		})
+		fmt.Fprintln(messages, "Metrics agent stopped")
+		return err
 	},
 }
 
+// agentSettings are the agent options that can be changed by reloading the
+// config file.
+type agentSettings struct {
+	interval          time.Duration
+	tags              map[string]string
+	collectors        map[string]collectorConfig
+	networkFilter     nameFilter
+	diskIOFilter      nameFilter
+	fstypeFilter      nameFilter
+	mountpointFilter  nameFilter
+	processRules      []processRule
+	prometheusTargets []prometheusTarget
+	statsdAddress     string
+	syslog            syslogConfig
+	otlpAddress       string
+	containerFilter   nameFilter
+	heartbeatURL      string
+	destinations      []destinationConfig
+	logSources        []logSource
+	logStatePath      string
+}
+
+// defaultAgentSettings are the settings used when there is no config file:
+// every collector enabled on the --interval flag's value.
+func defaultAgentSettings(tags map[string]string) agentSettings {
+	return agentSettings{
+		interval:         time.Duration(interval) * time.Second,
+		tags:             tags,
+		networkFilter:    nameFilter{exclude: defaultNetworkExclude},
+		diskIOFilter:     nameFilter{exclude: defaultDiskIOExclude},
+		fstypeFilter:     nameFilter{exclude: defaultFstypeExclude},
+		mountpointFilter: nameFilter{exclude: defaultMountpointExclude},
+	}
+}
+
+// loadAgentSettings resolves the reporting interval, tags, and collector
+// options. The --interval flag takes precedence over "agent.interval" in the
+// config file, and flag tags are merged over "agent.tags".
+func loadAgentSettings(cmd *cobra.Command, flagTags map[string]string) (agentSettings, error) {
+	seconds := interval
+	if !cmd.Flags().Changed("interval") && viper.IsSet("agent.interval") {
+		seconds = viper.GetInt("agent.interval")
+	}
+	if seconds <= 0 {
+		return agentSettings{}, fmt.Errorf("invalid interval %d: must be a positive number of seconds", seconds)
+	}
+
+	// Load config file tags and merge (CLI flags take precedence)
+	configTags, err := loadConfigTags()
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	collectors, err := loadCollectorConfigs()
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	networkFilter, err := loadNameFilter("agent.network", defaultNetworkExclude)
+	if err != nil {
+		return agentSettings{}, err
+	}
+	diskIOFilter, err := loadNameFilter("agent.diskio", defaultDiskIOExclude)
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	fstypeFilter, err := loadNameFilter("agent.disk.fstypes", defaultFstypeExclude)
+	if err != nil {
+		return agentSettings{}, err
+	}
+	mountpointFilter, err := loadNameFilter("agent.disk.mountpoints", defaultMountpointExclude)
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	processRules, err := loadProcessRules()
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	containerFilter, err := loadNameFilter("agent.containers", nil)
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	prometheusTargets, err := loadPrometheusTargets()
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	syslog, err := loadSyslogConfig()
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	logSources, err := loadLogSources()
+	if err != nil {
+		return agentSettings{}, err
+	}
+	var logStatePath string
+	if len(logSources) > 0 {
+		if logStatePath, err = defaultLogStatePath(); err != nil {
+			return agentSettings{}, err
+		}
+	}
+
+	destinations, err := loadDestinationConfigs()
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	heartbeatURL, err := loadAgentCheckIn(cmd, destinations)
+	if err != nil {
+		return agentSettings{}, err
+	}
+
+	return agentSettings{
+		interval:          time.Duration(seconds) * time.Second,
+		tags:              mergeTags(configTags, flagTags),
+		collectors:        collectors,
+		networkFilter:     networkFilter,
+		diskIOFilter:      diskIOFilter,
+		fstypeFilter:      fstypeFilter,
+		mountpointFilter:  mountpointFilter,
+		processRules:      processRules,
+		containerFilter:   containerFilter,
+		prometheusTargets: prometheusTargets,
+		statsdAddress:     viper.GetString("agent.statsd.address"),
+		syslog:            syslog,
+		otlpAddress:       viper.GetString("agent.otlp.address"),
+		logSources:        logSources,
+		logStatePath:      logStatePath,
+		heartbeatURL:      heartbeatURL,
+		destinations:      destinations,
+	}, nil
+}
+
+// loadAgentCheckIn returns the URL of the check-in the agent reports after
+// each successful reporting cycle, or "" if it has none. The --check-in-id
+// and --check-in-slug flags take precedence over "agent.check_in" in the
+// config file. Without a global API key, the check-in belongs to the first
+// destination's project.
+func loadAgentCheckIn(cmd *cobra.Command, destinations []destinationConfig) (string, error) {
+	id := viper.GetString("agent.check_in.id")
+	slug := viper.GetString("agent.check_in.slug")
+	if cmd.Flags().Changed("check-in-id") || cmd.Flags().Changed("check-in-slug") {
+		id, slug = agentCheckIn, agentSlug
+	}
+	if id == "" && slug == "" {
+		return "", nil
+	}
+	apiKey, endpoint := viper.GetString("api_key"), viper.GetString("endpoint")
+	if apiKey == "" && len(destinations) > 0 {
+		apiKey, endpoint = destinations[0].APIKey, destinations[0].Endpoint
+	}
+	return projectCheckInURL(id, slug, apiKey, endpoint, "--check-in-id", "--check-in-slug")
+}
+
+// rereadConfigFile reloads the config file found at startup. Values set by
+// flags and environment variables still take precedence.
+func rereadConfigFile() error {
+	err := viper.ReadInConfig()
+	var notFound viper.ConfigFileNotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(agentCmd)
 	agentCmd.Flags().IntVarP(&interval, "interval", "i", 60, "Reporting interval in seconds")
@@ -121,6 +469,26 @@ func init() {
 		&tagFlags, "tag", "t", nil,
 		"Tag in key=value format (repeatable, e.g. --tag environment=stage)",
 	)
+	agentCmd.Flags().StringVar(
+		&agentCheckIn, "check-in-id", "",
+		"Check-in ID to report after each successful reporting cycle",
+	)
+	agentCmd.Flags().StringVar(
+		&agentSlug, "check-in-slug", "",
+		"Check-in slug to report after each successful reporting cycle",
+	)
+	agentCmd.Flags().BoolVar(
+		&agentOnce, "once", false,
+		"Run every collector once and exit, failing if any collector failed",
+	)
+	agentCmd.Flags().BoolVar(
+		&agentDryRun, "dry-run", false,
+		"Print events to stdout as NDJSON instead of sending them",
+	)
+	agentCmd.Flags().StringVar(
+		&agentOutput, "output-file", "",
+		"Append events to this file as NDJSON instead of sending them",
+	)
 }
 
 // reservedTagKeys are metric payload fields that tags must not override.
@@ -140,6 +508,104 @@ var reservedTagKeys = map[string]bool{
 	"mountpoint":      true,
 	"device":          true,
 	"fstype":          true,
+
+	"interface":            true,
+	"bytes_sent_per_sec":   true,
+	"bytes_recv_per_sec":   true,
+	"packets_sent_per_sec": true,
+	"packets_recv_per_sec": true,
+	"errors_in_per_sec":    true,
+	"errors_out_per_sec":   true,
+	"drops_in_per_sec":     true,
+	"drops_out_per_sec":    true,
+
+	"read_bytes_per_sec":  true,
+	"write_bytes_per_sec": true,
+	"reads_per_sec":       true,
+	"writes_per_sec":      true,
+	"io_time_percent":     true,
+	"avg_queue_depth":     true,
+	"ios_in_progress":     true,
+
+	"process":      true,
+	"running":      true,
+	"pid":          true,
+	"process_name": true,
+	"cpu_percent":  true,
+	"rss_bytes":    true,
+	"open_fds":     true,
+	"num_threads":  true,
+
+	"healthy":           true,
+	"collectors":        true,
+	"failed_collectors": true,
+	"collector_errors":  true,
+
+	"job":         true,
+	"instance":    true,
+	"metric_type": true,
+	"value":       true,
+	"rate":        true,
+	"count":       true,
+	"sum":         true,
+	"buckets":     true,
+	"quantiles":   true,
+
+	"metric": true,
+	"min":    true,
+	"max":    true,
+	"mean":   true,
+	"p50":    true,
+	"p90":    true,
+	"p95":    true,
+	"p99":    true,
+
+	"source":  true,
+	"file":    true,
+	"message": true,
+
+	"facility":        true,
+	"severity":        true,
+	"app":             true,
+	"hostname":        true,
+	"msg":             true,
+	"proc_id":         true,
+	"msg_id":          true,
+	"structured_data": true,
+	"received":        true,
+	"dropped":         true,
+
+	"severity_number": true,
+	"trace_id":        true,
+	"span_id":         true,
+	"unit":            true,
+	"temporality":     true,
+	"monotonic":       true,
+
+	"cgroup":              true,
+	"container_id":        true,
+	"container_name":      true,
+	"memory_bytes":        true,
+	"memory_limit_bytes":  true,
+	"memory_used_percent": true,
+	"pids":                true,
+
+	"os":                    true,
+	"platform":              true,
+	"platform_family":       true,
+	"platform_version":      true,
+	"kernel_version":        true,
+	"kernel_arch":           true,
+	"cpu_model":             true,
+	"cpu_cores":             true,
+	"cpu_threads":           true,
+	"memory_total_bytes":    true,
+	"boot_time":             true,
+	"uptime_seconds":        true,
+	"virtualization_system": true,
+	"virtualization_role":   true,
+	"agent_version":         true,
+	"agent_commit":          true,
 }
 
 // parseTags converts a slice of "key=value" strings into a map.
@@ -188,12 +654,12 @@ func loadConfigTags() (map[string]string, error) {
 	return raw, nil
 }
 
-// sendMetric sends a single metric event to Honeybadger.
-// Tags are merged into the JSON payload, overriding any existing fields.
-func sendMetric(payload any, tags map[string]string) error {
+// buildEvent marshals a metric payload to JSON and merges tags into it,
+// overriding any existing fields.
+func buildEvent(payload any, tags map[string]string) ([]byte, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("error marshaling metrics: %w", err)
+		return nil, fmt.Errorf("error marshaling metrics: %w", err)
 	}
 
 	// If tags are present, unmarshal to a map of raw JSON values, overlay tags,
@@ -202,149 +668,479 @@ func sendMetric(payload any, tags map[string]string) error {
 	if len(tags) > 0 {
 		var merged map[string]json.RawMessage
 		if err := json.Unmarshal(jsonData, &merged); err != nil {
-			return fmt.Errorf("error unmarshaling metrics for tag merge: %w", err)
+			return nil, fmt.Errorf("error unmarshaling metrics for tag merge: %w", err)
 		}
 		for k, v := range tags {
 			tagJSON, err := json.Marshal(v)
 			if err != nil {
-				return fmt.Errorf("error marshaling tag %q: %w", k, err)
+				return nil, fmt.Errorf("error marshaling tag %q: %w", k, err)
 			}
 			merged[k] = tagJSON
 		}
 		jsonData, err = json.Marshal(merged)
 		if err != nil {
-			return fmt.Errorf("error marshaling final payload: %w", err)
+			return nil, fmt.Errorf("error marshaling final payload: %w", err)
 		}
 	}
 
-	apiEndpoint := viper.GetString("endpoint")
-	req, err := http.NewRequest(
-		"POST",
-		fmt.Sprintf("%s/v1/events", apiEndpoint),
-		strings.NewReader(string(jsonData)+"\n"),
-	)
-	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+	return jsonData, nil
+}
+
+// collectorStopTimeout is how long a stopping agent waits for collections
+// that are still in progress before delivering what it has.
+const collectorStopTimeout = 15 * time.Second
+
+// agent holds the state the metrics agent carries between reporting ticks.
+// Collectors queue their payloads in pending, which is delivered as one
+// batch every interval.
+type agent struct {
+	hostname     string
+	interval     time.Duration
+	tags         map[string]string
+	collectors   []scheduledCollector
+	destinations []*destination
+	heartbeatURL string
+	// spool holds events that failed to send. With more than one
+	// destination, each spools in a subdirectory of it instead.
+	spool             *spool
+	destinationSpools map[string]*spool
+	// host is kept across reloads, since it only reports the inventory
+	// when it changes.
+	host *hostCollector
+	// sink, when set, receives events as NDJSON instead of Honeybadger.
+	sink io.Writer
+
+	startedAt time.Time
+
+	// delivering is held while a batch is delivered and its checkpoints
+	// committed or rolled back. Checkpointer collectors wait for it, so
+	// they don't queue events that a rollback would read again.
+	delivering sync.Mutex
+
+	mu      sync.Mutex
+	pending []any
+	// checkpoints holds the progress of checkpointer collectors as of each
+	// collection whose payloads are pending.
+	checkpoints []checkpoint
+	// collectorErrors holds the error from each collector's most recent
+	// collection, for collectors whose last run failed.
+	collectorErrors map[string]string
+	// collectorRuns holds when each collector last finished a collection.
+	collectorRuns map[string]time.Time
+	// delivery tracks the outcome of sending events, for the status
+	// endpoint.
+	delivery deliveryStatus
+
+	// checkingIn is set while a check-in is being sent, so that a slow one
+	// isn't joined by another.
+	checkingIn atomic.Bool
+}
+
+// deliveryStatus records how sending events to Honeybadger has been going.
+type deliveryStatus struct {
+	lastSuccess time.Time
+	lastError   string
+	failures    int // consecutive
+}
+
+func newAgent(hostname string, tags map[string]string, sp *spool) *agent {
+	a := &agent{hostname: hostname, spool: sp, startedAt: time.Now()}
+	a.apply(defaultAgentSettings(tags))
+	return a
+}
+
+// apply updates the agent with settings loaded from flags and the config
+// file, picking up any changes to the endpoint and batch settings as well.
+// Collectors are rebuilt, so rate-based collectors start from a new baseline.
+// The host collector is kept, so a reload doesn't report the inventory again.
+func (a *agent) apply(settings agentSettings) {
+	a.tags = settings.tags
+	a.heartbeatURL = settings.heartbeatURL
+	collectors := newCollectors(a.hostname, settings)
+	for i, c := range collectors {
+		if host, ok := c.collector.(*hostCollector); ok {
+			if a.host == nil {
+				a.host = host
+			}
+			collectors[i].collector = a.host
+		}
 	}
+	destinations := a.newDestinations(settings)
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", viper.GetString("api_key"))
+	// The status endpoint reads these from another goroutine.
+	a.mu.Lock()
+	a.interval = settings.interval
+	a.collectors = collectors
+	a.destinations = destinations
+	a.collectorErrors = make(map[string]string)
+	a.collectorRuns = make(map[string]time.Time)
+	a.mu.Unlock()
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req) //nolint:gosec // endpoint is intentionally user-configurable
-	if err != nil {
-		return fmt.Errorf("error sending metrics: %w", err)
+// run starts the collectors and delivers their metrics every interval until
+// ctx is canceled. Collections that are in progress when ctx is canceled are
+// allowed to finish, so their events are either delivered or spooled before
+// run returns. Each value received on reload calls loadSettings and applies
+// the result without restarting.
+func (a *agent) run(
+	ctx context.Context,
+	reload <-chan os.Signal,
+	loadSettings func() (agentSettings, error),
+) error {
+	if err := a.reportHost(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reporting host inventory: %v\n", err)
 	}
-	defer func() {
-		if cerr := resp.Body.Close(); cerr != nil {
-			fmt.Fprintf(os.Stderr, "error closing response body: %v\n", cerr)
-		}
-	}()
 
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("received error response: %s\n%s", resp.Status, body)
+	var wg sync.WaitGroup
+	stopCollectors := a.startCollectors(ctx, &wg)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopCollectors()
+			if !waitTimeout(&wg, collectorStopTimeout) {
+				fmt.Fprintln(os.Stderr, "Error stopping metrics agent: timed out waiting for collectors")
+			}
+			if err := a.flush(time.Now().UTC().Format(time.RFC3339)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reporting metrics: %v\n", err)
+			}
+			return nil
+		case <-reload:
+			settings, err := loadSettings()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reloading config, keeping current settings: %v\n", err)
+				continue
+			}
+			if settings.interval != a.interval {
+				ticker.Reset(settings.interval)
+			}
+			stopCollectors()
+			// Deliver what the old collectors queued first, so that the
+			// new ones resume from committed read positions rather than
+			// reading pending events again.
+			if err := a.flush(time.Now().UTC().Format(time.RFC3339)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reporting metrics: %v\n", err)
+			}
+			a.apply(settings)
+			stopCollectors = a.startCollectors(ctx, &wg)
+			fmt.Fprintf(
+				os.Stderr,
+				"Reloaded config, reporting every %d seconds\n",
+				int(a.interval/time.Second),
+			)
+		case <-ticker.C:
+			if ctx.Err() != nil {
+				continue
+			}
+			if err := a.flush(time.Now().UTC().Format(time.RFC3339)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reporting metrics: %v\n", err)
+			}
+			a.checkIn()
+		}
 	}
+}
 
+// reportHost reports the host inventory right away when the agent starts,
+// rather than after the first interval, if the host collector is enabled.
+func (a *agent) reportHost() error {
+	for _, c := range a.collectors {
+		if _, ok := c.collector.(*hostCollector); !ok {
+			continue
+		}
+		timestamp := time.Now().UTC().Format(time.RFC3339)
+		if err := a.collect(c, timestamp); err != nil {
+			return err
+		}
+		return a.flush(timestamp)
+	}
 	return nil
 }
 
-func reportMetrics(hostname string, tags map[string]string) error {
-	timestamp := time.Now().UTC().Format(time.RFC3339)
+// startCollectors runs each collector on its own goroutine and interval, so
+// a slow collector can't hold up the others. The returned function stops
+// the collectors from starting new collections; one that is in progress
+// still finishes and queues its payloads. Listening collectors are closed
+// and drained, and other collectors that hold resources are waited for and
+// closed, before it returns.
+func (a *agent) startCollectors(ctx context.Context, wg *sync.WaitGroup) func() {
+	ctx, cancel := context.WithCancel(ctx)
 
-	// Collect and send CPU metrics
-	cpuPercent, err := cpu.Percent(time.Second, false)
-	var usedPercent float64
-	if err != nil {
-		// cpu.Percent may fail on macOS with CGO_ENABLED=0; use -1 to indicate unavailable
-		usedPercent = -1
-	} else if len(cpuPercent) > 0 {
-		usedPercent = math.Round(cpuPercent[0]*100) / 100
+	var listeners []scheduledCollector
+	for _, c := range a.collectors {
+		if l, ok := c.collector.(listener); ok {
+			if err := l.listen(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error starting %s collector: %v\n", c.name, err)
+				continue
+			}
+			listeners = append(listeners, c)
+		}
 	}
 
-	loadAvg, err := load.Avg()
-	if err != nil {
-		return fmt.Errorf("error getting load average: %w", err)
+	var closing sync.WaitGroup
+	for _, c := range a.collectors {
+		every := c.interval
+		if every == 0 {
+			every = a.interval
+		}
+		_, isListener := c.collector.(listener)
+		cl, isCloser := c.collector.(closer)
+		isCloser = isCloser && !isListener
+
+		wg.Add(1)
+		if isCloser {
+			closing.Add(1)
+		}
+		go func() {
+			defer wg.Done()
+			if isCloser {
+				defer closing.Done()
+				defer func() {
+					if err := cl.close(); err != nil {
+						fmt.Fprintf(os.Stderr, "Error stopping %s collector: %v\n", c.name, err)
+					}
+				}()
+			}
+
+			ticker := time.NewTicker(every)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if ctx.Err() != nil {
+						return
+					}
+					timestamp := time.Now().UTC().Format(time.RFC3339)
+					if err := a.collect(c, timestamp); err != nil {
+						fmt.Fprintf(os.Stderr, "Error collecting metrics: %v\n", err)
+					}
+				}
+			}
+		}()
 	}
 
-	numCPU, err := cpu.Counts(true)
-	if err != nil {
-		numCPU = 0 // fallback if we can't get the count
+	return func() {
+		cancel()
+		for _, c := range listeners {
+			if err := c.collector.(listener).close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error stopping %s collector: %v\n", c.name, err)
+			}
+			timestamp := time.Now().UTC().Format(time.RFC3339)
+			if err := a.collect(c, timestamp); err != nil {
+				fmt.Fprintf(os.Stderr, "Error collecting metrics: %v\n", err)
+			}
+		}
+		if !waitTimeout(&closing, collectorStopTimeout) {
+			fmt.Fprintln(os.Stderr, "Error stopping collectors: timed out waiting for collections to finish")
+		}
 	}
+}
 
-	cpuPayload := cpuPayload{
-		Ts:          timestamp,
-		Event:       "report.system.cpu",
-		Host:        hostname,
-		UsedPercent: usedPercent,
-		LoadAvg1:    loadAvg.Load1,
-		LoadAvg5:    loadAvg.Load5,
-		LoadAvg15:   loadAvg.Load15,
-		NumCPUs:     numCPU,
+// waitTimeout waits for wg and reports whether it finished within d.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
 	}
-	if err := sendMetric(cpuPayload, tags); err != nil {
-		return fmt.Errorf("error sending CPU metrics: %w", err)
+}
+
+// collect runs a single collection and queues whatever it produced, even if
+// it also returned an error. The outcome is recorded for the health event.
+func (a *agent) collect(c scheduledCollector, timestamp string) error {
+	cp, isCheckpointer := c.collector.(checkpointer)
+	if isCheckpointer {
+		a.delivering.Lock()
+		defer a.delivering.Unlock()
 	}
 
-	// Collect and send memory metrics
-	virtualMem, err := mem.VirtualMemory()
+	payloads, err := c.collector.collect(timestamp)
+	a.mu.Lock()
+	a.pending = append(a.pending, payloads...)
+	if isCheckpointer {
+		a.checkpoints = append(a.checkpoints, cp.checkpoint())
+	}
+	a.collectorRuns[c.name] = time.Now()
 	if err != nil {
-		return fmt.Errorf("error getting memory metrics: %w", err)
+		a.collectorErrors[c.name] = err.Error()
+	} else {
+		delete(a.collectorErrors, c.name)
 	}
-
-	memoryPayload := memoryPayload{
-		Ts:          timestamp,
-		Event:       "report.system.memory",
-		Host:        hostname,
-		Total:       virtualMem.Total,
-		Used:        virtualMem.Used,
-		Free:        virtualMem.Free,
-		Available:   virtualMem.Available,
-		UsedPercent: math.Round(virtualMem.UsedPercent*100) / 100,
+	a.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s collector: %w", c.name, err)
 	}
-	if err := sendMetric(memoryPayload, tags); err != nil {
-		return fmt.Errorf("error sending memory metrics: %w", err)
+	return nil
+}
+
+// flush delivers everything the collectors have queued as one batch, along
+// with a health event describing the collectors. Collector checkpoints are
+// then committed, or rolled back if the batch was lost.
+func (a *agent) flush(timestamp string) error {
+	a.delivering.Lock()
+	defer a.delivering.Unlock()
+
+	a.mu.Lock()
+	payloads := append(a.pending, a.healthPayload(timestamp))
+	checkpoints := a.checkpoints
+	a.pending = nil
+	a.checkpoints = nil
+	a.mu.Unlock()
+
+	err := a.deliver(payloads)
+	errs := []error{err}
+	for _, cp := range checkpoints {
+		if err != nil {
+			cp.rollback()
+		} else if cerr := cp.commit(); cerr != nil {
+			errs = append(errs, cerr)
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	// Collect and send disk metrics
-	parts, err := disk.Partitions(false)
-	if err != nil {
-		return fmt.Errorf("error getting disk partitions: %w", err)
+// checkIn reports the agent's check-in, if it has one, when the latest
+// reporting cycle delivered its events. If the agent stops or can't reach
+// Honeybadger, the check-in goes missing and Honeybadger alerts. It's sent
+// in the background so that a slow response doesn't hold up reporting, and
+// skipped while the previous one is still being sent.
+func (a *agent) checkIn() {
+	a.mu.Lock()
+	delivered := a.delivery.failures == 0
+	a.mu.Unlock()
+	url := a.heartbeatURL
+	if url == "" || a.sink != nil || !delivered {
+		return
+	}
+	if !a.checkingIn.CompareAndSwap(false, true) {
+		return
 	}
+	go func() {
+		defer a.checkingIn.Store(false)
+		if err := sendCheckIn(url); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reporting check-in: %v\n", err)
+		}
+	}()
+}
 
-	// Send metrics for each disk partition
-	for _, part := range parts {
-		// Skip pseudo filesystems
-		if part.Fstype == "devfs" || part.Fstype == "autofs" || part.Fstype == "nullfs" ||
-			part.Fstype == "squashfs" ||
-			strings.HasPrefix(part.Fstype, "fuse.") ||
-			strings.Contains(part.Mountpoint, "/System/Volumes") {
-			continue
+// healthPayload reports which collectors are enabled and which of them
+// failed on their most recent run, and why. The caller must hold a.mu.
+func (a *agent) healthPayload(timestamp string) agentHealthPayload {
+	payload := agentHealthPayload{
+		Ts:               timestamp,
+		Event:            "report.agent.health",
+		Host:             a.hostname,
+		Collectors:       make([]string, 0, len(a.collectors)),
+		FailedCollectors: []string{},
+	}
+	for _, c := range a.collectors {
+		payload.Collectors = append(payload.Collectors, c.name)
+		if msg, ok := a.collectorErrors[c.name]; ok {
+			payload.FailedCollectors = append(payload.FailedCollectors, c.name)
+			if payload.CollectorErrors == nil {
+				payload.CollectorErrors = make(map[string]string)
+			}
+			payload.CollectorErrors[c.name] = msg
 		}
+	}
+	payload.Healthy = len(payload.FailedCollectors) == 0
+	return payload
+}
 
-		usage, err := disk.Usage(part.Mountpoint)
-		if err != nil {
-			// Log error but continue with other partitions
-			fmt.Fprintf(os.Stderr, "Error getting disk usage for %s: %v\n", part.Mountpoint, err)
-			continue
+// onceSampleDelay is how long --once waits between the baseline sample of
+// collectors that report rates and the collection that reports them.
+const onceSampleDelay = time.Second
+
+// prime records a baseline for every collector that reports rates, and
+// returns whether there were any. Errors are reported by the collection
+// that follows instead.
+func (a *agent) prime() bool {
+	primed := false
+	for _, c := range a.collectors {
+		if p, ok := c.collector.(primer); ok {
+			_ = p.prime()
+			primed = true
 		}
+	}
+	return primed
+}
+
+// reportMetrics runs every collector once, concurrently, and delivers the
+// results as one batch.
+func (a *agent) reportMetrics() error {
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	errs := make([]error, len(a.collectors))
+	var wg sync.WaitGroup
+	for i, c := range a.collectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = a.collect(c, timestamp)
+		}()
+	}
+	wg.Wait()
+
+	if err := a.flush(timestamp); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-		diskPayload := diskPayload{
-			Ts:          timestamp,
-			Event:       "report.system.disk",
-			Host:        hostname,
-			Mountpoint:  part.Mountpoint,
-			Device:      part.Device,
-			Fstype:      part.Fstype,
-			Total:       usage.Total,
-			Used:        usage.Used,
-			Free:        usage.Free,
-			UsedPercent: math.Round(usage.UsedPercent*100) / 100,
+// deliver sends payloads to every destination, or writes them to the sink
+// instead when there is one.
+func (a *agent) deliver(payloads []any) error {
+	if a.sink != nil {
+		for _, d := range a.destinations {
+			events, err := buildEvents(payloads, d.tags)
+			if err != nil {
+				return err
+			}
+			if err := writeEvents(a.sink, events); err != nil {
+				a.recordDelivery(err)
+				return err
+			}
 		}
-		if err := sendMetric(diskPayload, tags); err != nil {
-			return fmt.Errorf("error sending disk metrics for %s: %w", part.Mountpoint, err)
+		a.recordDelivery(nil)
+		return nil
+	}
+
+	sendErr, err := a.deliverAll(payloads)
+	a.recordDelivery(sendErr)
+	return err
+}
+
+// buildEvents builds the event for each payload with tags merged in.
+func buildEvents(payloads []any, tags map[string]string) ([][]byte, error) {
+	events := make([][]byte, 0, len(payloads))
+	for _, payload := range payloads {
+		event, err := buildEvent(payload, tags)
+		if err != nil {
+			return nil, err
 		}
+		events = append(events, event)
 	}
+	return events, nil
+}
 
+// writeEvents writes events to w as newline-delimited JSON.
+func writeEvents(w io.Writer, events [][]byte) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		buf.Write(event)
+		buf.WriteByte('\n')
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing events: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,379 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/spf13/viper"
+)
+
+// collectorNames are the built-in collectors that can be configured under
+// "agent.collectors".
+var collectorNames = []string{"cpu", "memory", "disk", "diskio", "process", "container", "network", "prometheus", "statsd", "syslog", "logs", "otlp", "host"}
+
+// defaultFstypeExclude and defaultMountpointExclude skip pseudo and system
+// filesystems unless the config file provides its own exclude lists.
+var (
+	defaultFstypeExclude     = []string{"devfs", "autofs", "nullfs", "squashfs", "fuse.*"}
+	defaultMountpointExclude = []string{"/System/Volumes/**"}
+)
+
+// nameFilter matches names against glob patterns. A name passes if it matches
+// any include pattern (or there are none) and no exclude pattern. A pattern
+// ending in "/**" matches a path and everything below it.
+type nameFilter struct {
+	include []string
+	exclude []string
+}
+
+func (f nameFilter) match(name string) bool {
+	for _, pattern := range f.exclude {
+		if matchPattern(pattern, name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether name matches the glob pattern, or for a
+// pattern ending in "/**", whether name or any of its parents matches the
+// rest of it.
+func matchPattern(pattern, name string) bool {
+	prefix, recursive := strings.CutSuffix(pattern, "/**")
+	if !recursive {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	for {
+		if ok, _ := path.Match(prefix, name); ok {
+			return true
+		}
+		parent := path.Dir(name)
+		if parent == name {
+			return false
+		}
+		name = parent
+	}
+}
+
+// loadNameFilter reads "<key>.include" and "<key>.exclude" from the config
+// file. defaultExclude is used when no exclude list is configured.
+func loadNameFilter(key string, defaultExclude []string) (nameFilter, error) {
+	f := nameFilter{
+		include: viper.GetStringSlice(key + ".include"),
+		exclude: defaultExclude,
+	}
+	if viper.IsSet(key + ".exclude") {
+		f.exclude = viper.GetStringSlice(key + ".exclude")
+	}
+	for _, pattern := range append(f.include, f.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nameFilter{}, fmt.Errorf("invalid pattern %q in %s: %w", pattern, key, err)
+		}
+	}
+	return f, nil
+}
+
+// diskUsageTimeout is how long the disk collector waits on a single
+// mountpoint before giving up on it for the current collection.
+const diskUsageTimeout = 10 * time.Second
+
+var diskUsage = disk.Usage // injectable for testing
+
+// collector gathers one kind of metric. Each collector runs on its own
+// goroutine, one collection at a time, so it can keep state between
+// collections without locking.
+type collector interface {
+	collect(timestamp string) ([]any, error)
+}
+
+// listener is implemented by collectors that receive metrics pushed to the
+// agent instead of polling for them.
+type listener interface {
+	// listen starts receiving in the background.
+	listen() error
+	// close stops receiving. Anything already received is still returned
+	// by the next collection.
+	close() error
+}
+
+// closer is implemented by collectors that hold resources between
+// collections. close is called once the collector has stopped.
+type closer interface {
+	close() error
+}
+
+// primer is implemented by collectors that report rates between
+// collections, and so report nothing the first time. prime records the
+// baseline so that the next collection reports rates.
+type primer interface {
+	prime() error
+}
+
+// checkpointer is implemented by collectors that track how far they've
+// read, such as through log files. Their progress is only saved once the
+// events collected up to it have been delivered or spooled, so none are
+// lost if the agent stops or delivery fails.
+type checkpointer interface {
+	// checkpoint returns the collector's progress as of its latest
+	// collection.
+	checkpoint() checkpoint
+}
+
+// checkpoint is a collector's progress as of one collection.
+type checkpoint interface {
+	// commit saves the progress once the collection's events are safe.
+	commit() error
+	// rollback rewinds the collector to its last commit after the events
+	// were lost, so they're collected again.
+	rollback()
+}
+
+// collectorConfig is a collector's entry under "agent.collectors". A zero
+// interval means the collector runs on the agent's reporting interval.
+type collectorConfig struct {
+	enabled  bool
+	interval time.Duration
+}
+
+// scheduledCollector is an enabled collector along with how often it runs.
+type scheduledCollector struct {
+	name      string
+	interval  time.Duration
+	collector collector
+}
+
+// loadCollectorConfigs reads the "agent.collectors" section of the config
+// file. Collectors that aren't listed are enabled on the agent's interval.
+func loadCollectorConfigs() (map[string]collectorConfig, error) {
+	for name := range viper.GetStringMap("agent.collectors") {
+		if !slices.Contains(collectorNames, name) {
+			return nil, fmt.Errorf("unknown collector %q in agent.collectors", name)
+		}
+	}
+
+	configs := make(map[string]collectorConfig, len(collectorNames))
+	for _, name := range collectorNames {
+		key := "agent.collectors." + name
+		cfg := collectorConfig{enabled: true}
+		if viper.IsSet(key + ".enabled") {
+			cfg.enabled = viper.GetBool(key + ".enabled")
+		}
+		if viper.IsSet(key + ".interval") {
+			seconds := viper.GetInt(key + ".interval")
+			if seconds <= 0 {
+				return nil, fmt.Errorf(
+					"invalid interval %d for collector %q: must be a positive number of seconds",
+					seconds, name,
+				)
+			}
+			cfg.interval = time.Duration(seconds) * time.Second
+		}
+		configs[name] = cfg
+	}
+	return configs, nil
+}
+
+// newCollectors builds the enabled collectors for settings. Collectors
+// missing from settings.collectors are enabled.
+func newCollectors(hostname string, settings agentSettings) []scheduledCollector {
+	builtin := map[string]collector{
+		"cpu":    &cpuCollector{hostname: hostname},
+		"memory": &memoryCollector{hostname: hostname},
+		"disk": &diskCollector{
+			hostname:    hostname,
+			fstypes:     settings.fstypeFilter,
+			mountpoints: settings.mountpointFilter,
+			timeout:     diskUsageTimeout,
+		},
+		"diskio":     &diskIOCollector{hostname: hostname, filter: settings.diskIOFilter},
+		"process":    &processCollector{hostname: hostname, rules: settings.processRules},
+		"container":  newContainerCollector(hostname, settings.containerFilter),
+		"network":    &networkCollector{hostname: hostname, filter: settings.networkFilter},
+		"prometheus": newPrometheusCollector(hostname, settings.prometheusTargets),
+		"statsd":     newStatsdCollector(hostname, settings.statsdAddress),
+		"syslog":     newSyslogCollector(hostname, settings.syslog),
+		"logs":       newLogCollector(hostname, settings.logSources, settings.logStatePath),
+		"otlp":       newOTLPCollector(hostname, settings.otlpAddress),
+		"host":       &hostCollector{hostname: hostname, read: readHostInfo},
+	}
+
+	var collectors []scheduledCollector
+	for _, name := range collectorNames {
+		cfg, ok := settings.collectors[name]
+		if ok && !cfg.enabled {
+			continue
+		}
+		collectors = append(collectors, scheduledCollector{
+			name:      name,
+			interval:  cfg.interval,
+			collector: builtin[name],
+		})
+	}
+	return collectors
+}
+
+// cpuCollector reports CPU usage and load averages.
+type cpuCollector struct {
+	hostname string
+}
+
+func (c *cpuCollector) collect(timestamp string) ([]any, error) {
+	cpuPercent, err := cpu.Percent(time.Second, false)
+	var usedPercent float64
+	if err != nil {
+		// cpu.Percent may fail on macOS with CGO_ENABLED=0; use -1 to indicate unavailable
+		usedPercent = -1
+	} else if len(cpuPercent) > 0 {
+		usedPercent = math.Round(cpuPercent[0]*100) / 100
+	}
+
+	loadAvg, err := load.Avg()
+	if err != nil {
+		return nil, fmt.Errorf("error getting load average: %w", err)
+	}
+
+	numCPU, err := cpu.Counts(true)
+	if err != nil {
+		numCPU = 0 // fallback if we can't get the count
+	}
+
+	return []any{cpuPayload{
+		Ts:          timestamp,
+		Event:       "report.system.cpu",
+		Host:        c.hostname,
+		UsedPercent: usedPercent,
+		LoadAvg1:    loadAvg.Load1,
+		LoadAvg5:    loadAvg.Load5,
+		LoadAvg15:   loadAvg.Load15,
+		NumCPUs:     numCPU,
+	}}, nil
+}
+
+// memoryCollector reports virtual memory usage.
+type memoryCollector struct {
+	hostname string
+}
+
+func (c *memoryCollector) collect(timestamp string) ([]any, error) {
+	virtualMem, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, fmt.Errorf("error getting memory metrics: %w", err)
+	}
+
+	return []any{memoryPayload{
+		Ts:          timestamp,
+		Event:       "report.system.memory",
+		Host:        c.hostname,
+		Total:       virtualMem.Total,
+		Used:        virtualMem.Used,
+		Free:        virtualMem.Free,
+		Available:   virtualMem.Available,
+		UsedPercent: math.Round(virtualMem.UsedPercent*100) / 100,
+	}}, nil
+}
+
+// diskCollector reports usage for each mounted filesystem that passes the
+// filesystem type and mountpoint filters.
+type diskCollector struct {
+	hostname    string
+	fstypes     nameFilter
+	mountpoints nameFilter
+	timeout     time.Duration
+
+	// stalled holds mountpoints whose last usage call hasn't returned yet.
+	// They're skipped until it does, so a hung mount doesn't pile up
+	// goroutines.
+	mu      sync.Mutex
+	stalled map[string]bool
+}
+
+func (c *diskCollector) collect(timestamp string) ([]any, error) {
+	parts, err := disk.Partitions(false)
+	if err != nil {
+		return nil, fmt.Errorf("error getting disk partitions: %w", err)
+	}
+
+	var payloads []any
+	for _, part := range parts {
+		if !c.fstypes.match(part.Fstype) || !c.mountpoints.match(part.Mountpoint) {
+			continue
+		}
+
+		usage, err := c.usage(part.Mountpoint)
+		if err != nil {
+			// Log error but continue with other partitions
+			fmt.Fprintf(os.Stderr, "Error getting disk usage for %s: %v\n", part.Mountpoint, err)
+			continue
+		}
+
+		payloads = append(payloads, diskPayload{
+			Ts:          timestamp,
+			Event:       "report.system.disk",
+			Host:        c.hostname,
+			Mountpoint:  part.Mountpoint,
+			Device:      part.Device,
+			Fstype:      part.Fstype,
+			Total:       usage.Total,
+			Used:        usage.Used,
+			Free:        usage.Free,
+			UsedPercent: math.Round(usage.UsedPercent*100) / 100,
+		})
+	}
+	return payloads, nil
+}
+
+// usage returns disk usage for mountpoint, giving up after c.timeout so an
+// unresponsive mount (such as a hung NFS server) can't stall the collector.
+func (c *diskCollector) usage(mountpoint string) (*disk.UsageStat, error) {
+	c.mu.Lock()
+	if c.stalled[mountpoint] {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("still waiting on a previous usage request")
+	}
+	if c.stalled == nil {
+		c.stalled = make(map[string]bool)
+	}
+	c.stalled[mountpoint] = true
+	c.mu.Unlock()
+
+	type result struct {
+		usage *disk.UsageStat
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		usage, err := diskUsage(mountpoint)
+		c.mu.Lock()
+		delete(c.stalled, mountpoint)
+		c.mu.Unlock()
+		done <- result{usage, err}
+	}()
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.usage, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out after %s", c.timeout)
+	}
+}
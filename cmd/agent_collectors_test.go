@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// funcCollector adapts a function to the collector interface.
+type funcCollector func(timestamp string) ([]any, error)
+
+func (f funcCollector) collect(timestamp string) ([]any, error) {
+	return f(timestamp)
+}
+
+func TestLoadCollectorConfigs(t *testing.T) {
+	t.Run("enables every collector by default", func(t *testing.T) {
+		viper.Reset()
+		configs, err := loadCollectorConfigs()
+		require.NoError(t, err)
+		for _, name := range collectorNames {
+			assert.Equal(t, collectorConfig{enabled: true}, configs[name], name)
+		}
+	})
+
+	t.Run("disables collectors and sets intervals", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.collectors.process.enabled", false)
+		viper.Set("agent.collectors.disk.interval", 300)
+		configs, err := loadCollectorConfigs()
+		require.NoError(t, err)
+		assert.False(t, configs["process"].enabled)
+		assert.Equal(t, collectorConfig{enabled: true, interval: 5 * time.Minute}, configs["disk"])
+		assert.Equal(t, collectorConfig{enabled: true}, configs["cpu"])
+	})
+
+	t.Run("rejects unknown collectors", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.collectors.gpu.enabled", true)
+		_, err := loadCollectorConfigs()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown collector "gpu"`)
+	})
+
+	t.Run("rejects non-positive intervals", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.collectors.cpu.interval", 0)
+		_, err := loadCollectorConfigs()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid interval")
+	})
+}
+
+func TestNewCollectors(t *testing.T) {
+	collectors := newCollectors("test-host", agentSettings{
+		collectors: map[string]collectorConfig{
+			"cpu":     {enabled: true, interval: 10 * time.Second},
+			"process": {enabled: false},
+		},
+	})
+
+	var names []string
+	for _, c := range collectors {
+		names = append(names, c.name)
+		require.NotNil(t, c.collector)
+	}
+	assert.Equal(t, []string{"cpu", "memory", "disk", "diskio", "container", "network", "prometheus", "statsd", "syslog", "logs", "otlp", "host"}, names)
+	assert.Equal(t, 10*time.Second, collectors[0].interval)
+	assert.Zero(t, collectors[1].interval, "unconfigured collectors use the agent interval")
+}
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  nameFilter
+		matches []string
+		skips   []string
+	}{
+		{
+			name:    "empty filter matches everything",
+			filter:  nameFilter{},
+			matches: []string{"eth0", "lo"},
+		},
+		{
+			name:    "exclude patterns",
+			filter:  nameFilter{exclude: []string{"lo", "veth*"}},
+			matches: []string{"eth0", "lo0"},
+			skips:   []string{"lo", "veth1a2b3c"},
+		},
+		{
+			name:    "include patterns",
+			filter:  nameFilter{include: []string{"eth*", "en*"}},
+			matches: []string{"eth0", "en0"},
+			skips:   []string{"docker0", "lo"},
+		},
+		{
+			name:    "exclude wins over include",
+			filter:  nameFilter{include: []string{"eth*"}, exclude: []string{"eth1"}},
+			matches: []string{"eth0"},
+			skips:   []string{"eth1"},
+		},
+		{
+			name:    "recursive patterns",
+			filter:  nameFilter{exclude: []string{"/mnt/*/**"}},
+			matches: []string{"/", "/mnt", "/mntx/a"},
+			skips:   []string{"/mnt/a", "/mnt/a/b/c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range tt.matches {
+				assert.True(t, tt.filter.match(name), "expected %q to match", name)
+			}
+			for _, name := range tt.skips {
+				assert.False(t, tt.filter.match(name), "expected %q to be skipped", name)
+			}
+		})
+	}
+}
+
+func TestLoadNameFilter(t *testing.T) {
+	t.Run("uses default exclude list", func(t *testing.T) {
+		viper.Reset()
+		f, err := loadNameFilter("agent.network", defaultNetworkExclude)
+		require.NoError(t, err)
+		assert.Empty(t, f.include)
+		assert.Equal(t, defaultNetworkExclude, f.exclude)
+	})
+
+	t.Run("config replaces default exclude list", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.network.include", []string{"eth*"})
+		viper.Set("agent.network.exclude", []string{"veth*"})
+		f, err := loadNameFilter("agent.network", defaultNetworkExclude)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"eth*"}, f.include)
+		assert.Equal(t, []string{"veth*"}, f.exclude)
+	})
+
+	t.Run("rejects malformed patterns", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.network.exclude", []string{"[eth"})
+		_, err := loadNameFilter("agent.network", defaultNetworkExclude)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid pattern")
+	})
+}
+
+func TestDefaultDiskFilters(t *testing.T) {
+	fstypes := nameFilter{exclude: defaultFstypeExclude}
+	for _, fstype := range []string{"ext4", "xfs", "apfs", "nfs4"} {
+		assert.True(t, fstypes.match(fstype), "expected %q to be reported", fstype)
+	}
+	for _, fstype := range []string{"devfs", "autofs", "nullfs", "squashfs", "fuse.sshfs"} {
+		assert.False(t, fstypes.match(fstype), "expected %q to be skipped", fstype)
+	}
+
+	mountpoints := nameFilter{exclude: defaultMountpointExclude}
+	for _, mountpoint := range []string{"/", "/home", "/Volumes/Backup"} {
+		assert.True(t, mountpoints.match(mountpoint), "expected %q to be reported", mountpoint)
+	}
+	for _, mountpoint := range []string{
+		"/System/Volumes", "/System/Volumes/Data", "/System/Volumes/Update/mnt1",
+		"/System/Volumes/Data/home/user/mnt",
+	} {
+		assert.False(t, mountpoints.match(mountpoint), "expected %q to be skipped", mountpoint)
+	}
+}
+
+func TestDiskCollectorUsageTimeout(t *testing.T) {
+	originalDiskUsage := diskUsage
+	defer func() { diskUsage = originalDiskUsage }()
+
+	release := make(chan struct{})
+	var calls atomic.Int32
+	diskUsage = func(path string) (*disk.UsageStat, error) {
+		calls.Add(1)
+		<-release
+		return &disk.UsageStat{Path: path, Total: 100}, nil
+	}
+
+	c := &diskCollector{timeout: 10 * time.Millisecond}
+
+	_, err := c.usage("/mnt/nfs")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+
+	// The hung mount is skipped rather than queried again.
+	_, err = c.usage("/mnt/nfs")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "still waiting")
+	assert.Equal(t, int32(1), calls.Load())
+
+	close(release)
+	require.Eventually(t, func() bool {
+		usage, err := c.usage("/mnt/nfs")
+		return err == nil && usage.Total == 100
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAgentRunsCollectorsIndependently(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events := decodeEvents(t, r)
+		mu.Lock()
+		for _, event := range events {
+			received[event["event_type"].(string)]++
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+
+	release := make(chan struct{})
+	a := newAgent("test-host", nil, nil)
+	a.interval = 20 * time.Millisecond
+	a.collectors = []scheduledCollector{
+		{
+			name: "slow",
+			collector: funcCollector(func(ts string) ([]any, error) {
+				<-release
+				return []any{map[string]string{"ts": ts, "event_type": "test.slow"}}, nil
+			}),
+		},
+		{
+			name:     "fast",
+			interval: 5 * time.Millisecond,
+			collector: funcCollector(func(ts string) ([]any, error) {
+				return []any{map[string]string{"ts": ts, "event_type": "test.fast"}}, nil
+			}),
+		},
+		{
+			name: "failing",
+			collector: funcCollector(func(string) ([]any, error) {
+				return nil, errors.New("boom")
+			}),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.run(ctx, nil, nil) }()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return received["test.fast"] >= 5
+	}, 5*time.Second, 10*time.Millisecond, "fast collector was blocked by the slow one")
+
+	mu.Lock()
+	assert.Zero(t, received["test.slow"])
+	mu.Unlock()
+
+	// The slow collection finishes during shutdown and is still delivered.
+	close(release)
+	cancel()
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, received["test.slow"], 1)
+}
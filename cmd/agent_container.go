@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCgroupRoot = "/sys/fs/cgroup"
+	defaultDockerRoot = "/var/lib/docker"
+	// cgroupMaxDepth bounds how far below the cgroup root containers are
+	// looked for. Kubernetes pods are nested the deepest, at about five.
+	cgroupMaxDepth = 8
+)
+
+// containerCgroupPattern matches the cgroup directory of a container, as
+// created by Docker, containerd, CRI-O, and Podman with either the systemd
+// or cgroupfs cgroup driver, capturing the container ID.
+var containerCgroupPattern = regexp.MustCompile(`^(?:(?:docker|cri-containerd|crio|libpod)-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// cgroupSample is a cgroup's resource usage at one point in time. Counters
+// for controllers that aren't enabled in the cgroup are zero.
+type cgroupSample struct {
+	at            time.Time
+	cpuUsageUsec  uint64
+	memoryCurrent uint64
+	memoryMax     uint64 // zero when unlimited
+	readBytes     uint64
+	writeBytes    uint64
+	reads         uint64
+	writes        uint64
+	pids          uint64
+}
+
+// containerCollector reports resource usage from cgroup v2 for each
+// container and top-level systemd slice. CPU and I/O rates are computed
+// between collections, so they're left out the first time a cgroup is seen.
+// Hosts without cgroup v2 report nothing.
+type containerCollector struct {
+	hostname   string
+	filter     nameFilter
+	cgroupRoot string
+	dockerRoot string
+
+	previous map[string]cgroupSample
+	names    map[string]string
+}
+
+func newContainerCollector(hostname string, filter nameFilter) *containerCollector {
+	return &containerCollector{
+		hostname:   hostname,
+		filter:     filter,
+		cgroupRoot: defaultCgroupRoot,
+		dockerRoot: defaultDockerRoot,
+	}
+}
+
+func (c *containerCollector) collect(timestamp string) ([]any, error) {
+	if _, err := os.Stat(filepath.Join(c.cgroupRoot, "cgroup.controllers")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading cgroups: %w", err)
+	}
+
+	cgroups, err := findContainerCgroups(c.cgroupRoot)
+	if err != nil {
+		return nil, fmt.Errorf("error finding cgroups: %w", err)
+	}
+
+	now := time.Now()
+	current := make(map[string]cgroupSample, len(cgroups))
+	var payloads []any
+	var errs []error
+	for _, cgroup := range cgroups {
+		if !c.filter.match(cgroup) {
+			continue
+		}
+		sample, err := readCgroupSample(filepath.Join(c.cgroupRoot, filepath.FromSlash(cgroup)))
+		if err != nil {
+			// The cgroup may have been removed since it was found.
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("error reading cgroup %s: %w", cgroup, err))
+			}
+			continue
+		}
+		sample.at = now
+		current[cgroup] = sample
+
+		id := containerID(cgroup)
+		payload := cgroupPayload(c.hostname, timestamp, cgroup, sample)
+		payload.ContainerID = id
+		payload.ContainerName = c.containerName(id)
+		if prev, ok := c.previous[cgroup]; ok {
+			cgroupRates(&payload, prev, sample)
+		}
+		payloads = append(payloads, payload)
+	}
+	c.previous = current
+
+	return payloads, errors.Join(errs...)
+}
+
+func (c *containerCollector) prime() error {
+	_, err := c.collect("")
+	return err
+}
+
+// findContainerCgroups returns the paths, relative to root and starting with
+// "/", of container cgroups and top-level slices.
+func findContainerCgroups(root string) ([]string, error) {
+	var cgroups []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Cgroups come and go; skip any that disappear mid-walk.
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || path == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		cgroup := "/" + filepath.ToSlash(rel)
+		depth := strings.Count(cgroup, "/")
+
+		switch {
+		case containerCgroupPattern.MatchString(d.Name()):
+			cgroups = append(cgroups, cgroup)
+			return filepath.SkipDir
+		case depth == 1 && strings.HasSuffix(d.Name(), ".slice"):
+			cgroups = append(cgroups, cgroup)
+		case depth >= cgroupMaxDepth:
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return cgroups, err
+}
+
+// containerID returns the container ID from a container's cgroup path, or ""
+// if it isn't a container.
+func containerID(cgroup string) string {
+	if m := containerCgroupPattern.FindStringSubmatch(filepath.Base(cgroup)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// containerName looks up a Docker container's name from its config file.
+// Names are cached, since they can't change while the container runs.
+func (c *containerCollector) containerName(id string) string {
+	if id == "" {
+		return ""
+	}
+	if name, ok := c.names[id]; ok {
+		return name
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dockerRoot, "containers", id, "config.v2.json")) // #nosec G304
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		Name string `json:"Name"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil || config.Name == "" {
+		return ""
+	}
+
+	if c.names == nil {
+		c.names = make(map[string]string)
+	}
+	c.names[id] = strings.TrimPrefix(config.Name, "/")
+	return c.names[id]
+}
+
+// readCgroupSample reads a cgroup's cpu.stat, memory.current, memory.max,
+// io.stat, and pids.current files. Files for controllers that aren't enabled
+// are skipped.
+func readCgroupSample(dir string) (cgroupSample, error) {
+	var s cgroupSample
+	if _, err := os.Stat(dir); err != nil {
+		return s, err
+	}
+
+	cpuStat, err := readCgroupKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return s, err
+	}
+	s.cpuUsageUsec = cpuStat["usage_usec"]
+
+	if s.memoryCurrent, err = readCgroupValue(filepath.Join(dir, "memory.current")); err != nil {
+		return s, err
+	}
+	if s.memoryMax, err = readCgroupValue(filepath.Join(dir, "memory.max")); err != nil {
+		return s, err
+	}
+	if s.pids, err = readCgroupValue(filepath.Join(dir, "pids.current")); err != nil {
+		return s, err
+	}
+
+	// io.stat has a line per device, such as
+	// "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0".
+	f, err := os.Open(filepath.Join(dir, "io.stat")) // #nosec G304
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	defer f.Close() // nolint:errcheck
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for _, field := range fields[min(1, len(fields)):] {
+			key, value, _ := strings.Cut(field, "=")
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				s.readBytes += n
+			case "wbytes":
+				s.writeBytes += n
+			case "rios":
+				s.reads += n
+			case "wios":
+				s.writes += n
+			}
+		}
+	}
+	return s, scanner.Err()
+}
+
+// readCgroupValue reads a single-value cgroup file. A missing file, or a
+// value of "max", is zero.
+func readCgroupValue(path string) (uint64, error) {
+	data, err := os.ReadFile(path) // #nosec G304
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s", value, filepath.Base(path))
+	}
+	return n, nil
+}
+
+// readCgroupKeyValues reads a flat keyed cgroup file such as cpu.stat. A
+// missing file is empty.
+func readCgroupKeyValues(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path) // #nosec G304
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	return values, nil
+}
+
+// cgroupPayload reports the point-in-time values of a sample.
+func cgroupPayload(hostname, timestamp, cgroup string, s cgroupSample) containerPayload {
+	payload := containerPayload{
+		Ts:          timestamp,
+		Event:       "report.container",
+		Host:        hostname,
+		Cgroup:      cgroup,
+		MemoryBytes: s.memoryCurrent,
+		Pids:        s.pids,
+	}
+	if s.memoryMax > 0 {
+		limit := s.memoryMax
+		percent := math.Round(float64(s.memoryCurrent)/float64(limit)*10000) / 100
+		payload.MemoryLimitBytes = &limit
+		payload.MemoryUsedPercent = &percent
+	}
+	return payload
+}
+
+// cgroupRates adds CPU usage and I/O rates since prev to payload, unless a
+// counter went backwards.
+func cgroupRates(payload *containerPayload, prev, cur cgroupSample) {
+	seconds := cur.at.Sub(prev.at).Seconds()
+	if seconds <= 0 || cur.cpuUsageUsec < prev.cpuUsageUsec ||
+		cur.readBytes < prev.readBytes || cur.writeBytes < prev.writeBytes ||
+		cur.reads < prev.reads || cur.writes < prev.writes {
+		return
+	}
+
+	// 100% is one CPU core fully busy, as with process CPU usage.
+	cpuPercent := math.Round(float64(cur.cpuUsageUsec-prev.cpuUsageUsec)/(seconds*1e6)*10000) / 100
+	readBytes := perSecond(prev.readBytes, cur.readBytes, seconds)
+	writeBytes := perSecond(prev.writeBytes, cur.writeBytes, seconds)
+	reads := perSecond(prev.reads, cur.reads, seconds)
+	writes := perSecond(prev.writes, cur.writes, seconds)
+
+	payload.CPUPercent = &cpuPercent
+	payload.ReadBytesPerSec = &readBytes
+	payload.WriteBytesPerSec = &writeBytes
+	payload.ReadsPerSec = &reads
+	payload.WritesPerSec = &writes
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDockerID = "3f4e1a7b9c2d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7"
+	testCRIID    = "9a8b7c6d5e4f30211a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7081"
+)
+
+func newTestContainerCollector(filter nameFilter) *containerCollector {
+	c := newContainerCollector("test-host", filter)
+	c.cgroupRoot = filepath.Join("testdata", "cgroup")
+	c.dockerRoot = filepath.Join("testdata", "docker")
+	return c
+}
+
+func TestFindContainerCgroups(t *testing.T) {
+	cgroups, err := findContainerCgroups(filepath.Join("testdata", "cgroup"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"/kubepods.slice",
+		"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod5f1b2c3d.slice/cri-containerd-" + testCRIID + ".scope",
+		"/system.slice",
+		"/system.slice/docker-" + testDockerID + ".scope",
+		"/user.slice",
+	}, cgroups)
+}
+
+func TestContainerCollector(t *testing.T) {
+	c := newTestContainerCollector(nameFilter{exclude: []string{"/user.slice"}})
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 4)
+
+	byCgroup := map[string]containerPayload{}
+	for _, p := range payloads {
+		payload := p.(containerPayload)
+		byCgroup[payload.Cgroup] = payload
+	}
+
+	limit := uint64(536870912)
+	percent := 19.53
+	assert.Equal(t, containerPayload{
+		Ts:                "2026-01-01T00:00:00Z",
+		Event:             "report.container",
+		Host:              "test-host",
+		Cgroup:            "/system.slice/docker-" + testDockerID + ".scope",
+		ContainerID:       testDockerID,
+		ContainerName:     "web-1",
+		MemoryBytes:       104857600,
+		MemoryLimitBytes:  &limit,
+		MemoryUsedPercent: &percent,
+		Pids:              12,
+	}, byCgroup["/system.slice/docker-"+testDockerID+".scope"])
+
+	slice := byCgroup["/system.slice"]
+	assert.Empty(t, slice.ContainerID)
+	assert.Nil(t, slice.MemoryLimitBytes, "unlimited memory has no limit")
+	assert.Equal(t, uint64(214), slice.Pids)
+
+	pod := byCgroup["/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod5f1b2c3d.slice/cri-containerd-"+testCRIID+".scope"]
+	assert.Equal(t, testCRIID, pod.ContainerID)
+	assert.Empty(t, pod.ContainerName, "only Docker container names are looked up")
+	assert.Equal(t, 100.0, *pod.MemoryUsedPercent)
+
+	assert.NotContains(t, byCgroup, "/user.slice")
+
+	// Rates are reported from the second collection.
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	for _, p := range payloads {
+		assert.NotNil(t, p.(containerPayload).CPUPercent)
+	}
+}
+
+func TestContainerCollectorPrime(t *testing.T) {
+	c := newTestContainerCollector(nameFilter{})
+	require.NoError(t, c.prime())
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.NotEmpty(t, payloads)
+	for _, p := range payloads {
+		assert.NotNil(t, p.(containerPayload).CPUPercent, "rates are reported after priming")
+	}
+}
+
+func TestReadCgroupSample(t *testing.T) {
+	sample, err := readCgroupSample(filepath.Join("testdata", "cgroup", "system.slice", "docker-"+testDockerID+".scope"))
+	require.NoError(t, err)
+	assert.Equal(t, cgroupSample{
+		cpuUsageUsec:  123456789,
+		memoryCurrent: 104857600,
+		memoryMax:     536870912,
+		readBytes:     2097152,
+		writeBytes:    2097152,
+		reads:         150,
+		writes:        200,
+		pids:          12,
+	}, sample)
+
+	// Controllers that aren't enabled leave their values at zero.
+	sample, err = readCgroupSample(filepath.Join("testdata", "cgroup", "user.slice"))
+	require.NoError(t, err)
+	assert.Equal(t, cgroupSample{cpuUsageUsec: 300000000, memoryCurrent: 734003200, pids: 87}, sample)
+}
+
+func TestCgroupRates(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := cgroupSample{at: start, cpuUsageUsec: 1_000_000, readBytes: 1000, writes: 10}
+	cur := cgroupSample{
+		at: start.Add(10 * time.Second), cpuUsageUsec: 16_000_000,
+		readBytes: 11240, writes: 60,
+	}
+
+	var payload containerPayload
+	cgroupRates(&payload, prev, cur)
+	require.NotNil(t, payload.CPUPercent)
+	assert.Equal(t, 150.0, *payload.CPUPercent, "1.5 cores busy")
+	assert.Equal(t, 1024.0, *payload.ReadBytesPerSec)
+	assert.Equal(t, 0.0, *payload.WriteBytesPerSec)
+	assert.Equal(t, 5.0, *payload.WritesPerSec)
+
+	// A counter that went backwards (the cgroup was recreated) skips rates.
+	payload = containerPayload{}
+	cgroupRates(&payload, cur, prev)
+	assert.Nil(t, payload.CPUPercent)
+}
+
+func TestContainerCollectorWithoutCgroupV2(t *testing.T) {
+	c := newContainerCollector("test-host", nameFilter{})
+	c.cgroupRoot = t.TempDir()
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Empty(t, payloads)
+
+	c.cgroupRoot = filepath.Join(c.cgroupRoot, "missing")
+	_, err = os.Stat(c.cgroupRoot)
+	require.True(t, os.IsNotExist(err))
+	payloads, err = c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Empty(t, payloads)
+}
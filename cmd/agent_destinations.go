@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// destinationNamePattern limits destination names to characters that are
+// safe in a spool directory name.
+var destinationNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// destinationConfig is an entry in the "agent.destinations" section of the
+// config file: a Honeybadger project the agent reports to. Endpoint defaults
+// to the global endpoint, and Tags are merged over the agent's tags for this
+// destination only.
+type destinationConfig struct {
+	Name     string            `mapstructure:"name"`
+	Endpoint string            `mapstructure:"endpoint"`
+	APIKey   string            `mapstructure:"api_key"`
+	Tags     map[string]string `mapstructure:"tags"`
+}
+
+// loadDestinationConfigs reads and validates the "agent.destinations"
+// section of the config file. Without it, the agent reports to the global
+// endpoint and API key.
+func loadDestinationConfigs() ([]destinationConfig, error) {
+	var configs []destinationConfig
+	if err := viper.UnmarshalKey("agent.destinations", &configs); err != nil {
+		return nil, fmt.Errorf("invalid agent.destinations config: %w", err)
+	}
+	if viper.IsSet("agent.destinations") && len(configs) == 0 {
+		// The global API key isn't required with destinations, so an empty
+		// list would leave the agent with nowhere to send events.
+		return nil, fmt.Errorf("invalid agent.destinations config: at least one destination is required")
+	}
+
+	names := make(map[string]bool, len(configs))
+	for i := range configs {
+		config := &configs[i]
+		if config.Name == "" {
+			return nil, fmt.Errorf("invalid agent.destinations entry %d: name is required", i+1)
+		}
+		if !destinationNamePattern.MatchString(config.Name) {
+			return nil, fmt.Errorf(
+				"invalid agent.destinations entry %q: name may only contain letters, digits, '.', '_', and '-'",
+				config.Name,
+			)
+		}
+		if strings.HasSuffix(config.Name, spoolSegmentExt) || strings.HasSuffix(config.Name, ".tmp") {
+			// The destination's spool directory would be mistaken for a
+			// segment of the spool it's in.
+			return nil, fmt.Errorf(
+				"invalid agent.destinations entry %q: name may not end in %s or .tmp",
+				config.Name, spoolSegmentExt,
+			)
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("duplicate agent.destinations entry %q", config.Name)
+		}
+		names[config.Name] = true
+
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("invalid agent.destinations entry %q: api_key is required", config.Name)
+		}
+		if config.Endpoint == "" {
+			config.Endpoint = viper.GetString("endpoint")
+		}
+		u, err := url.Parse(config.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf(
+				"invalid agent.destinations entry %q: endpoint must be an http or https URL",
+				config.Name,
+			)
+		}
+		for key := range config.Tags {
+			if reservedTagKeys[key] {
+				return nil, fmt.Errorf(
+					"invalid agent.destinations entry %q: %q is a reserved metric field and cannot be used as a tag key",
+					config.Name, key,
+				)
+			}
+		}
+	}
+	return configs, nil
+}
+
+// destination is somewhere the agent delivers events, with its own tags,
+// sender, and retry spool so that one failing doesn't affect the others.
+type destination struct {
+	name   string // empty for the default destination
+	tags   map[string]string
+	sender *eventSender
+	spool  *spool
+}
+
+// newDestinations builds the destinations for settings. Without any
+// configured, events go to the global endpoint and API key and are spooled
+// in a.spool; otherwise each destination spools in its own subdirectory of
+// it. Spools are kept across reloads so their retry backoff carries over.
+// Events spooled before destinations were configured are moved into every
+// destination's spool, since they'd otherwise never be sent.
+func (a *agent) newDestinations(settings agentSettings) []*destination {
+	if len(settings.destinations) == 0 {
+		return []*destination{{tags: settings.tags, sender: newEventSender(), spool: a.spool}}
+	}
+
+	destinations := make([]*destination, 0, len(settings.destinations))
+	var spools []*spool
+	for _, config := range settings.destinations {
+		sender := newEventSender()
+		sender.endpoint = config.Endpoint
+		sender.apiKey = config.APIKey
+		destinations = append(destinations, &destination{
+			name:   config.Name,
+			tags:   mergeTags(settings.tags, config.Tags),
+			sender: sender,
+			spool:  a.destinationSpool(config.Name),
+		})
+		if sp := destinations[len(destinations)-1].spool; sp != nil {
+			spools = append(spools, sp)
+		}
+	}
+
+	if len(spools) > 0 {
+		moved, err := a.spool.moveTo(spools)
+		if moved > 0 {
+			fmt.Fprintf(os.Stderr, "Moved %d spooled events to the spool of each destination\n", moved)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error moving spooled events to destinations: %v\n", err)
+		}
+	}
+	return destinations
+}
+
+// destinationSpool returns the spool for the named destination, creating it
+// the first time. It's nil when spooling is disabled or the spool directory
+// can't be created.
+func (a *agent) destinationSpool(name string) *spool {
+	if a.spool == nil {
+		return nil
+	}
+	if sp, ok := a.destinationSpools[name]; ok {
+		return sp
+	}
+	sp, err := newSpool(filepath.Join(a.spool.dir, name), a.spool.maxBytes, a.spool.maxAge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating spool for destination %s, events that fail to send will be dropped: %v\n", name, err)
+		return nil
+	}
+	if a.destinationSpools == nil {
+		a.destinationSpools = make(map[string]*spool)
+	}
+	a.destinationSpools[name] = sp
+	return sp
+}
+
+// deliverAll sends payloads to every destination at once and returns the
+// send error, for the delivery status, along with any error that left
+// events undelivered or unspooled. Errors are labeled with the destination
+// they came from.
+func (a *agent) deliverAll(payloads []any) (sendErr, err error) {
+	sendErrs := make([]error, len(a.destinations))
+	errs := make([]error, len(a.destinations))
+	var wg sync.WaitGroup
+	for i, d := range a.destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := buildEvents(payloads, d.tags)
+			if err != nil {
+				sendErrs[i], errs[i] = d.label(err), d.label(err)
+				return
+			}
+			sendErr, err := d.deliver(events)
+			sendErrs[i], errs[i] = d.label(sendErr), d.label(err)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(sendErrs...), errors.Join(errs...)
+}
+
+// deliver sends events as a batch. When the destination has a spool,
+// events that could not be delivered are spooled instead of failing, and
+// the spool is replayed once delivery works again. Events the endpoint
+// rejected permanently are dropped and reported here rather than in err.
+// sendErr is the error from sending events, whether or not they were then
+// spooled or dropped.
+func (d *destination) deliver(events [][]byte) (sendErr, err error) {
+	undelivered, sendErr := d.sender.send(events)
+	dropped, err := splitDropped(sendErr)
+	if dropped != nil {
+		fmt.Fprintf(os.Stderr, "Error sending metrics%s: %v\n", d.suffix(), dropped)
+	}
+	if d.spool == nil {
+		return sendErr, err
+	}
+	if len(undelivered) > 0 {
+		d.spool.recordFailure()
+		if serr := d.spool.write(undelivered); serr != nil {
+			return sendErr, errors.Join(sendErr, fmt.Errorf("error spooling metrics: %w", serr))
+		}
+		fmt.Fprintf(os.Stderr, "Spooled %d events for retry%s: %v\n", len(undelivered), d.suffix(), err)
+		return sendErr, nil
+	}
+	if len(events) > 0 {
+		d.spool.recordSuccess()
+	}
+
+	sent, rerr := d.spool.replay(d.sender.send)
+	if sent > 0 {
+		fmt.Fprintf(os.Stderr, "Replayed %d spooled events%s\n", sent, d.suffix())
+	}
+	if rerr != nil {
+		return sendErr, errors.Join(err, fmt.Errorf("error replaying spooled metrics: %w", rerr))
+	}
+	return sendErr, err
+}
+
+// label prefixes err with the destination's name, if it has one.
+func (d *destination) label(err error) error {
+	if err == nil || d.name == "" {
+		return err
+	}
+	return fmt.Errorf("destination %s: %w", d.name, err)
+}
+
+// suffix names the destination in progress messages, if it has a name.
+func (d *destination) suffix() string {
+	if d.name == "" {
+		return ""
+	}
+	return " (destination " + d.name + ")"
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDestinationConfigs(t *testing.T) {
+	t.Run("defaults to no destinations", func(t *testing.T) {
+		viper.Reset()
+		configs, err := loadDestinationConfigs()
+		require.NoError(t, err)
+		assert.Empty(t, configs)
+	})
+
+	t.Run("loads destinations", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("endpoint", "https://api.honeybadger.io")
+		viper.Set("agent.destinations", []any{
+			map[string]any{"name": "us", "api_key": "us-key"},
+			map[string]any{
+				"name":     "eu",
+				"endpoint": "https://eu-api.honeybadger.io",
+				"api_key":  "eu-key",
+				"tags":     map[string]any{"region": "eu", "replica": 2},
+			},
+		})
+
+		configs, err := loadDestinationConfigs()
+		require.NoError(t, err)
+		assert.Equal(t, []destinationConfig{
+			{Name: "us", Endpoint: "https://api.honeybadger.io", APIKey: "us-key"},
+			{
+				Name:     "eu",
+				Endpoint: "https://eu-api.honeybadger.io",
+				APIKey:   "eu-key",
+				Tags:     map[string]string{"region": "eu", "replica": "2"},
+			},
+		}, configs)
+	})
+
+	for _, tt := range []struct {
+		name        string
+		destination map[string]any
+		wantErr     string
+	}{
+		{"missing name", map[string]any{"api_key": "key"}, "entry 1: name is required"},
+		{"invalid name", map[string]any{"name": "../us", "api_key": "key"}, "name may only contain"},
+		{
+			"name of a spool segment",
+			map[string]any{"name": "1700000000-3.ndjson", "api_key": "key"},
+			"name may not end in .ndjson or .tmp",
+		},
+		{"missing API key", map[string]any{"name": "us"}, `"us": api_key is required`},
+		{
+			"invalid endpoint",
+			map[string]any{"name": "us", "api_key": "key", "endpoint": "api.honeybadger.io"},
+			"endpoint must be an http or https URL",
+		},
+		{
+			"reserved tag",
+			map[string]any{"name": "us", "api_key": "key", "tags": map[string]any{"ts": "x"}},
+			`"ts" is a reserved metric field`,
+		},
+	} {
+		t.Run("rejects "+tt.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set("endpoint", "https://api.honeybadger.io")
+			viper.Set("agent.destinations", []any{tt.destination})
+			_, err := loadDestinationConfigs()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	t.Run("rejects an empty list", func(t *testing.T) {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		require.NoError(t, viper.ReadConfig(strings.NewReader("agent:\n  destinations: []\n")))
+		_, err := loadDestinationConfigs()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "at least one destination is required")
+	})
+
+	t.Run("rejects duplicate names", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.destinations", []any{
+			map[string]any{"name": "us", "api_key": "key", "endpoint": "https://api.honeybadger.io"},
+			map[string]any{"name": "us", "api_key": "key", "endpoint": "https://api.honeybadger.io"},
+		})
+		_, err := loadDestinationConfigs()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `duplicate agent.destinations entry "us"`)
+	})
+}
+
+func TestDestinationDropsRejectedEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	sp, err := newSpool(t.TempDir(), defaultSpoolMaxBytes, defaultSpoolMaxAge)
+	require.NoError(t, err)
+	d := &destination{
+		sender: &eventSender{endpoint: server.URL, maxBytes: defaultBatchMaxBytes, client: server.Client()},
+		spool:  sp,
+	}
+
+	sendErr, err := d.deliver(testEvents(2))
+	require.NoError(t, err)
+	require.Error(t, sendErr)
+	assert.Contains(t, sendErr.Error(), "422")
+	assert.Equal(t, 0, sp.depth(), "rejected events were spooled")
+}
+
+func TestAgentDestinationsMoveSpool(t *testing.T) {
+	viper.Reset()
+	sp, err := newSpool(t.TempDir(), defaultSpoolMaxBytes, defaultSpoolMaxAge)
+	require.NoError(t, err)
+	require.NoError(t, sp.write(testEvents(2)))
+	require.NoError(t, sp.write(testEvents(1)))
+
+	a := newAgent("test-host", nil, sp)
+	settings := defaultAgentSettings(nil)
+	settings.destinations = []destinationConfig{
+		{Name: "us", Endpoint: "https://api.honeybadger.io", APIKey: "us-key"},
+		{Name: "eu", Endpoint: "https://eu-api.honeybadger.io", APIKey: "eu-key"},
+	}
+	a.apply(settings)
+
+	assert.Equal(t, 0, sp.depth())
+	for _, d := range a.destinations {
+		assert.Equal(t, 3, d.spool.depth(), d.name)
+	}
+}
+
+func TestAgentDestinations(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]map[string]any{}
+	var euFailing atomic.Bool
+	euFailing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "eu-key" && euFailing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		events := decodeEvents(t, r)
+		mu.Lock()
+		received[key] = append(received[key], events...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	dir := t.TempDir()
+	sp, err := newSpool(dir, defaultSpoolMaxBytes, defaultSpoolMaxAge)
+	require.NoError(t, err)
+
+	a := newAgent("test-host", nil, sp)
+	settings := defaultAgentSettings(map[string]string{"environment": "production", "region": "unknown"})
+	settings.destinations = []destinationConfig{
+		{Name: "us", Endpoint: server.URL, APIKey: "us-key", Tags: map[string]string{"region": "us"}},
+		{Name: "eu", Endpoint: server.URL, APIKey: "eu-key", Tags: map[string]string{"region": "eu"}},
+	}
+	a.apply(settings)
+	a.collectors = []scheduledCollector{
+		{name: "test", collector: funcCollector(func(timestamp string) ([]any, error) {
+			return []any{map[string]string{"ts": timestamp, "event_type": "test", "host": "test-host"}}, nil
+		})},
+	}
+
+	t.Run("a failing destination spools without affecting the others", func(t *testing.T) {
+		require.NoError(t, a.reportMetrics())
+
+		mu.Lock()
+		require.Len(t, received["us-key"], 2)
+		for _, event := range received["us-key"] {
+			assert.Equal(t, "us", event["region"])
+			assert.Equal(t, "production", event["environment"])
+		}
+		assert.Empty(t, received["eu-key"])
+		mu.Unlock()
+
+		assert.Equal(t, 0, a.destinations[0].spool.depth())
+		assert.Equal(t, 2, a.destinations[1].spool.depth())
+		assert.Equal(t, filepath.Join(dir, "eu"), a.destinations[1].spool.dir)
+
+		status := a.status()
+		assert.False(t, status.Healthy)
+		assert.Contains(t, status.LastSendError, "destination eu:")
+		assert.Equal(t, 2, status.SpooledEvents)
+	})
+
+	t.Run("spools survive a reload", func(t *testing.T) {
+		euSpool := a.destinations[1].spool
+		a.apply(settings)
+		a.collectors = []scheduledCollector{
+			{name: "test", collector: funcCollector(func(timestamp string) ([]any, error) {
+				return []any{map[string]string{"ts": timestamp, "event_type": "test", "host": "test-host"}}, nil
+			})},
+		}
+		assert.Same(t, euSpool, a.destinations[1].spool)
+	})
+
+	t.Run("a recovered destination replays its spool", func(t *testing.T) {
+		euFailing.Store(false)
+
+		require.NoError(t, a.reportMetrics())
+
+		mu.Lock()
+		assert.Len(t, received["us-key"], 4)
+		require.Len(t, received["eu-key"], 4)
+		for _, event := range received["eu-key"] {
+			assert.Equal(t, "eu", event["region"])
+		}
+		mu.Unlock()
+		assert.Equal(t, 0, a.destinations[1].spool.depth())
+		assert.True(t, a.status().Healthy)
+	})
+}
@@ -0,0 +1,7 @@
+//go:build (darwin && cgo && !ios) || linux || freebsd || openbsd || netbsd || windows || solaris || aix
+
+package cmd
+
+// diskIOSupported reports whether gopsutil can read disk I/O counters on
+// this platform.
+const diskIOSupported = true
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskIORates(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := diskIOCounters{
+		at: start,
+		counters: map[string]disk.IOCountersStat{
+			"sda": {
+				Name: "sda", ReadBytes: 1 << 20, WriteBytes: 2 << 20,
+				ReadCount: 100, WriteCount: 200, IoTime: 1000, WeightedIO: 5000,
+			},
+			"sdb":   {Name: "sdb", ReadBytes: 5000},
+			"loop0": {Name: "loop0"},
+		},
+	}
+	current := diskIOCounters{
+		at: start.Add(10 * time.Second),
+		counters: map[string]disk.IOCountersStat{
+			"sda": {
+				Name: "sda", ReadBytes: 11 << 20, WriteBytes: 2<<20 + 5120,
+				ReadCount: 600, WriteCount: 250, IoTime: 3500, WeightedIO: 25000,
+				IopsInProgress: 4,
+			},
+			"sdb":   {Name: "sdb", ReadBytes: 10}, // counter reset
+			"loop0": {Name: "loop0", ReadBytes: 100},
+			"nvme0": {Name: "nvme0", ReadBytes: 100}, // new device
+		},
+	}
+
+	payloads := diskIORates(
+		"test-host", "2026-01-01T00:00:10Z", previous, current,
+		nameFilter{exclude: defaultDiskIOExclude},
+	)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, diskIOPayload{
+		Ts:               "2026-01-01T00:00:10Z",
+		Event:            "report.system.diskio",
+		Host:             "test-host",
+		Device:           "sda",
+		ReadBytesPerSec:  1 << 20,
+		WriteBytesPerSec: 512,
+		ReadsPerSec:      50,
+		WritesPerSec:     5,
+		IOTimePercent:    25,
+		AvgQueueDepth:    2,
+		InProgress:       4,
+	}, payloads[0])
+}
+
+func TestDiskIORatesCapsUtilization(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := diskIOCounters{
+		at:       start,
+		counters: map[string]disk.IOCountersStat{"sda": {Name: "sda"}},
+	}
+	current := diskIOCounters{
+		at:       start.Add(time.Second),
+		counters: map[string]disk.IOCountersStat{"sda": {Name: "sda", IoTime: 1500}},
+	}
+
+	payloads := diskIORates("test-host", "", previous, current, nameFilter{})
+	require.Len(t, payloads, 1)
+	assert.Equal(t, float64(100), payloads[0].(diskIOPayload).IOTimePercent)
+}
+
+func TestDiskIOCollector(t *testing.T) {
+	c := &diskIOCollector{hostname: "test-host"}
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Empty(t, payloads, "first collection should only record a baseline")
+
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	for _, p := range payloads {
+		payload := p.(diskIOPayload)
+		assert.Equal(t, "report.system.diskio", payload.Event)
+		assert.NotEmpty(t, payload.Device)
+		assert.GreaterOrEqual(t, payload.IOTimePercent, float64(0))
+		assert.LessOrEqual(t, payload.IOTimePercent, float64(100))
+	}
+}
+
+func TestDiskIOTagsAreReserved(t *testing.T) {
+	for _, key := range []string{"read_bytes_per_sec", "writes_per_sec", "avg_queue_depth"} {
+		_, err := parseTags([]string{key + "=foo"})
+		require.Error(t, err, "expected error for reserved key %q", key)
+	}
+}
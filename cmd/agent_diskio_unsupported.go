@@ -0,0 +1,8 @@
+//go:build !((darwin && cgo && !ios) || linux || freebsd || openbsd || netbsd || windows || solaris || aix)
+
+package cmd
+
+// diskIOSupported reports whether gopsutil can read disk I/O counters on
+// this platform. It can't on macOS without cgo, or on platforms it doesn't
+// support at all.
+const diskIOSupported = false
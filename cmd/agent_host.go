@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// hostCollector reports the host's OS, hardware, and agent version as a
+// "report.system.host" event when the agent starts and whenever any of them
+// change, such as after a kernel upgrade or a reboot.
+type hostCollector struct {
+	hostname string
+	read     func() (hostInfo, error)
+
+	last *hostInfo
+}
+
+func (c *hostCollector) collect(timestamp string) ([]any, error) {
+	info, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if c.last != nil && *c.last == info {
+		return nil, nil
+	}
+	c.last = &info
+
+	payload := hostPayload{
+		Ts:       timestamp,
+		Event:    "report.system.host",
+		Host:     c.hostname,
+		hostInfo: info,
+	}
+	if boot, err := time.Parse(time.RFC3339, info.BootTime); err == nil {
+		payload.UptimeSeconds = uint64(max(time.Since(boot), 0) / time.Second)
+	}
+	return []any{payload}, nil
+}
+
+// readHostInfo gathers the host's inventory. Details that aren't available
+// on the platform are left empty.
+func readHostInfo() (hostInfo, error) {
+	h, err := host.Info()
+	if err != nil {
+		return hostInfo{}, fmt.Errorf("error getting host info: %w", err)
+	}
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return hostInfo{}, fmt.Errorf("error getting memory metrics: %w", err)
+	}
+
+	info := hostInfo{
+		OS:                   h.OS,
+		Platform:             h.Platform,
+		PlatformFamily:       h.PlatformFamily,
+		PlatformVersion:      h.PlatformVersion,
+		KernelVersion:        h.KernelVersion,
+		KernelArch:           h.KernelArch,
+		MemoryTotal:          vm.Total,
+		BootTime:             time.Unix(int64(h.BootTime), 0).UTC().Format(time.RFC3339), // #nosec G115
+		VirtualizationSystem: h.VirtualizationSystem,
+		VirtualizationRole:   h.VirtualizationRole,
+		AgentVersion:         Version,
+		AgentCommit:          Commit,
+	}
+	if info.AgentVersion == "" {
+		info.AgentVersion = "dev"
+	}
+	if cpus, err := cpu.Info(); err == nil && len(cpus) > 0 {
+		info.CPUModel = strings.TrimSpace(cpus[0].ModelName)
+	}
+	if n, err := cpu.Counts(false); err == nil {
+		info.CPUCores = n
+	}
+	if n, err := cpu.Counts(true); err == nil {
+		info.CPUThreads = n
+	}
+	return info, nil
+}
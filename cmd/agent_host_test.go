@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostCollector(t *testing.T) {
+	info := hostInfo{
+		OS:              "linux",
+		Platform:        "ubuntu",
+		PlatformVersion: "24.04",
+		KernelVersion:   "6.8.0-45-generic",
+		CPUModel:        "AMD EPYC 7R13 Processor",
+		CPUCores:        4,
+		CPUThreads:      8,
+		MemoryTotal:     16 << 30,
+		BootTime:        time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+		AgentVersion:    "0.11.0",
+	}
+	var readErr error
+	c := &hostCollector{
+		hostname: "test-host",
+		read: func() (hostInfo, error) {
+			return info, readErr
+		},
+	}
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	payload := payloads[0].(hostPayload)
+	assert.Equal(t, "report.system.host", payload.Event)
+	assert.Equal(t, "test-host", payload.Host)
+	assert.InDelta(t, 3600, payload.UptimeSeconds, 5)
+
+	data, err := json.Marshal(payload)
+	require.NoError(t, err)
+	var event map[string]any
+	require.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, "6.8.0-45-generic", event["kernel_version"])
+	assert.Equal(t, "AMD EPYC 7R13 Processor", event["cpu_model"])
+	assert.Equal(t, float64(8), event["cpu_threads"])
+	assert.Equal(t, "0.11.0", event["agent_version"])
+	assert.NotContains(t, event, "agent_commit")
+	assert.NotContains(t, event, "virtualization_system")
+
+	t.Run("skips unchanged hosts", func(t *testing.T) {
+		payloads, err := c.collect("2026-01-01T00:01:00Z")
+		require.NoError(t, err)
+		assert.Empty(t, payloads)
+	})
+
+	t.Run("reports changes", func(t *testing.T) {
+		info.KernelVersion = "6.8.0-47-generic"
+		payloads, err := c.collect("2026-01-01T00:02:00Z")
+		require.NoError(t, err)
+		require.Len(t, payloads, 1)
+		assert.Equal(t, "6.8.0-47-generic", payloads[0].(hostPayload).KernelVersion)
+	})
+
+	t.Run("returns read errors", func(t *testing.T) {
+		readErr = errors.New("boom")
+		_, err := c.collect("2026-01-01T00:03:00Z")
+		assert.EqualError(t, err, "boom")
+	})
+}
+
+func TestAgentHostInventory(t *testing.T) {
+	var mu sync.Mutex
+	hostEvents := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, event := range decodeEvents(t, r) {
+			if event["event_type"] == "report.system.host" {
+				mu.Lock()
+				hostEvents++
+				mu.Unlock()
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+
+	settings := defaultAgentSettings(nil)
+	settings.interval = time.Hour
+	settings.collectors = map[string]collectorConfig{}
+	for _, name := range collectorNames {
+		settings.collectors[name] = collectorConfig{enabled: name == "host"}
+	}
+	a := newAgent("test-host", nil, nil)
+	a.apply(settings)
+
+	reload := make(chan os.Signal)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.run(ctx, reload, func() (agentSettings, error) { return settings, nil })
+	}()
+
+	// The inventory is reported at startup rather than after the first
+	// interval, and not again after a reload.
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return hostEvents == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	reload <- syscall.SIGHUP
+	cancel()
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, hostEvents)
+}
+
+func TestReadHostInfo(t *testing.T) {
+	info, err := readHostInfo()
+	require.NoError(t, err)
+	assert.NotEmpty(t, info.OS)
+	assert.NotEmpty(t, info.BootTime)
+	assert.Positive(t, info.MemoryTotal)
+	assert.Equal(t, "dev", info.AgentVersion)
+}
@@ -0,0 +1,600 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// logMaxLineBytes splits lines longer than this into several events.
+	logMaxLineBytes = 64 * 1024
+	// logMaxReadBytes caps how much of one file is read per collection, so a
+	// large backlog is shipped over several intervals. Files that were
+	// rotated or removed are read to the end, since they won't be read again.
+	logMaxReadBytes = 4 << 20
+	// logMaxEntryLines caps how many lines are joined into one multiline
+	// entry.
+	logMaxEntryLines = 500
+	// logFingerprintBytes is how much of the start of a file identifies it
+	// across restarts, so a rotated file isn't mistaken for the old one.
+	logFingerprintBytes = 1024
+)
+
+// logEventFields are the fields the agent sets on log events. Parsed fields
+// with the same name are renamed with an "exported_" prefix.
+var logEventFields = map[string]bool{
+	"ts":         true,
+	"event_type": true,
+	"host":       true,
+	"source":     true,
+	"file":       true,
+}
+
+// logSource is a set of log files that are parsed the same way. Format is
+// "text" (the default), "json", or "regex". MultilineStart, if set, matches
+// the first line of each entry; lines that don't match are joined to the
+// entry before them.
+type logSource struct {
+	Name           string   `mapstructure:"name"`
+	Paths          []string `mapstructure:"paths"`
+	Format         string   `mapstructure:"format"`
+	Pattern        string   `mapstructure:"pattern"`
+	MultilineStart string   `mapstructure:"multiline_start"`
+	StartAt        string   `mapstructure:"start_at"`
+
+	pattern        *regexp.Regexp
+	multilineStart *regexp.Regexp
+}
+
+// logFileState is the persisted read position of a file.
+type logFileState struct {
+	Offset          int64  `json:"offset"`
+	Fingerprint     string `json:"fingerprint"`
+	FingerprintSize int64  `json:"fingerprint_size"`
+}
+
+// loadLogSources reads and validates the "agent.logs" section of the config
+// file.
+func loadLogSources() ([]logSource, error) {
+	var sources []logSource
+	if err := viper.UnmarshalKey("agent.logs", &sources); err != nil {
+		return nil, fmt.Errorf("invalid agent.logs config: %w", err)
+	}
+
+	for i := range sources {
+		source := &sources[i]
+		if source.Name == "" {
+			return nil, fmt.Errorf("invalid agent.logs entry %d: name is required", i+1)
+		}
+		if len(source.Paths) == 0 {
+			return nil, fmt.Errorf("invalid agent.logs entry %q: paths is required", source.Name)
+		}
+		for _, pattern := range source.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid path pattern %q for %q: %w", pattern, source.Name, err)
+			}
+		}
+
+		switch source.Format {
+		case "", "text", "json":
+		case "regex":
+			re, err := regexp.Compile(source.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern regex for %q: %w", source.Name, err)
+			}
+			if !slices.ContainsFunc(re.SubexpNames(), func(name string) bool { return name != "" }) {
+				return nil, fmt.Errorf("invalid pattern regex for %q: no named captures", source.Name)
+			}
+			source.pattern = re
+		default:
+			return nil, fmt.Errorf(
+				"invalid agent.logs entry %q: format must be text, json, or regex",
+				source.Name,
+			)
+		}
+
+		if source.MultilineStart != "" {
+			re, err := regexp.Compile(source.MultilineStart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid multiline_start regex for %q: %w", source.Name, err)
+			}
+			source.multilineStart = re
+		}
+
+		switch source.StartAt {
+		case "", "beginning", "end":
+		default:
+			return nil, fmt.Errorf(
+				"invalid agent.logs entry %q: start_at must be beginning or end",
+				source.Name,
+			)
+		}
+	}
+
+	return sources, nil
+}
+
+// defaultLogStatePath prefers the state directory systemd provides to the
+// agent service (see install.sh), falling back to the user's cache directory.
+func defaultLogStatePath() (string, error) {
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return filepath.Join(dir, "log-offsets.json"), nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding log state directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "honeybadger-cli", "agent-log-offsets.json"), nil
+}
+
+// logCollector follows the files matched by each source and reports every
+// line (or multiline entry) as a "report.log" event. Read positions are
+// saved to statePath once the events read up to them have been delivered or
+// spooled, so a restart resumes where it left off without losing lines. If
+// delivery fails, the collector rewinds to the last saved positions and
+// reads the lines again.
+type logCollector struct {
+	hostname  string
+	sources   []logSource
+	statePath string
+
+	mu    sync.Mutex
+	files map[string]*tailedFile
+	// state is the read positions as of the latest collection, and
+	// committed the positions whose events are safe.
+	state     map[string]logFileState
+	committed map[string]logFileState
+	started   bool
+}
+
+// tailedFile is a log file being followed. Bytes after the last complete
+// line, and lines of a multiline entry that may not be finished yet, are
+// held back and not counted as read until they're reported.
+type tailedFile struct {
+	source     *logSource
+	path       string
+	file       *os.File
+	info       os.FileInfo
+	offset     int64
+	partial    []byte
+	entry      []string
+	entryBytes int64
+}
+
+// committed is the offset up to which every line has been reported.
+func (t *tailedFile) committed() int64 {
+	return t.offset - int64(len(t.partial)) - t.entryBytes
+}
+
+func newLogCollector(hostname string, sources []logSource, statePath string) *logCollector {
+	return &logCollector{
+		hostname:  hostname,
+		sources:   sources,
+		statePath: statePath,
+		files:     make(map[string]*tailedFile),
+	}
+}
+
+func (c *logCollector) collect(timestamp string) ([]any, error) {
+	if len(c.sources) == 0 {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	if c.state == nil {
+		state, err := readLogState(c.statePath)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		c.state = state
+		c.committed = maps.Clone(state)
+	}
+
+	var payloads []any
+	seen := make(map[string]bool)
+	for i := range c.sources {
+		source := &c.sources[i]
+		for _, pattern := range source.Paths {
+			matches, _ := filepath.Glob(pattern)
+			for _, path := range matches {
+				if seen[path] {
+					continue
+				}
+				seen[path] = true
+
+				events, err := c.follow(source, path, timestamp)
+				payloads = append(payloads, events...)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("error reading %s: %w", path, err))
+				}
+			}
+		}
+	}
+
+	// Finish files that were removed or no longer match.
+	for path, t := range c.files {
+		if seen[path] {
+			continue
+		}
+		events, err := c.read(t, timestamp, true)
+		payloads = append(payloads, events...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading %s: %w", path, err))
+		}
+		_ = t.file.Close()
+		delete(c.files, path)
+	}
+
+	c.started = true
+	if err := c.updateState(); err != nil {
+		errs = append(errs, err)
+	}
+	return payloads, errors.Join(errs...)
+}
+
+// follow reads new lines from path, opening it if needed and handling
+// rotation and truncation.
+func (c *logCollector) follow(source *logSource, path, timestamp string) ([]any, error) {
+	t, ok := c.files[path]
+	if !ok {
+		var err error
+		if t, err = c.open(source, path); err != nil {
+			return nil, err
+		}
+		c.files[path] = t
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var payloads []any
+	if !os.SameFile(t.info, info) {
+		// The file was rotated: finish the old one, then read the new one
+		// from the beginning.
+		events, err := c.read(t, timestamp, true)
+		payloads = append(payloads, events...)
+		_ = t.file.Close()
+		delete(c.files, path)
+		if err != nil {
+			return payloads, err
+		}
+
+		f, err := os.Open(path) // #nosec G304 - path comes from the user's config
+		if err != nil {
+			return payloads, err
+		}
+		t = &tailedFile{source: source, path: path, file: f, info: info}
+		c.files[path] = t
+	} else if info.Size() < t.offset {
+		// The file was truncated in place.
+		t.offset, t.partial, t.entry, t.entryBytes = 0, nil, nil, 0
+	}
+
+	events, err := c.read(t, timestamp, false)
+	return append(payloads, events...), err
+}
+
+// open starts following a file, resuming from the saved offset if the file
+// is the same one that was being read before. Otherwise files that exist
+// when the agent starts are read from the end (unless the source says
+// otherwise) and files that appear later are read from the beginning.
+func (c *logCollector) open(source *logSource, path string) (*tailedFile, error) {
+	f, err := os.Open(path) // #nosec G304 - path comes from the user's config
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	t := &tailedFile{source: source, path: path, file: f, info: info}
+	if saved, ok := c.state[path]; ok && saved.Offset <= info.Size() && fingerprintMatches(f, saved) {
+		t.offset = saved.Offset
+	} else if !c.started && source.StartAt != "beginning" {
+		t.offset = info.Size()
+		// Nothing before the end needs delivering, so a rewind starts here
+		// rather than at the beginning.
+		if fingerprint, size, err := fileFingerprint(f); err == nil {
+			c.committed[path] = logFileState{Offset: t.offset, Fingerprint: fingerprint, FingerprintSize: size}
+		}
+	}
+	return t, nil
+}
+
+// read reports the complete lines added to t since the last read. When
+// final is true the file is being let go, so it's read to the end however
+// much is left, and anything held back is reported too.
+func (c *logCollector) read(t *tailedFile, timestamp string, final bool) ([]any, error) {
+	var payloads []any
+	var readErr error
+	buf := make([]byte, 64*1024)
+	read := 0
+	for final || read < logMaxReadBytes {
+		n, err := t.file.ReadAt(buf, t.offset)
+		if n > 0 {
+			t.offset += int64(n)
+			read += n
+			payloads = append(payloads, c.consume(t, buf[:n], timestamp)...)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				readErr = err
+			}
+			break
+		}
+	}
+
+	if final && len(t.partial) > 0 {
+		line := string(bytes.TrimSuffix(t.partial, []byte("\r")))
+		n := int64(len(t.partial))
+		t.partial = nil
+		payloads = append(payloads, c.handleLine(t, line, n, timestamp)...)
+	}
+	// A multiline entry is finished once nothing has been added to the
+	// file for a whole interval.
+	if (final || read == 0) && len(t.entry) > 0 {
+		payloads = append(payloads, c.flushEntry(t, timestamp))
+	}
+
+	return payloads, readErr
+}
+
+// consume splits newly read data into lines.
+func (c *logCollector) consume(t *tailedFile, data []byte, timestamp string) []any {
+	t.partial = append(t.partial, data...)
+
+	var payloads []any
+	for {
+		var line []byte
+		var n int
+		if i := bytes.IndexByte(t.partial, '\n'); i >= 0 && i <= logMaxLineBytes {
+			line, n = t.partial[:i], i+1
+		} else if len(t.partial) >= logMaxLineBytes {
+			line, n = t.partial[:logMaxLineBytes], logMaxLineBytes
+		} else {
+			break
+		}
+		text := string(bytes.TrimSuffix(line, []byte("\r")))
+		t.partial = t.partial[n:]
+		payloads = append(payloads, c.handleLine(t, text, int64(n), timestamp)...)
+	}
+	if len(t.partial) == 0 {
+		t.partial = nil
+	}
+	return payloads
+}
+
+// handleLine reports a line, or adds it to the current multiline entry.
+// n is the number of bytes the line took up in the file.
+func (c *logCollector) handleLine(t *tailedFile, line string, n int64, timestamp string) []any {
+	if t.source.multilineStart == nil {
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		return []any{c.event(t, line, timestamp)}
+	}
+
+	if len(t.entry) > 0 && len(t.entry) < logMaxEntryLines && !t.source.multilineStart.MatchString(line) {
+		t.entry = append(t.entry, line)
+		t.entryBytes += n
+		return nil
+	}
+
+	var payloads []any
+	if len(t.entry) > 0 {
+		payloads = append(payloads, c.flushEntry(t, timestamp))
+	}
+	t.entry = []string{line}
+	t.entryBytes = n
+	return payloads
+}
+
+func (c *logCollector) flushEntry(t *tailedFile, timestamp string) any {
+	text := strings.Join(t.entry, "\n")
+	t.entry, t.entryBytes = nil, 0
+	return c.event(t, text, timestamp)
+}
+
+// event builds a log event, parsing text according to the source's format.
+// Unparseable lines are still reported with their text in "message".
+func (c *logCollector) event(t *tailedFile, text, timestamp string) map[string]any {
+	event := map[string]any{
+		"ts":         timestamp,
+		"event_type": "report.log",
+		"host":       c.hostname,
+		"source":     t.source.Name,
+		"file":       t.path,
+		"message":    text,
+	}
+
+	set := func(name string, value any) {
+		if logEventFields[name] {
+			name = "exported_" + name
+		}
+		event[name] = value
+	}
+
+	switch t.source.Format {
+	case "json":
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(text), &fields); err == nil {
+			delete(event, "message")
+			for name, value := range fields {
+				set(name, value)
+			}
+		}
+	case "regex":
+		if m := t.source.pattern.FindStringSubmatch(text); m != nil {
+			for i, name := range t.source.pattern.SubexpNames() {
+				if i > 0 && name != "" {
+					set(name, m[i])
+				}
+			}
+		}
+	}
+
+	return event
+}
+
+// close stops following every file.
+func (c *logCollector) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, t := range c.files {
+		_ = t.file.Close()
+		delete(c.files, path)
+	}
+	return nil
+}
+
+// updateState records the read position of every file being followed.
+func (c *logCollector) updateState() error {
+	state := make(map[string]logFileState, len(c.files))
+	for path, t := range c.files {
+		saved := c.state[path]
+		offset := t.committed()
+		if saved.Offset == offset && saved.FingerprintSize == logFingerprintBytes {
+			state[path] = saved
+			continue
+		}
+		fingerprint, size, err := fileFingerprint(t.file)
+		if err != nil {
+			return fmt.Errorf("error saving log offsets: %w", err)
+		}
+		state[path] = logFileState{Offset: offset, Fingerprint: fingerprint, FingerprintSize: size}
+	}
+	c.state = state
+	return nil
+}
+
+// logCheckpoint is the read positions of a log collector as of one
+// collection.
+type logCheckpoint struct {
+	c     *logCollector
+	state map[string]logFileState
+}
+
+// checkpoint returns the read positions as of the latest collection.
+func (c *logCollector) checkpoint() checkpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &logCheckpoint{c: c, state: c.state}
+}
+
+// commit saves the read positions once the events read up to them are
+// delivered or spooled. Files opened since, which start at the end, keep
+// their starting positions.
+func (cp *logCheckpoint) commit() error {
+	c := cp.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	committed := maps.Clone(cp.state)
+	for path := range c.files {
+		if _, ok := committed[path]; !ok {
+			if saved, ok := c.committed[path]; ok {
+				committed[path] = saved
+			}
+		}
+	}
+	c.committed = committed
+	return writeLogState(c.statePath, cp.state)
+}
+
+// rollback rewinds every file to its last committed position after the
+// events read since were lost, so the next collection reads them again.
+func (cp *logCheckpoint) rollback() {
+	c := cp.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, t := range c.files {
+		_ = t.file.Close()
+		delete(c.files, path)
+	}
+	c.state = maps.Clone(c.committed)
+}
+
+// writeLogState saves read positions to path.
+func writeLogState(path string, state map[string]logFileState) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error saving log offsets: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error saving log offsets: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("error saving log offsets: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("error saving log offsets: %w", err)
+	}
+	return nil
+}
+
+func readLogState(path string) (map[string]logFileState, error) {
+	state := make(map[string]logFileState)
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path) // #nosec G304 - path is the agent's own state file
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("error reading log offsets: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return make(map[string]logFileState), fmt.Errorf("error reading log offsets: %w", err)
+	}
+	return state, nil
+}
+
+// fileFingerprint hashes the start of f.
+func fileFingerprint(f *os.File) (string, int64, error) {
+	buf := make([]byte, logFingerprintBytes)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(buf[:n])
+	return hex.EncodeToString(sum[:]), int64(n), nil
+}
+
+// fingerprintMatches reports whether f starts with the same bytes as the
+// file saved was recorded from.
+func fingerprintMatches(f *os.File, saved logFileState) bool {
+	buf := make([]byte, saved.FingerprintSize)
+	n, err := f.ReadAt(buf, 0)
+	if int64(n) != saved.FingerprintSize || (err != nil && !errors.Is(err, io.EOF)) {
+		return false
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]) == saved.Fingerprint
+}
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadLogSources(t *testing.T) {
+	t.Run("loads sources from config", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.logs", []map[string]interface{}{
+			{"name": "app", "paths": []string{"/var/log/app/*.log"}, "format": "json"},
+			{
+				"name": "nginx", "paths": []string{"/var/log/nginx/access.log"},
+				"format": "regex", "pattern": `^(?P<ip>\S+) `,
+			},
+			{"name": "rails", "paths": []string{"/srv/app/log/*.log"}, "multiline_start": `^\S`},
+		})
+		sources, err := loadLogSources()
+		require.NoError(t, err)
+		require.Len(t, sources, 3)
+		assert.NotNil(t, sources[1].pattern)
+		assert.NotNil(t, sources[2].multilineStart)
+	})
+
+	tests := []struct {
+		name          string
+		source        map[string]interface{}
+		errorContains string
+	}{
+		{
+			name:          "requires a name",
+			source:        map[string]interface{}{"paths": []string{"/var/log/*.log"}},
+			errorContains: "name is required",
+		},
+		{
+			name:          "requires paths",
+			source:        map[string]interface{}{"name": "app"},
+			errorContains: "paths is required",
+		},
+		{
+			name:          "rejects unknown formats",
+			source:        map[string]interface{}{"name": "app", "paths": []string{"/x.log"}, "format": "xml"},
+			errorContains: "format must be",
+		},
+		{
+			name: "requires named captures",
+			source: map[string]interface{}{
+				"name": "app", "paths": []string{"/x.log"}, "format": "regex", "pattern": `^(\S+)`,
+			},
+			errorContains: "no named captures",
+		},
+		{
+			name: "rejects invalid multiline regex",
+			source: map[string]interface{}{
+				"name": "app", "paths": []string{"/x.log"}, "multiline_start": "(",
+			},
+			errorContains: "invalid multiline_start regex",
+		},
+		{
+			name:          "rejects invalid start_at",
+			source:        map[string]interface{}{"name": "app", "paths": []string{"/x.log"}, "start_at": "middle"},
+			errorContains: "start_at must be",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set("agent.logs", []map[string]interface{}{tt.source})
+			_, err := loadLogSources()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+		})
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// logMessages collects logs and returns the message of each event,
+// committing the read positions as if the events were delivered.
+func logMessages(t *testing.T, c *logCollector) []string {
+	t.Helper()
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.NoError(t, c.checkpoint().commit())
+	var messages []string
+	for _, p := range payloads {
+		messages = append(messages, p.(map[string]any)["message"].(string))
+	}
+	return messages
+}
+
+func TestLogCollector(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	statePath := filepath.Join(dir, "state", "offsets.json")
+	appendFile(t, path, "old line\n")
+
+	sources := []logSource{{Name: "app", Paths: []string{filepath.Join(dir, "*.log")}}}
+	c := newLogCollector("test-host", sources, statePath)
+	defer c.close() // nolint:errcheck
+
+	assert.Empty(t, logMessages(t, c), "existing lines are skipped by default")
+
+	appendFile(t, path, "first\r\nsecond\nthird without newline")
+	payloads, err := c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+	assert.Equal(t, map[string]any{
+		"ts":         "2026-01-01T00:01:00Z",
+		"event_type": "report.log",
+		"host":       "test-host",
+		"source":     "app",
+		"file":       path,
+		"message":    "first",
+	}, payloads[0])
+
+	appendFile(t, path, " finished\n")
+	assert.Equal(t, []string{"third without newline finished"}, logMessages(t, c))
+
+	t.Run("picks up new files from the beginning", func(t *testing.T) {
+		other := filepath.Join(dir, "other.log")
+		appendFile(t, other, "hello\n")
+		assert.Equal(t, []string{"hello"}, logMessages(t, c))
+	})
+
+	t.Run("handles truncation", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("after truncate\n"), 0o600))
+		assert.Equal(t, []string{"after truncate"}, logMessages(t, c))
+	})
+
+	t.Run("handles rotation", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("files being read can't be renamed on Windows")
+		}
+		appendFile(t, path, "before rotate\n")
+		require.NoError(t, os.Rename(path, filepath.Join(dir, "app.log.1")))
+		appendFile(t, path, "after rotate\n")
+		assert.Equal(t, []string{"before rotate", "after rotate"}, logMessages(t, c))
+	})
+
+	t.Run("resumes from saved offsets after a restart", func(t *testing.T) {
+		require.NoError(t, c.close())
+		appendFile(t, path, "while stopped\n")
+
+		restarted := newLogCollector("test-host", sources, statePath)
+		defer restarted.close() // nolint:errcheck
+		assert.Equal(t, []string{"while stopped"}, logMessages(t, restarted))
+	})
+}
+
+func TestLogCollectorCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	statePath := filepath.Join(dir, "offsets.json")
+	appendFile(t, path, "old line\n")
+
+	sources := []logSource{{Name: "app", Paths: []string{path}}}
+	c := newLogCollector("test-host", sources, statePath)
+	defer c.close() // nolint:errcheck
+	assert.Empty(t, logMessages(t, c))
+
+	t.Run("re-reads lines after a rollback", func(t *testing.T) {
+		appendFile(t, path, "first\n")
+		payloads, err := c.collect("2026-01-01T00:00:00Z")
+		require.NoError(t, err)
+		require.Len(t, payloads, 1)
+		c.checkpoint().rollback()
+
+		appendFile(t, path, "second\n")
+		assert.Equal(t, []string{"first", "second"}, logMessages(t, c))
+	})
+
+	t.Run("doesn't save offsets until they're committed", func(t *testing.T) {
+		appendFile(t, path, "unsent\n")
+		payloads, err := c.collect("2026-01-01T00:00:00Z")
+		require.NoError(t, err)
+		require.Len(t, payloads, 1)
+		require.NoError(t, c.close())
+
+		restarted := newLogCollector("test-host", sources, statePath)
+		defer restarted.close() // nolint:errcheck
+		assert.Equal(t, []string{"unsent"}, logMessages(t, restarted))
+	})
+}
+
+func TestLogCollectorStartAtBeginning(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "existing\n")
+
+	c := newLogCollector("test-host", []logSource{
+		{Name: "app", Paths: []string{path}, StartAt: "beginning"},
+	}, "")
+	defer c.close() // nolint:errcheck
+	assert.Equal(t, []string{"existing"}, logMessages(t, c))
+}
+
+func TestLogCollectorFinishesRemovedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files being read can't be removed on Windows")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	line := strings.Repeat("x", 99) + "\n"
+	lines := 2*logMaxReadBytes/len(line) + 1000
+	appendFile(t, path, strings.Repeat(line, lines))
+
+	c := newLogCollector("test-host", []logSource{
+		{Name: "app", Paths: []string{path}, StartAt: "beginning"},
+	}, "")
+	defer c.close() // nolint:errcheck
+
+	first := logMessages(t, c)
+	assert.Less(t, len(first), lines, "a large backlog is read over several collections")
+
+	// Once the file is gone, whatever is left of it is read at once.
+	require.NoError(t, os.Remove(path))
+	assert.Len(t, logMessages(t, c), lines-len(first))
+}
+
+func TestLogCollectorMultiline(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	statePath := filepath.Join(dir, "offsets.json")
+	viper.Set("agent.logs", []map[string]interface{}{
+		{"name": "app", "paths": []string{path}, "multiline_start": `^\d{4}-`, "start_at": "beginning"},
+	})
+	sources, err := loadLogSources()
+	require.NoError(t, err)
+
+	appendFile(t, path, "2026-01-01 ERROR boom\n"+
+		"RuntimeError: boom\n"+
+		"  app.rb:1:in `call'\n"+
+		"2026-01-01 INFO next\n"+
+		"  continued\n")
+
+	c := newLogCollector("test-host", sources, statePath)
+	assert.Equal(t, []string{"2026-01-01 ERROR boom\nRuntimeError: boom\n  app.rb:1:in `call'"}, logMessages(t, c))
+
+	// The last entry is held back in case more lines follow, and isn't
+	// counted as read, so a restart still reports it.
+	require.NoError(t, c.close())
+	restarted := newLogCollector("test-host", sources, statePath)
+	defer restarted.close() // nolint:errcheck
+	assert.Empty(t, logMessages(t, restarted))
+
+	// It's reported once nothing more has been written for an interval.
+	assert.Equal(t, []string{"2026-01-01 INFO next\n  continued"}, logMessages(t, restarted))
+}
+
+func TestLogEventParsing(t *testing.T) {
+	viper.Reset()
+	viper.Set("agent.logs", []map[string]interface{}{
+		{"name": "json", "paths": []string{"/x.log"}, "format": "json"},
+		{
+			"name": "nginx", "paths": []string{"/y.log"}, "format": "regex",
+			"pattern": `^(?P<ip>\S+) "(?P<method>\S+) (?P<path>\S+)" (?P<status>\d+)$`,
+		},
+	})
+	sources, err := loadLogSources()
+	require.NoError(t, err)
+	c := newLogCollector("test-host", sources, "")
+
+	jsonFile := &tailedFile{source: &sources[0], path: "/x.log"}
+	event := c.event(jsonFile, `{"level":"error","msg":"boom","host":"web-1","duration":1.5}`, "ts")
+	assert.Equal(t, "error", event["level"])
+	assert.Equal(t, 1.5, event["duration"])
+	assert.Equal(t, "test-host", event["host"])
+	assert.Equal(t, "web-1", event["exported_host"])
+	assert.NotContains(t, event, "message")
+
+	event = c.event(jsonFile, "not json", "ts")
+	assert.Equal(t, "not json", event["message"])
+
+	regexFile := &tailedFile{source: &sources[1], path: "/y.log"}
+	event = c.event(regexFile, `10.0.0.1 "GET /health" 200`, "ts")
+	assert.Equal(t, "10.0.0.1", event["ip"])
+	assert.Equal(t, "GET", event["method"])
+	assert.Equal(t, "/health", event["path"])
+	assert.Equal(t, "200", event["status"])
+	assert.Equal(t, `10.0.0.1 "GET /health" 200`, event["message"])
+
+	event = c.event(regexFile, "garbage", "ts")
+	assert.Equal(t, "garbage", event["message"])
+	assert.NotContains(t, event, "ip")
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+)
+
+// defaultNetworkExclude skips loopback interfaces unless the config file
+// provides its own exclude list.
+var defaultNetworkExclude = []string{"lo", "lo0"}
+
+// networkCounters holds interface counters from the previous collection so
+// that rates can be computed between ticks.
+type networkCounters struct {
+	at       time.Time
+	counters map[string]psnet.IOCountersStat
+}
+
+// networkCollector reports per-interface traffic rates since the previous
+// collection. The first collection only records a baseline.
+type networkCollector struct {
+	hostname string
+	filter   nameFilter
+	previous networkCounters
+}
+
+func (c *networkCollector) collect(timestamp string) ([]any, error) {
+	stats, err := psnet.IOCounters(true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting network metrics: %w", err)
+	}
+
+	current := networkCounters{
+		at:       time.Now(),
+		counters: make(map[string]psnet.IOCountersStat, len(stats)),
+	}
+	for _, stat := range stats {
+		current.counters[stat.Name] = stat
+	}
+
+	previous := c.previous
+	c.previous = current
+	if previous.counters == nil {
+		return nil, nil
+	}
+
+	return networkRates(c.hostname, timestamp, previous, current, c.filter), nil
+}
+
+func (c *networkCollector) prime() error {
+	_, err := c.collect("")
+	return err
+}
+
+// networkRates computes per-second rates for each interface present in both
+// snapshots. Interfaces whose counters went backwards (e.g. because the
+// interface was recreated) are skipped until the next tick.
+func networkRates(
+	hostname, timestamp string,
+	previous, current networkCounters,
+	filter nameFilter,
+) []any {
+	seconds := current.at.Sub(previous.at).Seconds()
+	if seconds <= 0 {
+		return nil
+	}
+
+	var payloads []any
+	for name, cur := range current.counters {
+		if !filter.match(name) {
+			continue
+		}
+		prev, ok := previous.counters[name]
+		if !ok || counterReset(prev, cur) {
+			continue
+		}
+
+		payloads = append(payloads, networkPayload{
+			Ts:                timestamp,
+			Event:             "report.system.network",
+			Host:              hostname,
+			Interface:         name,
+			BytesSentPerSec:   perSecond(prev.BytesSent, cur.BytesSent, seconds),
+			BytesRecvPerSec:   perSecond(prev.BytesRecv, cur.BytesRecv, seconds),
+			PacketsSentPerSec: perSecond(prev.PacketsSent, cur.PacketsSent, seconds),
+			PacketsRecvPerSec: perSecond(prev.PacketsRecv, cur.PacketsRecv, seconds),
+			ErrorsInPerSec:    perSecond(prev.Errin, cur.Errin, seconds),
+			ErrorsOutPerSec:   perSecond(prev.Errout, cur.Errout, seconds),
+			DropsInPerSec:     perSecond(prev.Dropin, cur.Dropin, seconds),
+			DropsOutPerSec:    perSecond(prev.Dropout, cur.Dropout, seconds),
+		})
+	}
+	return payloads
+}
+
+func counterReset(prev, cur psnet.IOCountersStat) bool {
+	return cur.BytesSent < prev.BytesSent || cur.BytesRecv < prev.BytesRecv ||
+		cur.PacketsSent < prev.PacketsSent || cur.PacketsRecv < prev.PacketsRecv ||
+		cur.Errin < prev.Errin || cur.Errout < prev.Errout ||
+		cur.Dropin < prev.Dropin || cur.Dropout < prev.Dropout
+}
+
+// perSecond returns the rate of change of a counter, rounded to two decimals.
+func perSecond(prev, cur uint64, seconds float64) float64 {
+	return math.Round(float64(cur-prev)/seconds*100) / 100
+}
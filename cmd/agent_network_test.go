@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkRates(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := networkCounters{
+		at: start,
+		counters: map[string]psnet.IOCountersStat{
+			"eth0":  {Name: "eth0", BytesSent: 1000, BytesRecv: 2000, PacketsSent: 10, PacketsRecv: 20},
+			"eth1":  {Name: "eth1", BytesSent: 5000},
+			"lo":    {Name: "lo", BytesSent: 100},
+			"wlan0": {Name: "wlan0", BytesSent: 100},
+		},
+	}
+	current := networkCounters{
+		at: start.Add(10 * time.Second),
+		counters: map[string]psnet.IOCountersStat{
+			"eth0": {
+				Name: "eth0", BytesSent: 11000, BytesRecv: 2500, PacketsSent: 110, PacketsRecv: 25,
+				Errin: 3, Errout: 1, Dropin: 7, Dropout: 2,
+			},
+			"eth1":    {Name: "eth1", BytesSent: 10}, // counter reset
+			"lo":      {Name: "lo", BytesSent: 200},
+			"docker0": {Name: "docker0", BytesSent: 100}, // new interface
+		},
+	}
+
+	payloads := networkRates(
+		"test-host", "2026-01-01T00:00:10Z", previous, current,
+		nameFilter{exclude: []string{"lo"}},
+	)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, networkPayload{
+		Ts:                "2026-01-01T00:00:10Z",
+		Event:             "report.system.network",
+		Host:              "test-host",
+		Interface:         "eth0",
+		BytesSentPerSec:   1000,
+		BytesRecvPerSec:   50,
+		PacketsSentPerSec: 10,
+		PacketsRecvPerSec: 0.5,
+		ErrorsInPerSec:    0.3,
+		ErrorsOutPerSec:   0.1,
+		DropsInPerSec:     0.7,
+		DropsOutPerSec:    0.2,
+	}, payloads[0])
+}
+
+func TestNetworkCollector(t *testing.T) {
+	c := &networkCollector{hostname: "test-host"}
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Empty(t, payloads, "first collection should only record a baseline")
+
+	time.Sleep(10 * time.Millisecond)
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	require.NotEmpty(t, payloads)
+	for _, p := range payloads {
+		payload := p.(networkPayload)
+		assert.Equal(t, "report.system.network", payload.Event)
+		assert.Equal(t, "test-host", payload.Host)
+		assert.NotEmpty(t, payload.Interface)
+		assert.GreaterOrEqual(t, payload.BytesRecvPerSec, float64(0))
+	}
+}
+
+func TestNetworkTagsAreReserved(t *testing.T) {
+	for _, key := range []string{"interface", "bytes_sent_per_sec", "drops_out_per_sec"} {
+		_, err := parseTags([]string{key + "=foo"})
+		require.Error(t, err, "expected error for reserved key %q", key)
+	}
+}
@@ -0,0 +1,663 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	otlpMaxBodySize = 10 << 20 // 10MB, after decompression
+	// otlpMaxEvents caps the events held between flushes. Requests beyond it
+	// are refused with a retryable status so the exporter can back off.
+	otlpMaxEvents       = 50000
+	otlpShutdownTimeout = 5 * time.Second
+)
+
+// otlpEventFields are the fields the agent sets on OTLP events. Attributes
+// with the same name are renamed with an "exported_" prefix.
+var otlpEventFields = map[string]bool{
+	"ts":              true,
+	"event_type":      true,
+	"host":            true,
+	"message":         true,
+	"severity":        true,
+	"severity_number": true,
+	"trace_id":        true,
+	"span_id":         true,
+	"metric":          true,
+	"metric_type":     true,
+	"unit":            true,
+	"temporality":     true,
+	"monotonic":       true,
+	"value":           true,
+	"count":           true,
+	"sum":             true,
+	"min":             true,
+	"max":             true,
+	"buckets":         true,
+	"quantiles":       true,
+}
+
+var otlpTemporalities = map[int]string{1: "delta", 2: "cumulative"}
+
+// otlpCollector receives OpenTelemetry logs and metrics over OTLP/HTTP, in
+// either the JSON or protobuf encoding, and reports each log record as a
+// "report.otlp.log" event and each data point as a "report.otlp.metric"
+// event. Resource, scope, and record attributes are flattened into the
+// event's fields.
+type otlpCollector struct {
+	hostname string
+	address  string
+
+	server    *http.Server
+	listener  net.Listener
+	done      chan struct{}
+	listenErr error
+
+	mu      sync.Mutex
+	events  []map[string]any
+	refused int
+}
+
+func newOTLPCollector(hostname, address string) *otlpCollector {
+	return &otlpCollector{hostname: hostname, address: address}
+}
+
+func (c *otlpCollector) listen() error {
+	if c.address == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", c.address)
+	if err != nil {
+		c.listenErr = fmt.Errorf("error listening for OTLP requests on %s: %w", c.address, err)
+		return c.listenErr
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/logs", c.handle(otlpLogEvents))
+	mux.HandleFunc("/v1/metrics", c.handle(otlpMetricEvents))
+	c.listener = ln
+	c.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.server.Serve(ln) // nolint:errcheck
+	}()
+	return nil
+}
+
+// close stops accepting requests and waits for those in progress to finish,
+// so everything the agent has acknowledged is returned by the next
+// collection.
+func (c *otlpCollector) close() error {
+	if c.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+	defer cancel()
+	err := c.server.Shutdown(ctx)
+	<-c.done
+	return err
+}
+
+// handle returns a handler for one OTLP signal. decode turns a request body
+// into events, given whether it's protobuf-encoded.
+func (c *otlpCollector) handle(decode func(body []byte, protobuf bool) ([]map[string]any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var protobuf bool
+		switch mediaType {
+		case "application/json":
+		case "application/x-protobuf":
+			protobuf = true
+		default:
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body, err := readOTLPBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events, err := decode(body, protobuf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		full := len(c.events)+len(events) > otlpMaxEvents
+		if full {
+			c.refused += len(events)
+		} else {
+			c.events = append(c.events, events...)
+		}
+		c.mu.Unlock()
+		if full {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "too many events queued", http.StatusServiceUnavailable)
+			return
+		}
+
+		// An empty Export*ServiceResponse means everything was accepted.
+		if protobuf {
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}")) // nolint:errcheck
+	}
+}
+
+// readOTLPBody reads a request body, decompressing it if needed, up to
+// otlpMaxBodySize.
+func readOTLPBody(r *http.Request) ([]byte, error) {
+	var reader io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing request body: %w", err)
+		}
+		defer gz.Close() // nolint:errcheck
+		reader = gz
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", r.Header.Get("Content-Encoding"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, otlpMaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	if len(body) > otlpMaxBodySize {
+		return nil, fmt.Errorf("request body is larger than %d bytes", otlpMaxBodySize)
+	}
+	return body, nil
+}
+
+func (c *otlpCollector) collect(timestamp string) ([]any, error) {
+	if c.listenErr != nil {
+		return nil, c.listenErr
+	}
+
+	c.mu.Lock()
+	events, refused := c.events, c.refused
+	c.events, c.refused = nil, 0
+	c.mu.Unlock()
+
+	payloads := make([]any, 0, len(events))
+	for _, event := range events {
+		// Records without a timestamp of their own are reported at the
+		// time they were collected.
+		if _, ok := event["ts"]; !ok {
+			event["ts"] = timestamp
+		}
+		event["host"] = c.hostname
+		payloads = append(payloads, event)
+	}
+
+	if refused > 0 {
+		return payloads, fmt.Errorf("refused %d OTLP events over the limit of %d per interval", refused, otlpMaxEvents)
+	}
+	return payloads, nil
+}
+
+// otlpLogEvents decodes an ExportLogsServiceRequest into events.
+func otlpLogEvents(body []byte, protobuf bool) ([]map[string]any, error) {
+	var req otlpLogsRequest
+	var err error
+	if protobuf {
+		err = decodeOTLPLogsProto(body, &req)
+	} else {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP logs request: %w", err)
+	}
+
+	var events []map[string]any
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, record := range sl.LogRecords {
+				event := otlpEvent("report.otlp.log", rl.Resource, sl.Scope, record.Attributes)
+				setOTLPTime(event, record.TimeUnixNano, record.ObservedTimeUnixNano)
+
+				if record.Body != nil {
+					if record.Body.KvlistValue != nil {
+						setOTLPAttributes(event, "", record.Body.KvlistValue.Values)
+					} else {
+						event["message"] = record.Body.value()
+					}
+				}
+				switch {
+				case record.SeverityText != "":
+					event["severity"] = record.SeverityText
+				case record.SeverityNumber > 0:
+					event["severity"] = otlpSeverityName(record.SeverityNumber)
+				}
+				if record.SeverityNumber > 0 {
+					event["severity_number"] = record.SeverityNumber
+				}
+				if record.TraceID != "" {
+					event["trace_id"] = record.TraceID
+				}
+				if record.SpanID != "" {
+					event["span_id"] = record.SpanID
+				}
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+// otlpMetricEvents decodes an ExportMetricsServiceRequest into events, one
+// per data point.
+func otlpMetricEvents(body []byte, protobuf bool) ([]map[string]any, error) {
+	var req otlpMetricsRequest
+	var err error
+	if protobuf {
+		err = decodeOTLPMetricsProto(body, &req)
+	} else {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP metrics request: %w", err)
+	}
+
+	var events []map[string]any
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, metric := range sm.Metrics {
+				point := func(typ string, attributes []otlpKeyValue, timeUnixNano otlpUint) map[string]any {
+					event := otlpEvent("report.otlp.metric", rm.Resource, sm.Scope, attributes)
+					setOTLPTime(event, timeUnixNano, 0)
+					event["metric"] = metric.Name
+					event["metric_type"] = typ
+					if metric.Unit != "" {
+						event["unit"] = metric.Unit
+					}
+					events = append(events, event)
+					return event
+				}
+
+				switch {
+				case metric.Gauge != nil:
+					for _, dp := range metric.Gauge.DataPoints {
+						event := point("gauge", dp.Attributes, dp.TimeUnixNano)
+						setFinite(event, "value", dp.value())
+					}
+				case metric.Sum != nil:
+					for _, dp := range metric.Sum.DataPoints {
+						event := point("sum", dp.Attributes, dp.TimeUnixNano)
+						setFinite(event, "value", dp.value())
+						event["monotonic"] = metric.Sum.IsMonotonic
+						setOTLPTemporality(event, metric.Sum.AggregationTemporality)
+					}
+				case metric.Histogram != nil:
+					for _, dp := range metric.Histogram.DataPoints {
+						event := point("histogram", dp.Attributes, dp.TimeUnixNano)
+						event["count"] = uint64(dp.Count)
+						setOTLPOptional(event, dp.Sum, dp.Min, dp.Max)
+						if len(dp.BucketCounts) > 0 {
+							event["buckets"] = otlpBuckets(dp.ExplicitBounds, dp.BucketCounts)
+						}
+						setOTLPTemporality(event, metric.Histogram.AggregationTemporality)
+					}
+				case metric.ExponentialHistogram != nil:
+					for _, dp := range metric.ExponentialHistogram.DataPoints {
+						event := point("exponential_histogram", dp.Attributes, dp.TimeUnixNano)
+						event["count"] = uint64(dp.Count)
+						setOTLPOptional(event, dp.Sum, dp.Min, dp.Max)
+						setOTLPTemporality(event, metric.ExponentialHistogram.AggregationTemporality)
+					}
+				case metric.Summary != nil:
+					for _, dp := range metric.Summary.DataPoints {
+						event := point("summary", dp.Attributes, dp.TimeUnixNano)
+						event["count"] = uint64(dp.Count)
+						setFinite(event, "sum", dp.Sum)
+						if len(dp.QuantileValues) > 0 {
+							quantiles := make(map[string]float64, len(dp.QuantileValues))
+							for _, q := range dp.QuantileValues {
+								quantiles[strconv.FormatFloat(q.Quantile, 'g', -1, 64)] = q.Value
+							}
+							event["quantiles"] = finiteValues(quantiles)
+						}
+					}
+				}
+			}
+		}
+	}
+	return events, nil
+}
+
+// otlpEvent starts an event with the resource, scope, and record attributes
+// flattened into it, in increasing order of precedence.
+func otlpEvent(eventType string, resource otlpResource, scope otlpScope, attributes []otlpKeyValue) map[string]any {
+	event := map[string]any{"event_type": eventType}
+	setOTLPAttributes(event, "", resource.Attributes)
+	if scope.Name != "" {
+		event["otel.scope.name"] = scope.Name
+	}
+	if scope.Version != "" {
+		event["otel.scope.version"] = scope.Version
+	}
+	setOTLPAttributes(event, "", scope.Attributes)
+	setOTLPAttributes(event, "", attributes)
+	return event
+}
+
+// setOTLPAttributes flattens attributes into event. Nested key/value lists
+// become dotted names, such as "http.request.method".
+func setOTLPAttributes(event map[string]any, prefix string, attributes []otlpKeyValue) {
+	for _, kv := range attributes {
+		name := prefix + kv.Key
+		if kv.Value.KvlistValue != nil {
+			setOTLPAttributes(event, name+".", kv.Value.KvlistValue.Values)
+			continue
+		}
+		if otlpEventFields[name] {
+			name = "exported_" + name
+		}
+		event[name] = kv.Value.value()
+	}
+}
+
+// setOTLPTime sets the event's timestamp from the first non-zero time.
+func setOTLPTime(event map[string]any, times ...otlpUint) {
+	for _, t := range times {
+		if t > 0 {
+			event["ts"] = time.Unix(0, int64(t)).UTC().Format(time.RFC3339) // #nosec G115
+			return
+		}
+	}
+}
+
+func setOTLPTemporality(event map[string]any, temporality int) {
+	if name, ok := otlpTemporalities[temporality]; ok {
+		event["temporality"] = name
+	}
+}
+
+func setOTLPOptional(event map[string]any, sum, minValue, maxValue *float64) {
+	for key, v := range map[string]*float64{"sum": sum, "min": minValue, "max": maxValue} {
+		if v != nil {
+			setFinite(event, key, *v)
+		}
+	}
+}
+
+// otlpBuckets converts explicit-bounds bucket counts to cumulative counts
+// keyed by upper bound, the same shape as Prometheus histogram buckets.
+func otlpBuckets(bounds []float64, counts []otlpUint) map[string]float64 {
+	buckets := make(map[string]float64, len(counts))
+	var total uint64
+	for i, count := range counts {
+		total += uint64(count)
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+		buckets[le] = float64(total)
+	}
+	return finiteValues(buckets)
+}
+
+// otlpSeverityName returns the short name for a log severity number, as
+// defined by the OpenTelemetry logs data model.
+func otlpSeverityName(n int) string {
+	names := []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+	i := (n - 1) / 4
+	if i < 0 || i >= len(names) {
+		return ""
+	}
+	if offset := (n - 1) % 4; offset > 0 {
+		return names[i] + strconv.Itoa(offset+1)
+	}
+	return names[i]
+}
+
+// The types below mirror the OTLP protobuf messages the receiver needs,
+// with JSON field names from the OTLP/JSON encoding. Fields the agent
+// doesn't report are left out.
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         otlpUint       `json:"timeUnixNano"`
+	ObservedTimeUnixNano otlpUint       `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 *otlpAnyValue  `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId"`
+	SpanID               string         `json:"spanId"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name                 string                    `json:"name"`
+	Unit                 string                    `json:"unit"`
+	Gauge                *otlpGauge                `json:"gauge"`
+	Sum                  *otlpSum                  `json:"sum"`
+	Histogram            *otlpHistogram            `json:"histogram"`
+	ExponentialHistogram *otlpExponentialHistogram `json:"exponentialHistogram"`
+	Summary              *otlpSummary              `json:"summary"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+// otlpExponentialHistogram is reported without its buckets, which don't
+// translate into fixed bounds.
+type otlpExponentialHistogram struct {
+	DataPoints             []otlpExponentialHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                                 `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes"`
+	TimeUnixNano otlpUint       `json:"timeUnixNano"`
+	AsDouble     *float64       `json:"asDouble"`
+	AsInt        *otlpInt       `json:"asInt"`
+}
+
+func (dp otlpNumberDataPoint) value() float64 {
+	if dp.AsInt != nil {
+		return float64(*dp.AsInt)
+	}
+	if dp.AsDouble != nil {
+		return *dp.AsDouble
+	}
+	return 0
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes     []otlpKeyValue `json:"attributes"`
+	TimeUnixNano   otlpUint       `json:"timeUnixNano"`
+	Count          otlpUint       `json:"count"`
+	Sum            *float64       `json:"sum"`
+	BucketCounts   []otlpUint     `json:"bucketCounts"`
+	ExplicitBounds []float64      `json:"explicitBounds"`
+	Min            *float64       `json:"min"`
+	Max            *float64       `json:"max"`
+}
+
+type otlpExponentialHistogramDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes"`
+	TimeUnixNano otlpUint       `json:"timeUnixNano"`
+	Count        otlpUint       `json:"count"`
+	Sum          *float64       `json:"sum"`
+	Min          *float64       `json:"min"`
+	Max          *float64       `json:"max"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes     []otlpKeyValue `json:"attributes"`
+	TimeUnixNano   otlpUint       `json:"timeUnixNano"`
+	Count          otlpUint       `json:"count"`
+	Sum            float64        `json:"sum"`
+	QuantileValues []otlpQuantile `json:"quantileValues"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name       string         `json:"name"`
+	Version    string         `json:"version"`
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string           `json:"stringValue"`
+	BoolValue   *bool             `json:"boolValue"`
+	IntValue    *otlpInt          `json:"intValue"`
+	DoubleValue *float64          `json:"doubleValue"`
+	ArrayValue  *otlpArrayValue   `json:"arrayValue"`
+	KvlistValue *otlpKeyValueList `json:"kvlistValue"`
+	BytesValue  []byte            `json:"bytesValue"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKeyValueList struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// value returns v as a JSON-friendly Go value.
+func (v otlpAnyValue) value() any {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		if !isFinite(*v.DoubleValue) {
+			return nil
+		}
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		values := make([]any, 0, len(v.ArrayValue.Values))
+		for _, value := range v.ArrayValue.Values {
+			values = append(values, value.value())
+		}
+		return values
+	case v.KvlistValue != nil:
+		values := make(map[string]any, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			values[kv.Key] = kv.Value.value()
+		}
+		return values
+	case v.BytesValue != nil:
+		return v.BytesValue
+	}
+	return nil
+}
+
+// otlpInt and otlpUint are 64-bit integers, which OTLP/JSON encodes as
+// strings but some exporters send as numbers.
+type (
+	otlpInt  int64
+	otlpUint uint64
+)
+
+func (n *otlpInt) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(unquoteOTLPNumber(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s", data)
+	}
+	*n = otlpInt(v)
+	return nil
+}
+
+func (n *otlpUint) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseUint(unquoteOTLPNumber(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s", data)
+	}
+	*n = otlpUint(v)
+	return nil
+}
+
+func unquoteOTLPNumber(data []byte) string {
+	s := string(data)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,505 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file decodes the OTLP protobuf encoding into the same types the
+// JSON encoding is unmarshaled into. Only the fields those types hold are
+// read; everything else is skipped, as protobuf allows.
+
+var errOTLPProto = errors.New("malformed protobuf message")
+
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+
+	// protoPacked marks a repeated fixed64 or double field, which may be
+	// sent one value at a time or packed into a length-delimited field.
+	protoPacked = -1
+)
+
+// otlpMaxProtoDepth limits how deeply AnyValue arrays and key-value lists
+// may nest, matching the limit encoding/json applies to the JSON encoding.
+const otlpMaxProtoDepth = 10000
+
+// protoSchema maps the numbers of the fields a message type holds to their
+// wire types. Fields it doesn't list are skipped.
+type protoSchema map[int]int
+
+// Schemas of the OTLP messages, from the opentelemetry-proto definitions.
+var (
+	otlpRequestProto  = protoSchema{1: protoBytes}
+	otlpResourceProto = protoSchema{1: protoBytes}
+	otlpScopedProto   = protoSchema{1: protoBytes, 2: protoBytes}
+	otlpScopeProto    = protoSchema{1: protoBytes, 2: protoBytes, 3: protoBytes}
+	otlpKeyValueProto = protoSchema{1: protoBytes, 2: protoBytes}
+	otlpValuesProto   = protoSchema{1: protoBytes}
+	otlpAnyValueProto = protoSchema{
+		1: protoBytes, 2: protoVarint, 3: protoVarint, 4: protoFixed64,
+		5: protoBytes, 6: protoBytes, 7: protoBytes,
+	}
+	otlpLogRecordProto = protoSchema{
+		1: protoFixed64, 11: protoFixed64, 2: protoVarint, 3: protoBytes,
+		5: protoBytes, 6: protoBytes, 9: protoBytes, 10: protoBytes,
+	}
+	otlpMetricProto = protoSchema{
+		1: protoBytes, 3: protoBytes, 5: protoBytes, 7: protoBytes,
+		9: protoBytes, 10: protoBytes, 11: protoBytes,
+	}
+	otlpDataPointsProto     = protoSchema{1: protoBytes}
+	otlpSumProto            = protoSchema{1: protoBytes, 2: protoVarint, 3: protoVarint}
+	otlpAggregationProto    = protoSchema{1: protoBytes, 2: protoVarint}
+	otlpNumberPointProto    = protoSchema{7: protoBytes, 3: protoFixed64, 4: protoFixed64, 6: protoFixed64}
+	otlpHistogramPointProto = protoSchema{
+		9: protoBytes, 3: protoFixed64, 4: protoFixed64, 5: protoFixed64,
+		6: protoPacked, 7: protoPacked, 11: protoFixed64, 12: protoFixed64,
+	}
+	otlpExponentialHistogramPointProto = protoSchema{
+		1: protoBytes, 3: protoFixed64, 4: protoFixed64, 5: protoFixed64,
+		12: protoFixed64, 13: protoFixed64,
+	}
+	otlpSummaryPointProto = protoSchema{
+		7: protoBytes, 3: protoFixed64, 4: protoFixed64, 5: protoFixed64, 6: protoBytes,
+	}
+	otlpQuantileProto = protoSchema{1: protoFixed64, 2: protoFixed64}
+)
+
+// protoField is one field read from a protobuf message. Depending on wire,
+// its value is in n (varint, fixed64, fixed32) or b (length-delimited).
+type protoField struct {
+	num  int
+	wire int
+	n    uint64
+	b    []byte
+}
+
+func (f protoField) double() float64 {
+	return math.Float64frombits(f.n)
+}
+
+// readProto calls fn for each field in the protobuf message b that schema
+// lists, and rejects fields whose wire type doesn't match it.
+func readProto(b []byte, schema protoSchema, fn func(f protoField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errOTLPProto
+		}
+		b = b[n:]
+
+		f := protoField{num: int(key >> 3), wire: int(key & 7)} // #nosec G115
+		switch f.wire {
+		case protoVarint:
+			f.n, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errOTLPProto
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return errOTLPProto
+			}
+			f.n = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoFixed32:
+			if len(b) < 4 {
+				return errOTLPProto
+			}
+			f.n = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case protoBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return errOTLPProto
+			}
+			f.b = b[n : n+int(length)] // #nosec G115 -- bounded by len(b)
+			b = b[n+int(length):]      // #nosec G115
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", errOTLPProto, f.wire)
+		}
+
+		want, ok := schema[f.num]
+		if !ok {
+			continue
+		}
+		if want != f.wire && (want != protoPacked || (f.wire != protoFixed64 && f.wire != protoBytes)) {
+			return fmt.Errorf("%w: field %d has wire type %d", errOTLPProto, f.num, f.wire)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoFixed64s returns the values of a repeated fixed64 or double field,
+// which may be packed into a single length-delimited field.
+func protoFixed64s(f protoField) ([]uint64, error) {
+	if f.wire == protoFixed64 {
+		return []uint64{f.n}, nil
+	}
+	if f.wire != protoBytes || len(f.b)%8 != 0 {
+		return nil, errOTLPProto
+	}
+	values := make([]uint64, 0, len(f.b)/8)
+	for i := 0; i < len(f.b); i += 8 {
+		values = append(values, binary.LittleEndian.Uint64(f.b[i:]))
+	}
+	return values, nil
+}
+
+func decodeOTLPLogsProto(b []byte, req *otlpLogsRequest) error {
+	return readProto(b, otlpRequestProto, func(f protoField) error {
+		if f.num != 1 {
+			return nil
+		}
+		var rl otlpResourceLogs
+		err := readProto(f.b, otlpScopedProto, func(f protoField) error {
+			switch f.num {
+			case 1:
+				return decodeOTLPResourceProto(f.b, &rl.Resource)
+			case 2:
+				var sl otlpScopeLogs
+				err := readProto(f.b, otlpScopedProto, func(f protoField) error {
+					switch f.num {
+					case 1:
+						return decodeOTLPScopeProto(f.b, &sl.Scope)
+					case 2:
+						var record otlpLogRecord
+						if err := decodeOTLPLogRecordProto(f.b, &record); err != nil {
+							return err
+						}
+						sl.LogRecords = append(sl.LogRecords, record)
+					}
+					return nil
+				})
+				rl.ScopeLogs = append(rl.ScopeLogs, sl)
+				return err
+			}
+			return nil
+		})
+		req.ResourceLogs = append(req.ResourceLogs, rl)
+		return err
+	})
+}
+
+func decodeOTLPLogRecordProto(b []byte, record *otlpLogRecord) error {
+	return readProto(b, otlpLogRecordProto, func(f protoField) error {
+		switch f.num {
+		case 1:
+			record.TimeUnixNano = otlpUint(f.n)
+		case 11:
+			record.ObservedTimeUnixNano = otlpUint(f.n)
+		case 2:
+			record.SeverityNumber = int(f.n) // #nosec G115
+		case 3:
+			record.SeverityText = string(f.b)
+		case 5:
+			record.Body = &otlpAnyValue{}
+			return decodeOTLPAnyValueProto(f.b, record.Body, 0)
+		case 6:
+			return appendOTLPKeyValueProto(f.b, &record.Attributes, 0)
+		case 9:
+			record.TraceID = hex.EncodeToString(f.b)
+		case 10:
+			record.SpanID = hex.EncodeToString(f.b)
+		}
+		return nil
+	})
+}
+
+func decodeOTLPMetricsProto(b []byte, req *otlpMetricsRequest) error {
+	return readProto(b, otlpRequestProto, func(f protoField) error {
+		if f.num != 1 {
+			return nil
+		}
+		var rm otlpResourceMetrics
+		err := readProto(f.b, otlpScopedProto, func(f protoField) error {
+			switch f.num {
+			case 1:
+				return decodeOTLPResourceProto(f.b, &rm.Resource)
+			case 2:
+				var sm otlpScopeMetrics
+				err := readProto(f.b, otlpScopedProto, func(f protoField) error {
+					switch f.num {
+					case 1:
+						return decodeOTLPScopeProto(f.b, &sm.Scope)
+					case 2:
+						var metric otlpMetric
+						if err := decodeOTLPMetricProto(f.b, &metric); err != nil {
+							return err
+						}
+						sm.Metrics = append(sm.Metrics, metric)
+					}
+					return nil
+				})
+				rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+				return err
+			}
+			return nil
+		})
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		return err
+	})
+}
+
+func decodeOTLPMetricProto(b []byte, metric *otlpMetric) error {
+	return readProto(b, otlpMetricProto, func(f protoField) error {
+		switch f.num {
+		case 1:
+			metric.Name = string(f.b)
+		case 3:
+			metric.Unit = string(f.b)
+		case 5:
+			metric.Gauge = &otlpGauge{}
+			return readProto(f.b, otlpDataPointsProto, func(f protoField) error {
+				if f.num == 1 {
+					return appendOTLPNumberDataPointProto(f.b, &metric.Gauge.DataPoints)
+				}
+				return nil
+			})
+		case 7:
+			metric.Sum = &otlpSum{}
+			return readProto(f.b, otlpSumProto, func(f protoField) error {
+				switch f.num {
+				case 1:
+					return appendOTLPNumberDataPointProto(f.b, &metric.Sum.DataPoints)
+				case 2:
+					metric.Sum.AggregationTemporality = int(f.n) // #nosec G115
+				case 3:
+					metric.Sum.IsMonotonic = f.n != 0
+				}
+				return nil
+			})
+		case 9:
+			metric.Histogram = &otlpHistogram{}
+			return readProto(f.b, otlpAggregationProto, func(f protoField) error {
+				switch f.num {
+				case 1:
+					var dp otlpHistogramDataPoint
+					if err := decodeOTLPHistogramDataPointProto(f.b, &dp); err != nil {
+						return err
+					}
+					metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, dp)
+				case 2:
+					metric.Histogram.AggregationTemporality = int(f.n) // #nosec G115
+				}
+				return nil
+			})
+		case 10:
+			metric.ExponentialHistogram = &otlpExponentialHistogram{}
+			return readProto(f.b, otlpAggregationProto, func(f protoField) error {
+				switch f.num {
+				case 1:
+					var dp otlpExponentialHistogramDataPoint
+					err := readProto(f.b, otlpExponentialHistogramPointProto, func(f protoField) error {
+						switch f.num {
+						case 1:
+							return appendOTLPKeyValueProto(f.b, &dp.Attributes, 0)
+						case 3:
+							dp.TimeUnixNano = otlpUint(f.n)
+						case 4:
+							dp.Count = otlpUint(f.n)
+						case 5:
+							dp.Sum = protoDouble(f)
+						case 12:
+							dp.Min = protoDouble(f)
+						case 13:
+							dp.Max = protoDouble(f)
+						}
+						return nil
+					})
+					metric.ExponentialHistogram.DataPoints = append(metric.ExponentialHistogram.DataPoints, dp)
+					return err
+				case 2:
+					metric.ExponentialHistogram.AggregationTemporality = int(f.n) // #nosec G115
+				}
+				return nil
+			})
+		case 11:
+			metric.Summary = &otlpSummary{}
+			return readProto(f.b, otlpDataPointsProto, func(f protoField) error {
+				if f.num != 1 {
+					return nil
+				}
+				var dp otlpSummaryDataPoint
+				err := readProto(f.b, otlpSummaryPointProto, func(f protoField) error {
+					switch f.num {
+					case 7:
+						return appendOTLPKeyValueProto(f.b, &dp.Attributes, 0)
+					case 3:
+						dp.TimeUnixNano = otlpUint(f.n)
+					case 4:
+						dp.Count = otlpUint(f.n)
+					case 5:
+						dp.Sum = f.double()
+					case 6:
+						var q otlpQuantile
+						err := readProto(f.b, otlpQuantileProto, func(f protoField) error {
+							switch f.num {
+							case 1:
+								q.Quantile = f.double()
+							case 2:
+								q.Value = f.double()
+							}
+							return nil
+						})
+						dp.QuantileValues = append(dp.QuantileValues, q)
+						return err
+					}
+					return nil
+				})
+				metric.Summary.DataPoints = append(metric.Summary.DataPoints, dp)
+				return err
+			})
+		}
+		return nil
+	})
+}
+
+func appendOTLPNumberDataPointProto(b []byte, points *[]otlpNumberDataPoint) error {
+	var dp otlpNumberDataPoint
+	err := readProto(b, otlpNumberPointProto, func(f protoField) error {
+		switch f.num {
+		case 7:
+			return appendOTLPKeyValueProto(f.b, &dp.Attributes, 0)
+		case 3:
+			dp.TimeUnixNano = otlpUint(f.n)
+		case 4:
+			dp.AsDouble = protoDouble(f)
+		case 6:
+			n := otlpInt(f.n) // #nosec G115 -- sfixed64
+			dp.AsInt = &n
+		}
+		return nil
+	})
+	*points = append(*points, dp)
+	return err
+}
+
+func decodeOTLPHistogramDataPointProto(b []byte, dp *otlpHistogramDataPoint) error {
+	return readProto(b, otlpHistogramPointProto, func(f protoField) error {
+		switch f.num {
+		case 9:
+			return appendOTLPKeyValueProto(f.b, &dp.Attributes, 0)
+		case 3:
+			dp.TimeUnixNano = otlpUint(f.n)
+		case 4:
+			dp.Count = otlpUint(f.n)
+		case 5:
+			dp.Sum = protoDouble(f)
+		case 6:
+			counts, err := protoFixed64s(f)
+			for _, count := range counts {
+				dp.BucketCounts = append(dp.BucketCounts, otlpUint(count))
+			}
+			return err
+		case 7:
+			bounds, err := protoFixed64s(f)
+			for _, bound := range bounds {
+				dp.ExplicitBounds = append(dp.ExplicitBounds, math.Float64frombits(bound))
+			}
+			return err
+		case 11:
+			dp.Min = protoDouble(f)
+		case 12:
+			dp.Max = protoDouble(f)
+		}
+		return nil
+	})
+}
+
+func decodeOTLPResourceProto(b []byte, resource *otlpResource) error {
+	return readProto(b, otlpResourceProto, func(f protoField) error {
+		if f.num == 1 {
+			return appendOTLPKeyValueProto(f.b, &resource.Attributes, 0)
+		}
+		return nil
+	})
+}
+
+func decodeOTLPScopeProto(b []byte, scope *otlpScope) error {
+	return readProto(b, otlpScopeProto, func(f protoField) error {
+		switch f.num {
+		case 1:
+			scope.Name = string(f.b)
+		case 2:
+			scope.Version = string(f.b)
+		case 3:
+			return appendOTLPKeyValueProto(f.b, &scope.Attributes, 0)
+		}
+		return nil
+	})
+}
+
+// appendOTLPKeyValueProto decodes a KeyValue nested depth values deep.
+func appendOTLPKeyValueProto(b []byte, attributes *[]otlpKeyValue, depth int) error {
+	var kv otlpKeyValue
+	err := readProto(b, otlpKeyValueProto, func(f protoField) error {
+		switch f.num {
+		case 1:
+			kv.Key = string(f.b)
+		case 2:
+			return decodeOTLPAnyValueProto(f.b, &kv.Value, depth)
+		}
+		return nil
+	})
+	*attributes = append(*attributes, kv)
+	return err
+}
+
+// decodeOTLPAnyValueProto decodes an AnyValue nested depth values deep.
+func decodeOTLPAnyValueProto(b []byte, v *otlpAnyValue, depth int) error {
+	if depth > otlpMaxProtoDepth {
+		return fmt.Errorf("%w: values nested too deeply", errOTLPProto)
+	}
+	return readProto(b, otlpAnyValueProto, func(f protoField) error {
+		switch f.num {
+		case 1:
+			s := string(f.b)
+			v.StringValue = &s
+		case 2:
+			b := f.n != 0
+			v.BoolValue = &b
+		case 3:
+			n := otlpInt(f.n) // #nosec G115
+			v.IntValue = &n
+		case 4:
+			v.DoubleValue = protoDouble(f)
+		case 5:
+			v.ArrayValue = &otlpArrayValue{}
+			return readProto(f.b, otlpValuesProto, func(f protoField) error {
+				if f.num != 1 {
+					return nil
+				}
+				var value otlpAnyValue
+				err := decodeOTLPAnyValueProto(f.b, &value, depth+1)
+				v.ArrayValue.Values = append(v.ArrayValue.Values, value)
+				return err
+			})
+		case 6:
+			v.KvlistValue = &otlpKeyValueList{}
+			return readProto(f.b, otlpValuesProto, func(f protoField) error {
+				if f.num == 1 {
+					return appendOTLPKeyValueProto(f.b, &v.KvlistValue.Values, depth+1)
+				}
+				return nil
+			})
+		case 7:
+			v.BytesValue = append([]byte{}, f.b...)
+		}
+		return nil
+	})
+}
+
+func protoDouble(f protoField) *float64 {
+	v := f.double()
+	return &v
+}
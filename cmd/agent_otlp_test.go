@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Helpers for building protobuf messages in tests.
+
+func pbKey(num, wire int) []byte {
+	return binary.AppendUvarint(nil, uint64(num<<3|wire))
+}
+
+func pbMsg(num int, fields ...[]byte) []byte {
+	body := bytes.Join(fields, nil)
+	return append(binary.AppendUvarint(pbKey(num, protoBytes), uint64(len(body))), body...)
+}
+
+func pbString(num int, s string) []byte {
+	return pbMsg(num, []byte(s))
+}
+
+func pbVarint(num int, v uint64) []byte {
+	return binary.AppendUvarint(pbKey(num, protoVarint), v)
+}
+
+func pbFixed64(num int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(pbKey(num, protoFixed64), v)
+}
+
+func pbDouble(num int, v float64) []byte {
+	return pbFixed64(num, math.Float64bits(v))
+}
+
+// pbAttr is a KeyValue with a string value, as field num.
+func pbAttr(num int, key, value string) []byte {
+	return pbMsg(num, pbString(1, key), pbMsg(2, pbString(1, value)))
+}
+
+// otlpTestClient doesn't keep connections open, which would otherwise leave
+// the collector's graceful shutdown waiting on connections the transport
+// dialed but never used.
+var otlpTestClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// postOTLP sends body to the collector and returns the response status.
+func postOTLP(t *testing.T, c *otlpCollector, path, contentType string, body []byte) int {
+	t.Helper()
+	resp, err := otlpTestClient.Post("http://"+c.listener.Addr().String()+path, contentType, bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint:errcheck
+	return resp.StatusCode
+}
+
+func TestOTLPCollectorJSON(t *testing.T) {
+	c := newOTLPCollector("test-host", "127.0.0.1:0")
+	require.NoError(t, c.listen())
+
+	logs := `{"resourceLogs":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeLogs":[{
+			"scope":{"name":"my.library","version":"1.0.0"},
+			"logRecords":[{
+				"timeUnixNano":"1544712660300000000",
+				"severityNumber":17,
+				"body":{"stringValue":"payment failed"},
+				"attributes":[
+					{"key":"http","value":{"kvlistValue":{"values":[{"key":"status_code","value":{"intValue":"502"}}]}}},
+					{"key":"host","value":{"stringValue":"pod-1"}}
+				],
+				"traceId":"5b8efff798038103d269b633813fc60c",
+				"spanId":"eee19b7ec3c1b174"
+			}]
+		}]
+	}]}`
+	assert.Equal(t, http.StatusOK, postOTLP(t, c, "/v1/logs", "application/json", []byte(logs)))
+
+	metrics := `{"resourceMetrics":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeMetrics":[{"metrics":[
+			{"name":"orders","unit":"1","sum":{
+				"aggregationTemporality":2,"isMonotonic":true,
+				"dataPoints":[{"asInt":"42","attributes":[{"key":"region","value":{"stringValue":"us"}}]}]
+			}},
+			{"name":"latency","unit":"ms","histogram":{
+				"aggregationTemporality":1,
+				"dataPoints":[{"count":"3","sum":60,"bucketCounts":["1","1","1"],"explicitBounds":[10,25],"min":5,"max":40}]
+			}},
+			{"name":"rpc.duration","summary":{
+				"dataPoints":[{"count":"2","sum":3,"quantileValues":[{"quantile":0.5,"value":1},{"quantile":0.99,"value":2}]}]
+			}}
+		]}]
+	}]}`
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, err := w.Write([]byte(metrics))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	req, err := http.NewRequest(http.MethodPost, "http://"+c.listener.Addr().String()+"/v1/metrics", &gz)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := otlpTestClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close() // nolint:errcheck
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, c.close())
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 4)
+
+	assert.Equal(t, map[string]any{
+		"ts":                 "2018-12-13T14:51:00Z",
+		"event_type":         "report.otlp.log",
+		"host":               "test-host",
+		"service.name":       "checkout",
+		"otel.scope.name":    "my.library",
+		"otel.scope.version": "1.0.0",
+		"http.status_code":   int64(502),
+		"exported_host":      "pod-1",
+		"message":            "payment failed",
+		"severity":           "ERROR",
+		"severity_number":    17,
+		"trace_id":           "5b8efff798038103d269b633813fc60c",
+		"span_id":            "eee19b7ec3c1b174",
+	}, payloads[0])
+
+	assert.Equal(t, map[string]any{
+		"ts":           "2026-01-01T00:00:00Z",
+		"event_type":   "report.otlp.metric",
+		"host":         "test-host",
+		"service.name": "checkout",
+		"region":       "us",
+		"metric":       "orders",
+		"metric_type":  "sum",
+		"unit":         "1",
+		"value":        float64(42),
+		"monotonic":    true,
+		"temporality":  "cumulative",
+	}, payloads[1])
+
+	latency := payloads[2].(map[string]any)
+	assert.Equal(t, "histogram", latency["metric_type"])
+	assert.Equal(t, uint64(3), latency["count"])
+	assert.Equal(t, float64(60), latency["sum"])
+	assert.Equal(t, float64(5), latency["min"])
+	assert.Equal(t, float64(40), latency["max"])
+	assert.Equal(t, map[string]float64{"10": 1, "25": 2, "+Inf": 3}, latency["buckets"])
+	assert.Equal(t, "delta", latency["temporality"])
+
+	summary := payloads[3].(map[string]any)
+	assert.Equal(t, "summary", summary["metric_type"])
+	assert.Equal(t, map[string]float64{"0.5": 1, "0.99": 2}, summary["quantiles"])
+}
+
+func TestOTLPCollectorProtobuf(t *testing.T) {
+	c := newOTLPCollector("test-host", "127.0.0.1:0")
+	require.NoError(t, c.listen())
+
+	resource := pbMsg(1, pbAttr(1, "service.name", "checkout"))
+	logs := pbMsg(1,
+		resource,
+		pbMsg(2,
+			pbMsg(1, pbString(1, "my.library")),
+			pbMsg(2,
+				pbFixed64(1, 1544712660300000000),
+				pbVarint(2, 9),
+				pbString(3, "Information"),
+				pbMsg(5, pbString(1, "order placed")),
+				pbAttr(6, "order.id", "A-1"),
+				pbMsg(9, []byte{0xab, 0xcd}),
+				pbVarint(99, 1), // unknown fields are skipped
+			),
+		),
+	)
+	assert.Equal(t, http.StatusOK, postOTLP(t, c, "/v1/logs", "application/x-protobuf", logs))
+
+	metrics := pbMsg(1,
+		resource,
+		pbMsg(2,
+			pbMsg(2,
+				pbString(1, "queue.depth"),
+				pbMsg(5, pbMsg(1, pbDouble(4, 7.5), pbAttr(7, "queue", "emails"))),
+			),
+			pbMsg(2,
+				pbString(1, "latency"),
+				pbMsg(9,
+					pbMsg(1,
+						pbFixed64(4, 3),
+						pbDouble(5, 60),
+						// Packed repeated fields.
+						pbMsg(6, binary.LittleEndian.AppendUint64(binary.LittleEndian.AppendUint64(nil, 1), 2)),
+						pbMsg(7, binary.LittleEndian.AppendUint64(nil, math.Float64bits(10))),
+					),
+					pbVarint(2, 2),
+				),
+			),
+		),
+	)
+	assert.Equal(t, http.StatusOK, postOTLP(t, c, "/v1/metrics", "application/x-protobuf", metrics))
+
+	require.NoError(t, c.close())
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 3)
+
+	assert.Equal(t, map[string]any{
+		"ts":              "2018-12-13T14:51:00Z",
+		"event_type":      "report.otlp.log",
+		"host":            "test-host",
+		"service.name":    "checkout",
+		"otel.scope.name": "my.library",
+		"order.id":        "A-1",
+		"message":         "order placed",
+		"severity":        "Information",
+		"severity_number": 9,
+		"trace_id":        "abcd",
+	}, payloads[0])
+
+	gauge := payloads[1].(map[string]any)
+	assert.Equal(t, "queue.depth", gauge["metric"])
+	assert.Equal(t, "gauge", gauge["metric_type"])
+	assert.Equal(t, 7.5, gauge["value"])
+	assert.Equal(t, "emails", gauge["queue"])
+
+	latency := payloads[2].(map[string]any)
+	assert.Equal(t, uint64(3), latency["count"])
+	assert.Equal(t, map[string]float64{"10": 1, "+Inf": 3}, latency["buckets"])
+	assert.Equal(t, "cumulative", latency["temporality"])
+}
+
+func TestOTLPCollectorRejectsBadRequests(t *testing.T) {
+	c := newOTLPCollector("test-host", "127.0.0.1:0")
+	require.NoError(t, c.listen())
+	defer c.close() // nolint:errcheck
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, postOTLP(t, c, "/v1/logs", "text/plain", []byte("hi")))
+	assert.Equal(t, http.StatusBadRequest, postOTLP(t, c, "/v1/logs", "application/json", []byte("{")))
+	assert.Equal(t, http.StatusBadRequest, postOTLP(t, c, "/v1/metrics", "application/x-protobuf", []byte{0x0a, 0x05}))
+	assert.Equal(t, http.StatusNotFound, postOTLP(t, c, "/v1/traces", "application/json", []byte("{}")))
+
+	// A metric name sent as a varint instead of a string.
+	wrongWire := pbMsg(1, pbMsg(2, pbMsg(2, pbVarint(1, 7))))
+	assert.Equal(t, http.StatusBadRequest, postOTLP(t, c, "/v1/metrics", "application/x-protobuf", wrongWire))
+
+	resp, err := otlpTestClient.Get("http://" + c.listener.Addr().String() + "/v1/logs")
+	require.NoError(t, err)
+	resp.Body.Close() // nolint:errcheck
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestDecodeOTLPAnyValueProtoLimitsDepth(t *testing.T) {
+	// An AnyValue holding an array of one string.
+	nested := pbMsg(5, pbMsg(1, pbString(1, "deep")))
+
+	var v otlpAnyValue
+	require.NoError(t, decodeOTLPAnyValueProto(nested, &v, otlpMaxProtoDepth-1))
+	require.Len(t, v.ArrayValue.Values, 1)
+	assert.Equal(t, "deep", *v.ArrayValue.Values[0].StringValue)
+
+	err := decodeOTLPAnyValueProto(nested, &otlpAnyValue{}, otlpMaxProtoDepth)
+	assert.ErrorIs(t, err, errOTLPProto)
+}
+
+func TestOTLPSeverityName(t *testing.T) {
+	assert.Equal(t, "TRACE", otlpSeverityName(1))
+	assert.Equal(t, "DEBUG2", otlpSeverityName(6))
+	assert.Equal(t, "INFO", otlpSeverityName(9))
+	assert.Equal(t, "FATAL4", otlpSeverityName(24))
+	assert.Equal(t, "", otlpSeverityName(25))
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/spf13/viper"
+)
+
+// processRule selects the processes reported under a name. Exactly one of
+// ProcessName (a glob matched against the executable name), Cmdline (a
+// regular expression matched against the full command line), or Pidfile must
+// be set.
+type processRule struct {
+	Name        string `mapstructure:"name"`
+	ProcessName string `mapstructure:"process_name"`
+	Cmdline     string `mapstructure:"cmdline"`
+	Pidfile     string `mapstructure:"pidfile"`
+
+	cmdline *regexp.Regexp
+}
+
+// processCPUSample is the CPU time a process had used at the previous
+// collection, so CPU usage can be computed between ticks.
+type processCPUSample struct {
+	at        time.Time
+	total     float64
+	createdAt int64
+}
+
+// loadProcessRules reads and validates the "agent.processes" section of the
+// config file.
+func loadProcessRules() ([]processRule, error) {
+	var rules []processRule
+	if err := viper.UnmarshalKey("agent.processes", &rules); err != nil {
+		return nil, fmt.Errorf("invalid agent.processes config: %w", err)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("invalid agent.processes entry %d: name is required", i+1)
+		}
+
+		matchers := 0
+		for _, m := range []string{rule.ProcessName, rule.Cmdline, rule.Pidfile} {
+			if m != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return nil, fmt.Errorf(
+				"invalid agent.processes entry %q: exactly one of process_name, cmdline, or pidfile is required",
+				rule.Name,
+			)
+		}
+
+		if rule.ProcessName != "" {
+			if _, err := path.Match(rule.ProcessName, ""); err != nil {
+				return nil, fmt.Errorf("invalid process_name for %q: %w", rule.Name, err)
+			}
+		}
+		if rule.Cmdline != "" {
+			re, err := regexp.Compile(rule.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cmdline regex for %q: %w", rule.Name, err)
+			}
+			rule.cmdline = re
+		}
+	}
+
+	return rules, nil
+}
+
+// matches reports whether p is selected by a process_name or cmdline rule.
+func (r processRule) matches(p *process.Process) bool {
+	switch {
+	case r.ProcessName != "":
+		name, err := p.Name()
+		if err != nil {
+			return false
+		}
+		ok, _ := path.Match(r.ProcessName, name)
+		return ok
+	case r.cmdline != nil:
+		cmdline, err := p.Cmdline()
+		if err != nil || cmdline == "" {
+			return false
+		}
+		return r.cmdline.MatchString(cmdline)
+	}
+	return false
+}
+
+// pidfileProcess returns the process whose PID is in the rule's pidfile, or
+// nil if the pidfile is missing or the process isn't running.
+func (r processRule) pidfileProcess() *process.Process {
+	data, err := os.ReadFile(r.Pidfile) // #nosec G304 - pidfile path comes from the user's config
+	if err != nil {
+		return nil
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil || pid <= 0 {
+		return nil
+	}
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// processCollector reports CPU, memory, file descriptor, and thread usage for
+// each process matched by the configured rules. A rule that matches nothing
+// produces a "not running" event so alarms can fire on it.
+type processCollector struct {
+	hostname string
+	rules    []processRule
+	cpu      map[int32]processCPUSample
+}
+
+func (c *processCollector) collect(timestamp string) ([]any, error) {
+	if len(c.rules) == 0 {
+		return nil, nil
+	}
+
+	matched := make([][]*process.Process, len(c.rules))
+	needScan := false
+	for i, rule := range c.rules {
+		if rule.Pidfile != "" {
+			if p := rule.pidfileProcess(); p != nil {
+				matched[i] = append(matched[i], p)
+			}
+		} else {
+			needScan = true
+		}
+	}
+
+	if needScan {
+		procs, err := process.Processes()
+		if err != nil {
+			return nil, fmt.Errorf("error listing processes: %w", err)
+		}
+		selfPID := int32(os.Getpid()) // #nosec G115 - PIDs fit in int32
+		for _, p := range procs {
+			if p.Pid == selfPID {
+				continue
+			}
+			for i, rule := range c.rules {
+				if rule.Pidfile == "" && rule.matches(p) {
+					matched[i] = append(matched[i], p)
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	samples := make(map[int32]processCPUSample)
+	var payloads []any
+	for i, rule := range c.rules {
+		if len(matched[i]) == 0 {
+			payloads = append(payloads, processNotRunningPayload{
+				Ts:      timestamp,
+				Event:   "report.process",
+				Host:    c.hostname,
+				Process: rule.Name,
+				Running: false,
+			})
+			continue
+		}
+		for _, p := range matched[i] {
+			payloads = append(payloads, c.processMetrics(timestamp, rule.Name, p, now, samples))
+		}
+	}
+
+	c.cpu = samples
+	return payloads, nil
+}
+
+func (c *processCollector) prime() error {
+	_, err := c.collect("")
+	return err
+}
+
+// processMetrics builds the payload for a single process. Values that can't
+// be read (e.g. open files of another user's process) are reported as -1.
+// CPU usage is omitted the first time a process is seen.
+func (c *processCollector) processMetrics(
+	timestamp, ruleName string,
+	p *process.Process,
+	now time.Time,
+	samples map[int32]processCPUSample,
+) processPayload {
+	payload := processPayload{
+		Ts:         timestamp,
+		Event:      "report.process",
+		Host:       c.hostname,
+		Process:    ruleName,
+		Running:    true,
+		PID:        p.Pid,
+		OpenFDs:    -1,
+		NumThreads: -1,
+	}
+
+	if name, err := p.Name(); err == nil {
+		payload.ProcessName = name
+	}
+	if memInfo, err := p.MemoryInfo(); err == nil {
+		payload.RSS = memInfo.RSS
+	}
+	if fds, err := p.NumFDs(); err == nil {
+		payload.OpenFDs = fds
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		payload.NumThreads = threads
+	}
+
+	if times, err := p.Times(); err == nil {
+		createdAt, _ := p.CreateTime()
+		sample := processCPUSample{at: now, total: times.User + times.System, createdAt: createdAt}
+		samples[p.Pid] = sample
+
+		// Only compare against the same process, not a new one reusing the PID.
+		if prev, ok := c.cpu[p.Pid]; ok && prev.createdAt == createdAt {
+			if seconds := now.Sub(prev.at).Seconds(); seconds > 0 {
+				cpuPercent := math.Round((sample.total-prev.total)/seconds*100*100) / 100
+				payload.CPUPercent = &cpuPercent
+			}
+		}
+	}
+
+	return payload
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadProcessRules(t *testing.T) {
+	t.Run("loads rules from config", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.processes", []map[string]interface{}{
+			{"name": "web", "process_name": "nginx*"},
+			{"name": "worker", "cmdline": "sidekiq .*default"},
+			{"name": "db", "pidfile": "/var/run/postgres.pid"},
+		})
+
+		rules, err := loadProcessRules()
+		require.NoError(t, err)
+		require.Len(t, rules, 3)
+		assert.Equal(t, "nginx*", rules[0].ProcessName)
+		require.NotNil(t, rules[1].cmdline)
+		assert.True(t, rules[1].cmdline.MatchString("sidekiq 7.0 [0 of 5 busy] default"))
+		assert.Equal(t, "/var/run/postgres.pid", rules[2].Pidfile)
+	})
+
+	t.Run("returns no rules when unset", func(t *testing.T) {
+		viper.Reset()
+		rules, err := loadProcessRules()
+		require.NoError(t, err)
+		assert.Empty(t, rules)
+	})
+
+	tests := []struct {
+		name          string
+		rule          map[string]interface{}
+		errorContains string
+	}{
+		{
+			name:          "requires a name",
+			rule:          map[string]interface{}{"process_name": "nginx"},
+			errorContains: "name is required",
+		},
+		{
+			name:          "requires a matcher",
+			rule:          map[string]interface{}{"name": "web"},
+			errorContains: "exactly one of",
+		},
+		{
+			name:          "rejects multiple matchers",
+			rule:          map[string]interface{}{"name": "web", "process_name": "nginx", "pidfile": "/x.pid"},
+			errorContains: "exactly one of",
+		},
+		{
+			name:          "rejects invalid regex",
+			rule:          map[string]interface{}{"name": "web", "cmdline": "("},
+			errorContains: "invalid cmdline regex",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set("agent.processes", []map[string]interface{}{tt.rule})
+			_, err := loadProcessRules()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+		})
+	}
+}
+
+// startTestProcess starts a long-running child process and returns it along
+// with its executable name.
+func startTestProcess(t *testing.T) (*exec.Cmd, string) {
+	t.Helper()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("ping", "-n", "60", "127.0.0.1")
+	} else {
+		cmd = exec.Command("sleep", "60")
+	}
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	p, err := process.NewProcess(int32(cmd.Process.Pid)) // #nosec G115
+	require.NoError(t, err)
+	name, err := p.Name()
+	require.NoError(t, err)
+	return cmd, name
+}
+
+func TestProcessCollector(t *testing.T) {
+	cmd, name := startTestProcess(t)
+	pid := int32(cmd.Process.Pid) // #nosec G115
+
+	pidfile := filepath.Join(t.TempDir(), "test.pid")
+	require.NoError(t, os.WriteFile(pidfile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o600))
+
+	viper.Reset()
+	viper.Set("agent.processes", []map[string]interface{}{
+		{"name": "by-name", "process_name": name},
+		{"name": "by-pidfile", "pidfile": pidfile},
+		{"name": "by-cmdline", "cmdline": regexp.QuoteMeta(strings.Join(cmd.Args, " ")) + "$"},
+		{"name": "missing", "process_name": "hb-test-no-such-process"},
+		{"name": "missing-pidfile", "pidfile": filepath.Join(t.TempDir(), "gone.pid")},
+	})
+	rules, err := loadProcessRules()
+	require.NoError(t, err)
+
+	c := &processCollector{hostname: "test-host", rules: rules}
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+
+	found := map[string]processPayload{}
+	notRunning := map[string]bool{}
+	for _, p := range payloads {
+		switch payload := p.(type) {
+		case processPayload:
+			assert.Equal(t, "report.process", payload.Event)
+			assert.Equal(t, "test-host", payload.Host)
+			assert.True(t, payload.Running)
+			if payload.PID == pid {
+				found[payload.Process] = payload
+			}
+		case processNotRunningPayload:
+			assert.Equal(t, "report.process", payload.Event)
+			assert.False(t, payload.Running)
+			notRunning[payload.Process] = true
+		}
+	}
+
+	for _, rule := range []string{"by-name", "by-pidfile", "by-cmdline"} {
+		payload, ok := found[rule]
+		require.True(t, ok, "rule %q did not match the test process", rule)
+		assert.Equal(t, name, payload.ProcessName)
+		assert.NotZero(t, payload.RSS)
+		assert.Nil(t, payload.CPUPercent, "CPU usage should be omitted on first sight")
+	}
+	assert.Equal(t, map[string]bool{"missing": true, "missing-pidfile": true}, notRunning)
+
+	// The second collection reports CPU usage since the first.
+	time.Sleep(10 * time.Millisecond)
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	for _, p := range payloads {
+		if payload, ok := p.(processPayload); ok && payload.PID == pid {
+			require.NotNil(t, payload.CPUPercent)
+			assert.GreaterOrEqual(t, *payload.CPUPercent, float64(0))
+		}
+	}
+}
+
+func TestProcessCollectorWithoutRules(t *testing.T) {
+	c := &processCollector{hostname: "test-host"}
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Empty(t, payloads)
+}
@@ -0,0 +1,501 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	prometheusScrapeTimeout = 10 * time.Second
+	prometheusMaxBodySize   = 10 << 20 // 10MB
+	prometheusAccept        = "application/openmetrics-text;version=1.0.0;q=0.5,text/plain;version=0.0.4;q=0.3,*/*;q=0.1"
+)
+
+// prometheusEventFields are the fields the agent sets on scraped events.
+// Labels with the same name are renamed with an "exported_" prefix, as
+// Prometheus itself does.
+var prometheusEventFields = map[string]bool{
+	"ts":          true,
+	"event_type":  true,
+	"host":        true,
+	"job":         true,
+	"instance":    true,
+	"metric_type": true,
+	"value":       true,
+	"rate":        true,
+	"count":       true,
+	"sum":         true,
+	"buckets":     true,
+	"quantiles":   true,
+}
+
+// prometheusTarget is an endpoint exposing metrics in the Prometheus text or
+// OpenMetrics format. Include and Exclude are globs matched against metric
+// family names.
+type prometheusTarget struct {
+	Name    string   `mapstructure:"name"`
+	URL     string   `mapstructure:"url"`
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+
+	filter   nameFilter
+	instance string
+}
+
+// loadPrometheusTargets reads and validates the "agent.prometheus" section
+// of the config file.
+func loadPrometheusTargets() ([]prometheusTarget, error) {
+	var targets []prometheusTarget
+	if err := viper.UnmarshalKey("agent.prometheus", &targets); err != nil {
+		return nil, fmt.Errorf("invalid agent.prometheus config: %w", err)
+	}
+
+	for i := range targets {
+		target := &targets[i]
+		if target.Name == "" {
+			return nil, fmt.Errorf("invalid agent.prometheus entry %d: name is required", i+1)
+		}
+		u, err := url.Parse(target.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf(
+				"invalid agent.prometheus entry %q: url must be an http or https URL",
+				target.Name,
+			)
+		}
+		target.instance = u.Host
+
+		target.filter = nameFilter{include: target.Include, exclude: target.Exclude}
+		for _, pattern := range append(target.Include, target.Exclude...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q for %q: %w", pattern, target.Name, err)
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// prometheusCollector scrapes each configured target and converts every
+// metric family into "report.prometheus.<family>" events. Counters also get
+// a per-second rate since the previous scrape.
+type prometheusCollector struct {
+	hostname string
+	targets  []prometheusTarget
+	client   *http.Client
+
+	// previous holds each target's counter values from its last scrape.
+	previous []map[string]prometheusCounterSample
+}
+
+type prometheusCounterSample struct {
+	at    time.Time
+	value float64
+}
+
+func newPrometheusCollector(hostname string, targets []prometheusTarget) *prometheusCollector {
+	return &prometheusCollector{
+		hostname: hostname,
+		targets:  targets,
+		client:   &http.Client{Timeout: prometheusScrapeTimeout},
+		previous: make([]map[string]prometheusCounterSample, len(targets)),
+	}
+}
+
+func (c *prometheusCollector) collect(timestamp string) ([]any, error) {
+	results := make([][]any, len(c.targets))
+	errs := make([]error, len(c.targets))
+
+	// Scrape targets concurrently so one slow target doesn't delay the rest.
+	var wg sync.WaitGroup
+	for i, target := range c.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			families, err := c.scrape(target)
+			if err != nil {
+				errs[i] = fmt.Errorf("error scraping %s: %w", target.Name, err)
+				return
+			}
+			now := time.Now()
+			results[i], c.previous[i] = prometheusEvents(
+				c.hostname, timestamp, target, families, now, c.previous[i],
+			)
+		}()
+	}
+	wg.Wait()
+
+	var payloads []any
+	for _, events := range results {
+		payloads = append(payloads, events...)
+	}
+	return payloads, errors.Join(errs...)
+}
+
+func (c *prometheusCollector) scrape(target prometheusTarget) ([]*prometheusFamily, error) {
+	req, err := http.NewRequest("GET", target.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept", prometheusAccept)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("received error response: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, prometheusMaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	if len(body) > prometheusMaxBodySize {
+		return nil, fmt.Errorf("response is larger than %d bytes", prometheusMaxBodySize)
+	}
+	return parsePrometheusText(bytes.NewReader(body))
+}
+
+// prometheusEvents converts parsed families into events and returns the
+// counter values to compare against on the next scrape.
+func prometheusEvents(
+	hostname, timestamp string,
+	target prometheusTarget,
+	families []*prometheusFamily,
+	now time.Time,
+	previous map[string]prometheusCounterSample,
+) ([]any, map[string]prometheusCounterSample) {
+	counters := make(map[string]prometheusCounterSample)
+	var events []any
+
+	for _, family := range families {
+		if !target.filter.match(family.name) {
+			continue
+		}
+		for _, series := range family.series {
+			event := map[string]any{
+				"ts":          timestamp,
+				"event_type":  "report.prometheus." + family.name,
+				"host":        hostname,
+				"job":         target.Name,
+				"instance":    target.instance,
+				"metric_type": family.typ,
+			}
+			for name, value := range series.labels {
+				if prometheusEventFields[name] {
+					name = "exported_" + name
+				}
+				event[name] = value
+			}
+
+			switch family.typ {
+			case "histogram", "gaugehistogram", "summary":
+				setFinite(event, "count", series.count)
+				setFinite(event, "sum", series.sum)
+				if len(series.buckets) > 0 {
+					event["buckets"] = finiteValues(series.buckets)
+				}
+				if len(series.quantiles) > 0 {
+					event["quantiles"] = finiteValues(series.quantiles)
+				}
+			default:
+				if !series.hasValue || !isFinite(series.value) {
+					continue
+				}
+				event["value"] = series.value
+				if family.typ == "counter" {
+					key := family.name + "\xff" + series.key
+					counters[key] = prometheusCounterSample{at: now, value: series.value}
+					prev, ok := previous[key]
+					if seconds := now.Sub(prev.at).Seconds(); ok && seconds > 0 && series.value >= prev.value {
+						event["rate"] = math.Round((series.value-prev.value)/seconds*100) / 100
+					}
+				}
+			}
+			events = append(events, event)
+		}
+	}
+
+	return events, counters
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// setFinite sets event[key] unless v can't be represented in JSON.
+func setFinite(event map[string]any, key string, v float64) {
+	if isFinite(v) {
+		event[key] = v
+	}
+}
+
+func finiteValues(values map[string]float64) map[string]float64 {
+	out := make(map[string]float64, len(values))
+	for k, v := range values {
+		if isFinite(v) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// prometheusFamily is a parsed metric family. Histograms and summaries are
+// grouped into one series per label set, without the "le" and "quantile"
+// labels.
+type prometheusFamily struct {
+	name   string
+	typ    string
+	series []*prometheusSeries
+	index  map[string]*prometheusSeries
+}
+
+type prometheusSeries struct {
+	key       string
+	labels    map[string]string
+	value     float64
+	hasValue  bool
+	count     float64
+	sum       float64
+	buckets   map[string]float64
+	quantiles map[string]float64
+}
+
+// prometheusSuffixes are the sample name suffixes each metric type uses.
+var prometheusSuffixes = map[string][]string{
+	"counter":        {"_total", "_created"},
+	"histogram":      {"_bucket", "_sum", "_count", "_created"},
+	"gaugehistogram": {"_bucket", "_gsum", "_gcount"},
+	"summary":        {"_sum", "_count", "_created"},
+	"info":           {"_info"},
+}
+
+// parsePrometheusText parses the Prometheus text exposition format and the
+// OpenMetrics text format. Exemplars and sample timestamps are ignored.
+func parsePrometheusText(r io.Reader) ([]*prometheusFamily, error) {
+	types := make(map[string]string)
+	byName := make(map[string]*prometheusFamily)
+	var families []*prometheusFamily
+
+	family := func(name, typ string) *prometheusFamily {
+		f, ok := byName[name]
+		if !ok {
+			f = &prometheusFamily{name: name, typ: typ, index: make(map[string]*prometheusSeries)}
+			byName[name] = f
+			families = append(families, f)
+		}
+		return f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[1] == "EOF" {
+				break
+			}
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = strings.ToLower(fields[3])
+			}
+			continue
+		}
+
+		name, labels, value, err := parsePrometheusSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		familyName, suffix := prometheusFamilyName(name, types)
+		typ := types[familyName]
+		switch typ {
+		case "", "unknown":
+			typ = "untyped"
+		case "stateset", "info":
+			typ = "gauge"
+		}
+		if suffix == "_created" {
+			continue
+		}
+		f := family(familyName, typ)
+
+		var bucket, quantile string
+		switch typ {
+		case "histogram", "gaugehistogram":
+			bucket = labels["le"]
+			delete(labels, "le")
+		case "summary":
+			quantile = labels["quantile"]
+			delete(labels, "quantile")
+		}
+
+		key := labelsKey(labels)
+		series, ok := f.index[key]
+		if !ok {
+			series = &prometheusSeries{key: key, labels: labels}
+			f.index[key] = series
+			f.series = append(f.series, series)
+		}
+
+		switch {
+		case suffix == "_bucket" && bucket != "":
+			if series.buckets == nil {
+				series.buckets = make(map[string]float64)
+			}
+			series.buckets[bucket] = value
+		case suffix == "_count" || suffix == "_gcount":
+			series.count = value
+		case suffix == "_sum" || suffix == "_gsum":
+			series.sum = value
+		case typ == "summary" && quantile != "":
+			if series.quantiles == nil {
+				series.quantiles = make(map[string]float64)
+			}
+			series.quantiles[quantile] = value
+		default:
+			series.value = value
+			series.hasValue = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading metrics: %w", err)
+	}
+
+	return families, nil
+}
+
+// prometheusFamilyName finds the family a sample belongs to, returning the
+// family name and the suffix that was stripped from the sample name.
+func prometheusFamilyName(name string, types map[string]string) (string, string) {
+	if typ, ok := types[name]; ok && typ != "histogram" && typ != "gaugehistogram" && typ != "summary" {
+		return name, ""
+	}
+	for _, typ := range []string{"counter", "histogram", "gaugehistogram", "summary", "info"} {
+		for _, suffix := range prometheusSuffixes[typ] {
+			base, ok := strings.CutSuffix(name, suffix)
+			if ok && types[base] == typ {
+				return base, suffix
+			}
+		}
+	}
+	return name, ""
+}
+
+// parsePrometheusSample parses a line such as
+// `http_requests_total{method="post",code="200"} 1027 1395066363000`.
+func parsePrometheusSample(line string) (string, map[string]string, float64, error) {
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return "", nil, 0, fmt.Errorf("invalid sample %q", line)
+	}
+	name := line[:end]
+	rest := line[end:]
+
+	labels := make(map[string]string)
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		rest, err = parsePrometheusLabels(rest[1:], labels)
+		if err != nil {
+			return "", nil, 0, err
+		}
+	}
+
+	// Drop an OpenMetrics exemplar, then the optional timestamp.
+	if i := strings.Index(rest, " # "); i >= 0 {
+		rest = rest[:i]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", nil, 0, fmt.Errorf("invalid sample %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid value for %s: %q", name, fields[0])
+	}
+
+	return name, labels, value, nil
+}
+
+// parsePrometheusLabels parses `name="value",...}` into labels and returns
+// what follows the closing brace.
+func parsePrometheusLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return "", fmt.Errorf("invalid label in %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return "", fmt.Errorf("invalid value for label %q", name)
+		}
+
+		var value strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return "", fmt.Errorf("unterminated value for label %q", name)
+		}
+		labels[name] = value.String()
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		s = strings.TrimPrefix(s, ",")
+	}
+}
+
+// labelsKey identifies a label set regardless of label order.
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(labels[name])
+		b.WriteByte('\xff')
+	}
+	return b.String()
+}
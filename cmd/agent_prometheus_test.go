@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testExposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A comment that isn't HELP or TYPE.
+# TYPE temperature gauge
+temperature{room="kitchen",note="say \"hi\"\nback\\slash"} 21.5
+temperature{room="attic"} NaN
+
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="0.5"} 129389
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds{quantile="0.99"} NaN
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+
+metric_without_type_or_labels 12.47
+`
+
+func findPrometheusFamily(t *testing.T, families []*prometheusFamily, name string) *prometheusFamily {
+	t.Helper()
+	for _, f := range families {
+		if f.name == name {
+			return f
+		}
+	}
+	t.Fatalf("family %q not found", name)
+	return nil
+}
+
+func TestParsePrometheusText(t *testing.T) {
+	families, err := parsePrometheusText(strings.NewReader(testExposition))
+	require.NoError(t, err)
+	require.Len(t, families, 5)
+
+	counter := findPrometheusFamily(t, families, "http_requests_total")
+	assert.Equal(t, "counter", counter.typ)
+	require.Len(t, counter.series, 2)
+	assert.Equal(t, map[string]string{"method": "post", "code": "200"}, counter.series[0].labels)
+	assert.Equal(t, float64(1027), counter.series[0].value)
+	assert.Equal(t, float64(3), counter.series[1].value)
+
+	gauge := findPrometheusFamily(t, families, "temperature")
+	assert.Equal(t, "gauge", gauge.typ)
+	require.Len(t, gauge.series, 2)
+	assert.Equal(t, "say \"hi\"\nback\\slash", gauge.series[0].labels["note"])
+
+	histogram := findPrometheusFamily(t, families, "http_request_duration_seconds")
+	assert.Equal(t, "histogram", histogram.typ)
+	require.Len(t, histogram.series, 1)
+	assert.Empty(t, histogram.series[0].labels)
+	assert.Equal(t, map[string]float64{"0.05": 24054, "0.5": 129389, "+Inf": 144320}, histogram.series[0].buckets)
+	assert.Equal(t, float64(53423), histogram.series[0].sum)
+	assert.Equal(t, float64(144320), histogram.series[0].count)
+
+	summary := findPrometheusFamily(t, families, "rpc_duration_seconds")
+	assert.Equal(t, "summary", summary.typ)
+	require.Len(t, summary.series, 1)
+	assert.Equal(t, float64(4773), summary.series[0].quantiles["0.5"])
+	assert.Equal(t, float64(2693), summary.series[0].count)
+
+	untyped := findPrometheusFamily(t, families, "metric_without_type_or_labels")
+	assert.Equal(t, "untyped", untyped.typ)
+	assert.Equal(t, 12.47, untyped.series[0].value)
+}
+
+func TestParseOpenMetricsText(t *testing.T) {
+	input := `# TYPE requests counter
+requests_total{path="/"} 10 # {trace_id="abc"} 1.0
+requests_created{path="/"} 1700000000
+# TYPE build info
+build_info{version="1.2.3"} 1
+# EOF
+ignored_after_eof 1
+`
+	families, err := parsePrometheusText(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+
+	assert.Equal(t, "requests", families[0].name)
+	assert.Equal(t, "counter", families[0].typ)
+	require.Len(t, families[0].series, 1)
+	assert.Equal(t, float64(10), families[0].series[0].value)
+
+	assert.Equal(t, "build", families[1].name)
+	assert.Equal(t, "gauge", families[1].typ)
+	assert.Equal(t, "1.2.3", families[1].series[0].labels["version"])
+}
+
+func TestParsePrometheusTextErrors(t *testing.T) {
+	for _, input := range []string{
+		`metric{label="unterminated} 1`,
+		`metric{label=unquoted} 1`,
+		`metric not-a-number`,
+		`metric`,
+	} {
+		_, err := parsePrometheusText(strings.NewReader(input))
+		assert.Error(t, err, "expected error for %q", input)
+	}
+}
+
+func TestLoadPrometheusTargets(t *testing.T) {
+	t.Run("loads targets from config", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.prometheus", []map[string]interface{}{
+			{"name": "node", "url": "http://localhost:9100/metrics", "include": []string{"node_*"}},
+		})
+		targets, err := loadPrometheusTargets()
+		require.NoError(t, err)
+		require.Len(t, targets, 1)
+		assert.Equal(t, "localhost:9100", targets[0].instance)
+		assert.Equal(t, []string{"node_*"}, targets[0].filter.include)
+	})
+
+	tests := []struct {
+		name          string
+		target        map[string]interface{}
+		errorContains string
+	}{
+		{
+			name:          "requires a name",
+			target:        map[string]interface{}{"url": "http://localhost:9100/metrics"},
+			errorContains: "name is required",
+		},
+		{
+			name:          "requires an http url",
+			target:        map[string]interface{}{"name": "node", "url": "localhost:9100"},
+			errorContains: "url must be an http or https URL",
+		},
+		{
+			name:          "rejects malformed patterns",
+			target:        map[string]interface{}{"name": "node", "url": "http://localhost/metrics", "exclude": []string{"[go"}},
+			errorContains: "invalid pattern",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set("agent.prometheus", []map[string]interface{}{tt.target})
+			_, err := loadPrometheusTargets()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+		})
+	}
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	counter := "1027"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept"), "text/plain")
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(strings.Replace(testExposition, "1027", counter, 1)))
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("agent.prometheus", []map[string]interface{}{
+		{"name": "web", "url": server.URL + "/metrics", "exclude": []string{"rpc_*"}},
+	})
+	targets, err := loadPrometheusTargets()
+	require.NoError(t, err)
+	c := newPrometheusCollector("test-host", targets)
+
+	byType := func(payloads []any) map[string][]map[string]any {
+		events := map[string][]map[string]any{}
+		for _, p := range payloads {
+			event := p.(map[string]any)
+			events[event["event_type"].(string)] = append(events[event["event_type"].(string)], event)
+		}
+		return events
+	}
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	events := byType(payloads)
+
+	assert.NotContains(t, events, "report.prometheus.rpc_duration_seconds", "excluded family was reported")
+	require.Len(t, events["report.prometheus.temperature"], 1, "NaN samples should be skipped")
+
+	requestsEvent := events["report.prometheus.http_requests_total"][0]
+	assert.Equal(t, "2026-01-01T00:00:00Z", requestsEvent["ts"])
+	assert.Equal(t, "test-host", requestsEvent["host"])
+	assert.Equal(t, "web", requestsEvent["job"])
+	assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), requestsEvent["instance"])
+	assert.Equal(t, "counter", requestsEvent["metric_type"])
+	assert.Equal(t, "post", requestsEvent["method"])
+	assert.Equal(t, float64(1027), requestsEvent["value"])
+	assert.NotContains(t, requestsEvent, "rate", "first scrape has no rate")
+
+	histogram := events["report.prometheus.http_request_duration_seconds"][0]
+	assert.Equal(t, float64(144320), histogram["count"])
+	assert.Equal(t, float64(53423), histogram["sum"])
+	assert.Equal(t, map[string]float64{"0.05": 24054, "0.5": 129389, "+Inf": 144320}, histogram["buckets"])
+
+	counter = "1127"
+	time.Sleep(10 * time.Millisecond)
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	requestsEvent = byType(payloads)["report.prometheus.http_requests_total"][0]
+	assert.Equal(t, float64(1127), requestsEvent["value"])
+	assert.Greater(t, requestsEvent["rate"], float64(0))
+}
+
+func TestPrometheusCollectorErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := newPrometheusCollector("test-host", []prometheusTarget{
+		{Name: "down", URL: server.URL},
+	})
+	_, err := c.collect("2026-01-01T00:00:00Z")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error scraping down")
+	assert.Contains(t, err.Error(), "503")
+}
+
+func TestPrometheusCollectorResponseTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("up 1\n" + strings.Repeat("#", prometheusMaxBodySize)))
+	}))
+	defer server.Close()
+
+	c := newPrometheusCollector("test-host", []prometheusTarget{
+		{Name: "huge", URL: server.URL},
+	})
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "response is larger than")
+	assert.Empty(t, payloads)
+}
+
+func TestPrometheusLabelConflicts(t *testing.T) {
+	families, err := parsePrometheusText(strings.NewReader(`up{job="other",host="db1",zone="a"} 1`))
+	require.NoError(t, err)
+
+	events, _ := prometheusEvents(
+		"test-host", "2026-01-01T00:00:00Z", prometheusTarget{Name: "web"}, families, time.Now(), nil,
+	)
+	require.Len(t, events, 1)
+	event := events[0].(map[string]any)
+	assert.Equal(t, "web", event["job"])
+	assert.Equal(t, "other", event["exported_job"])
+	assert.Equal(t, "test-host", event["host"])
+	assert.Equal(t, "db1", event["exported_host"])
+	assert.Equal(t, "a", event["zone"])
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultBatchMaxBytes = 1024 * 1024
+
+// eventsResponseError is returned when the events endpoint responds with a
+// non-2xx status.
+type eventsResponseError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *eventsResponseError) Error() string {
+	return fmt.Sprintf("received error response: %s\n%s", e.Status, e.Body)
+}
+
+// droppedEventsError is returned when the events endpoint rejected events
+// in a way that retrying them won't fix, such as a malformed event, so they
+// were dropped.
+type droppedEventsError struct {
+	count int
+	errs  []error
+}
+
+func (e *droppedEventsError) Error() string {
+	return fmt.Sprintf("dropped %d events that can't be delivered: %v", e.count, errors.Join(e.errs...))
+}
+
+func (e *droppedEventsError) add(count int, err error) {
+	e.count += count
+	e.errs = append(e.errs, err)
+}
+
+// splitDropped separates the events dropped as undeliverable from the other
+// errors returned by send.
+func splitDropped(err error) (*droppedEventsError, error) {
+	if dropped, ok := err.(*droppedEventsError); ok {
+		return dropped, nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return nil, err
+	}
+	var dropped *droppedEventsError
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		if d, ok := e.(*droppedEventsError); ok {
+			dropped = d
+		} else {
+			errs = append(errs, e)
+		}
+	}
+	return dropped, errors.Join(errs...)
+}
+
+// permanentStatus reports whether a response status means the events will
+// never be accepted. Other client errors, such as an API key that was
+// revoked or hasn't been set up yet, may clear up, so they're retried like
+// server errors.
+func permanentStatus(code int) bool {
+	return code == http.StatusBadRequest ||
+		code == http.StatusRequestEntityTooLarge ||
+		code == http.StatusUnprocessableEntity
+}
+
+// eventSender posts events to Honeybadger's events endpoint as batches of
+// newline-delimited JSON.
+type eventSender struct {
+	endpoint string
+	apiKey   string
+	gzip     bool
+	maxBytes int
+	client   *http.Client
+}
+
+// newEventSender builds an eventSender from the global endpoint and API key
+// and the "agent.batch" section of the config file.
+func newEventSender() *eventSender {
+	maxBytes := viper.GetInt("agent.batch.max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultBatchMaxBytes
+	}
+	return &eventSender{
+		endpoint: viper.GetString("endpoint"),
+		apiKey:   viper.GetString("api_key"),
+		gzip:     viper.GetBool("agent.batch.gzip"),
+		maxBytes: maxBytes,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// send posts events in batches of at most maxBytes and returns the events
+// that could not be delivered so the caller can retry them later. A batch
+// rejected as too large is split in half and retried; an event that is too
+// large on its own is dropped since it can never be delivered, as are
+// batches rejected with any other 4xx status. Dropped events are reported
+// with a *droppedEventsError.
+func (s *eventSender) send(events [][]byte) ([][]byte, error) {
+	var undelivered [][]byte
+	var errs []error
+	dropped := &droppedEventsError{}
+
+	batches := splitBatches(events, s.maxBytes)
+	for i, batch := range batches {
+		failed, err := s.sendBatch(batch, dropped)
+		undelivered = append(undelivered, failed...)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+
+		// If the endpoint can't be reached at all, the remaining batches
+		// would fail the same way.
+		var respErr *eventsResponseError
+		if !errors.As(err, &respErr) {
+			for _, rest := range batches[i+1:] {
+				undelivered = append(undelivered, rest...)
+			}
+			break
+		}
+	}
+
+	if dropped.count > 0 {
+		if len(errs) == 0 {
+			return undelivered, dropped
+		}
+		errs = append(errs, dropped)
+	}
+	return undelivered, errors.Join(errs...)
+}
+
+// sendBatch posts batch and returns the events that could be retried if it
+// fails. Events that can never be delivered are added to dropped instead.
+func (s *eventSender) sendBatch(batch [][]byte, dropped *droppedEventsError) ([][]byte, error) {
+	err := s.post(batch)
+	if err == nil {
+		return nil, nil
+	}
+
+	var respErr *eventsResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusRequestEntityTooLarge {
+		if len(batch) == 1 {
+			dropped.add(1, fmt.Errorf("event is too large to send: %w", err))
+			return nil, nil
+		}
+		mid := len(batch) / 2
+		firstFailed, firstErr := s.sendBatch(batch[:mid], dropped)
+		secondFailed, secondErr := s.sendBatch(batch[mid:], dropped)
+		return append(firstFailed, secondFailed...), errors.Join(firstErr, secondErr)
+	}
+	if respErr != nil && permanentStatus(respErr.StatusCode) {
+		dropped.add(len(batch), err)
+		return nil, nil
+	}
+
+	return batch, err
+}
+
+// post sends a single NDJSON request containing events.
+func (s *eventSender) post(events [][]byte) error {
+	var body bytes.Buffer
+	var w io.Writer = &body
+	var zw *gzip.Writer
+	if s.gzip {
+		zw = gzip.NewWriter(&body)
+		w = zw
+	}
+	for _, event := range events {
+		if _, err := w.Write(event); err != nil {
+			return fmt.Errorf("error encoding metrics: %w", err)
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return fmt.Errorf("error encoding metrics: %w", err)
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("error compressing metrics: %w", err)
+		}
+	}
+
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("%s/v1/events", s.endpoint),
+		&body,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", s.apiKey)
+	if s.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := s.client.Do(req) //nolint:gosec // endpoint is intentionally user-configurable
+	if err != nil {
+		return fmt.Errorf("error sending metrics: %w", err)
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			fmt.Fprintf(os.Stderr, "error closing response body: %v\n", cerr)
+		}
+	}()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &eventsResponseError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       respBody,
+		}
+	}
+
+	return nil
+}
+
+// splitBatches groups events into batches whose NDJSON encoding is at most
+// maxBytes. An event larger than maxBytes gets a batch of its own.
+func splitBatches(events [][]byte, maxBytes int) [][][]byte {
+	var batches [][][]byte
+	var current [][]byte
+	size := 0
+	for _, event := range events {
+		n := len(event) + 1
+		if len(current) > 0 && size+n > maxBytes {
+			batches = append(batches, current)
+			current = nil
+			size = 0
+		}
+		current = append(current, event)
+		size += n
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents(n int) [][]byte {
+	events := make([][]byte, n)
+	for i := range events {
+		events[i] = []byte(fmt.Sprintf(`{"event_type":"test","n":%d}`, i))
+	}
+	return events
+}
+
+func TestSplitBatches(t *testing.T) {
+	events := [][]byte{
+		[]byte(`{"a":1}`), // 8 bytes with newline
+		[]byte(`{"b":2}`),
+		[]byte(`{"c":3}`),
+		[]byte(`{"large":"0123456789012345"}`),
+	}
+
+	batches := splitBatches(events, 16)
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+	assert.Equal(t, [][]byte{events[3]}, batches[2], "oversized events get their own batch")
+
+	assert.Len(t, splitBatches(events, defaultBatchMaxBytes), 1)
+	assert.Empty(t, splitBatches(nil, defaultBatchMaxBytes))
+}
+
+func TestEventSenderSend(t *testing.T) {
+	t.Run("sends all events in a single NDJSON request", func(t *testing.T) {
+		var requests int
+		var received []map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			assert.Equal(t, "/v1/events", r.URL.Path)
+			assert.Equal(t, "test-key", r.Header.Get("X-API-Key"))
+			assert.Empty(t, r.Header.Get("Content-Encoding"))
+			received = append(received, decodeEvents(t, r)...)
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		s := &eventSender{
+			endpoint: server.URL,
+			apiKey:   "test-key",
+			maxBytes: defaultBatchMaxBytes,
+			client:   server.Client(),
+		}
+		undelivered, err := s.send(testEvents(5))
+		require.NoError(t, err)
+		assert.Empty(t, undelivered)
+		assert.Equal(t, 1, requests)
+		assert.Len(t, received, 5)
+	})
+
+	t.Run("gzip-compresses the body when enabled", func(t *testing.T) {
+		var received []map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+			received = append(received, decodeEvents(t, r)...)
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		s := &eventSender{
+			endpoint: server.URL,
+			gzip:     true,
+			maxBytes: defaultBatchMaxBytes,
+			client:   server.Client(),
+		}
+		_, err := s.send(testEvents(3))
+		require.NoError(t, err)
+		assert.Len(t, received, 3)
+	})
+
+	t.Run("splits batches larger than the max size", func(t *testing.T) {
+		var batchSizes []int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			batchSizes = append(batchSizes, len(decodeEvents(t, r)))
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		events := testEvents(10)
+		s := &eventSender{
+			endpoint: server.URL,
+			maxBytes: 3 * (len(events[0]) + 1),
+			client:   server.Client(),
+		}
+		_, err := s.send(events)
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 3, 3, 1}, batchSizes)
+	})
+
+	t.Run("splits batches the server rejects as too large", func(t *testing.T) {
+		var mu sync.Mutex
+		var delivered int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			events := decodeEvents(t, r)
+			if len(events) > 2 {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			mu.Lock()
+			delivered += len(events)
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		s := &eventSender{endpoint: server.URL, maxBytes: defaultBatchMaxBytes, client: server.Client()}
+		undelivered, err := s.send(testEvents(7))
+		require.NoError(t, err)
+		assert.Empty(t, undelivered)
+		assert.Equal(t, 7, delivered)
+	})
+
+	t.Run("drops a single event the server rejects as too large", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}))
+		defer server.Close()
+
+		s := &eventSender{endpoint: server.URL, maxBytes: defaultBatchMaxBytes, client: server.Client()}
+		undelivered, err := s.send(testEvents(1))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "too large")
+		assert.Empty(t, undelivered)
+	})
+
+	t.Run("drops batches rejected with a client error", func(t *testing.T) {
+		for _, status := range []int{http.StatusBadRequest, http.StatusUnprocessableEntity} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(status)
+			}))
+
+			s := &eventSender{endpoint: server.URL, maxBytes: defaultBatchMaxBytes, client: server.Client()}
+			undelivered, err := s.send(testEvents(3))
+			server.Close()
+			var dropped *droppedEventsError
+			require.ErrorAs(t, err, &dropped, "status %d", status)
+			assert.Equal(t, 3, dropped.count)
+			assert.Empty(t, undelivered)
+		}
+	})
+
+	t.Run("retries rate-limited and unauthorized batches", func(t *testing.T) {
+		for _, status := range []int{http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusForbidden} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(status)
+			}))
+
+			events := testEvents(3)
+			s := &eventSender{endpoint: server.URL, maxBytes: defaultBatchMaxBytes, client: server.Client()}
+			undelivered, err := s.send(events)
+			server.Close()
+			require.Error(t, err, "status %d", status)
+			assert.Equal(t, events, undelivered, "status %d", status)
+		}
+	})
+
+	t.Run("returns only the batches that failed", func(t *testing.T) {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests++
+			if requests == 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		events := testEvents(6)
+		s := &eventSender{
+			endpoint: server.URL,
+			maxBytes: 2 * (len(events[0]) + 1),
+			client:   server.Client(),
+		}
+		undelivered, err := s.send(events)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "503")
+		assert.Equal(t, 3, requests)
+		assert.Equal(t, events[2:4], undelivered)
+	})
+
+	t.Run("stops sending when the endpoint is unreachable", func(t *testing.T) {
+		events := testEvents(6)
+		s := &eventSender{
+			endpoint: "http://invalid-endpoint",
+			maxBytes: 2 * (len(events[0]) + 1),
+			client:   &http.Client{Timeout: time.Second},
+		}
+		undelivered, err := s.send(events)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error sending metrics")
+		assert.Equal(t, events, undelivered)
+	})
+}
+
+func TestNewEventSender(t *testing.T) {
+	viper.Reset()
+	viper.Set("endpoint", "https://eu-api.honeybadger.io")
+	viper.Set("api_key", "test-key")
+
+	s := newEventSender()
+	assert.Equal(t, "https://eu-api.honeybadger.io", s.endpoint)
+	assert.Equal(t, "test-key", s.apiKey)
+	assert.False(t, s.gzip)
+	assert.Equal(t, defaultBatchMaxBytes, s.maxBytes)
+
+	viper.Set("agent.batch.gzip", true)
+	viper.Set("agent.batch.max_bytes", 4096)
+	s = newEventSender()
+	assert.True(t, s.gzip)
+	assert.Equal(t, 4096, s.maxBytes)
+}
+
+func TestReportMetricsBatching(t *testing.T) {
+	var requests int
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		received = append(received, decodeEvents(t, r)...)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+	viper.Set("agent.batch.gzip", true)
+
+	err := newAgent("test-host", nil, nil).reportMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests, "expected one request per tick")
+	assert.GreaterOrEqual(t, len(received), 3)
+}
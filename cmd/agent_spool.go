@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultSpoolMaxBytes = 50 * 1024 * 1024
+	defaultSpoolMaxAge   = 24 * time.Hour
+	spoolRetryBase       = 15 * time.Second
+	spoolRetryMax        = 15 * time.Minute
+	spoolReplayBudget    = 5 * 1024 * 1024 // max bytes replayed per attempt
+	spoolSegmentExt      = ".ndjson"
+)
+
+// spool is a bounded on-disk queue for events the agent failed to deliver.
+// Each write creates an NDJSON segment file named after the time it was
+// written and the number of events it holds, so segments sort oldest-first
+// and the queue depth can be read without opening them. Events are stored
+// exactly as they were sent, so replayed events keep their original "ts".
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	lastStamp   int64
+	failures    int
+	nextAttempt time.Time
+}
+
+type spoolSegment struct {
+	path    string
+	written time.Time
+	events  int
+	size    int64
+}
+
+func newSpool(dir string, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating spool directory: %w", err)
+	}
+	return &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+	}, nil
+}
+
+// loadSpool builds the retry spool from the "agent.spool" section of the
+// config file. Returns nil when spooling is disabled. When agent.spool.dir
+// isn't set and the default directory can't be used, such as when HOME is
+// unset or read-only, it warns and disables spooling rather than failing.
+func loadSpool() (*spool, error) {
+	if viper.IsSet("agent.spool.enabled") && !viper.GetBool("agent.spool.enabled") {
+		return nil, nil
+	}
+
+	dir := viper.GetString("agent.spool.dir")
+	configured := dir != ""
+	if !configured {
+		var err error
+		if dir, err = defaultSpoolDir(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: spooling disabled, events that fail to send will be dropped: %v\n", err)
+			return nil, nil
+		}
+	}
+
+	maxBytes := viper.GetInt64("agent.spool.max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultSpoolMaxBytes
+	}
+	maxAge := viper.GetDuration("agent.spool.max_age")
+	if maxAge <= 0 {
+		maxAge = defaultSpoolMaxAge
+	}
+
+	sp, err := newSpool(dir, maxBytes, maxAge)
+	if err != nil && !configured {
+		fmt.Fprintf(
+			os.Stderr,
+			"Warning: spooling disabled, events that fail to send will be dropped: %v (set agent.spool.dir in your config file)\n",
+			err,
+		)
+		return nil, nil
+	}
+	return sp, err
+}
+
+// defaultSpoolDir prefers the state directory systemd provides to the agent
+// service (see install.sh), falling back to the user's cache directory.
+func defaultSpoolDir() (string, error) {
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return filepath.Join(dir, "spool"), nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf(
+			"unable to determine spool directory, set agent.spool.dir in your config file: %w",
+			err,
+		)
+	}
+	return filepath.Join(cacheDir, "honeybadger-cli", "agent-spool"), nil
+}
+
+// write adds events to the spool as a new segment, then enforces the size
+// and age limits.
+func (s *spool) write(events [][]byte) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep segment names unique and ordered even if the clock stalls.
+	stamp := s.now().UnixNano()
+	if stamp <= s.lastStamp {
+		stamp = s.lastStamp + 1
+	}
+	s.lastStamp = stamp
+
+	name := fmt.Sprintf("%020d-%d%s", stamp, len(events), spoolSegmentExt)
+	if err := writeSpoolSegment(filepath.Join(s.dir, name), events); err != nil {
+		return err
+	}
+
+	return s.pruneLocked()
+}
+
+// replay resends spooled segments oldest-first, stopping at the first
+// failure. Events from a segment that could only be partly delivered are
+// written back in its place. Nothing is sent until the backoff from the
+// previous failure has elapsed. Returns the number of events delivered.
+func (s *spool) replay(send func([][]byte) ([][]byte, error)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.now().Before(s.nextAttempt) {
+		return 0, nil
+	}
+	if err := s.pruneLocked(); err != nil {
+		return 0, err
+	}
+
+	segments, err := s.segmentsLocked()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var budget int64
+	for _, seg := range segments {
+		if budget > 0 && budget+seg.size > spoolReplayBudget {
+			break
+		}
+		budget += seg.size
+
+		events, err := readSpoolSegment(seg.path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Discarding unreadable spool segment %s: %v\n", seg.path, err)
+			_ = os.Remove(seg.path)
+			continue
+		}
+
+		undelivered, sendErr := send(events)
+		sent += len(events) - len(undelivered)
+		if len(undelivered) > 0 {
+			if err := s.rewriteSegmentLocked(seg, undelivered); err != nil {
+				return sent, errors.Join(sendErr, err)
+			}
+			s.recordFailureLocked()
+			return sent, sendErr
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return sent, fmt.Errorf("error removing spool segment: %w", err)
+		}
+		if sendErr != nil {
+			// Events that can never be delivered were dropped; keep going.
+			fmt.Fprintf(os.Stderr, "Error replaying spooled events: %v\n", sendErr)
+		}
+	}
+
+	s.failures = 0
+	return sent, nil
+}
+
+// moveTo copies every segment into each of the targets, keeping its place
+// in their queues, and then removes it. Returns the number of events moved.
+func (s *spool) moveTo(targets []*spool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments, err := s.segmentsLocked()
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, seg := range segments {
+		events, err := readSpoolSegment(seg.path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Discarding unreadable spool segment %s: %v\n", seg.path, err)
+			_ = os.Remove(seg.path)
+			continue
+		}
+		for _, t := range targets {
+			t.mu.Lock()
+			err := writeSpoolSegment(filepath.Join(t.dir, filepath.Base(seg.path)), events)
+			if err == nil {
+				err = t.pruneLocked()
+			}
+			t.mu.Unlock()
+			if err != nil {
+				return moved, err
+			}
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return moved, fmt.Errorf("error removing spool segment: %w", err)
+		}
+		moved += len(events)
+	}
+	return moved, nil
+}
+
+// rewriteSegmentLocked replaces a segment with the subset of its events that
+// still need to be delivered, keeping its place in the queue.
+func (s *spool) rewriteSegmentLocked(seg spoolSegment, events [][]byte) error {
+	if len(events) == seg.events {
+		return nil
+	}
+	stamp := seg.written.UnixNano()
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d-%d%s", stamp, len(events), spoolSegmentExt))
+	if err := writeSpoolSegment(path, events); err != nil {
+		return err
+	}
+	if err := os.Remove(seg.path); err != nil {
+		return fmt.Errorf("error removing spool segment: %w", err)
+	}
+	return nil
+}
+
+// recordFailure pushes back the next replay attempt using exponential
+// backoff.
+func (s *spool) recordFailure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordFailureLocked()
+}
+
+func (s *spool) recordFailureLocked() {
+	s.failures++
+	delay := spoolRetryMax
+	if s.failures <= 10 {
+		delay = min(spoolRetryBase<<(s.failures-1), spoolRetryMax)
+	}
+	s.nextAttempt = s.now().Add(delay)
+}
+
+// recordSuccess clears the backoff so the spool is replayed right away.
+func (s *spool) recordSuccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = 0
+	s.nextAttempt = time.Time{}
+}
+
+// depth returns the number of events waiting in the spool.
+func (s *spool) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segments, err := s.segmentsLocked()
+	if err != nil {
+		return 0
+	}
+	total := 0
+	for _, seg := range segments {
+		total += seg.events
+	}
+	return total
+}
+
+// pruneLocked removes segments older than maxAge, then the oldest segments
+// until the spool fits within maxBytes.
+func (s *spool) pruneLocked() error {
+	segments, err := s.segmentsLocked()
+	if err != nil {
+		return err
+	}
+
+	cutoff := s.now().Add(-s.maxAge)
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+
+	dropped := 0
+	for _, seg := range segments {
+		if !seg.written.Before(cutoff) && total <= s.maxBytes {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing spool segment: %w", err)
+		}
+		total -= seg.size
+		dropped += seg.events
+	}
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "Dropped %d spooled events that exceeded spool limits\n", dropped)
+	}
+	return nil
+}
+
+// segmentsLocked lists spool segments, oldest first. Leftover temporary files
+// from an interrupted write are removed.
+func (s *spool) segmentsLocked() ([]spoolSegment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spool directory: %w", err)
+	}
+
+	var segments []spoolSegment
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, spoolSegmentExt+".tmp") {
+			_ = os.Remove(filepath.Join(s.dir, name))
+			continue
+		}
+		stampStr, countStr, ok := strings.Cut(strings.TrimSuffix(name, spoolSegmentExt), "-")
+		if !ok || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		stamp, err := strconv.ParseInt(stampStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(countStr)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, spoolSegment{
+			path:    filepath.Join(s.dir, name),
+			written: time.Unix(0, stamp),
+			events:  count,
+			size:    info.Size(),
+		})
+	}
+	return segments, nil
+}
+
+// writeSpoolSegment writes events to path via a temporary file, so a partly
+// written segment is never replayed.
+func writeSpoolSegment(path string, events [][]byte) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		buf.Write(event)
+		buf.WriteByte('\n')
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("error writing spool segment: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("error writing spool segment: %w", err)
+	}
+	return nil
+}
+
+func readSpoolSegment(path string) ([][]byte, error) {
+	f, err := os.Open(path) // #nosec G304 - path is built from the spool directory listing
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+
+	var events [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), spoolReplayBudget)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		events = append(events, bytes.Clone(line))
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSpool(t *testing.T, maxBytes int64, maxAge time.Duration) (*spool, *time.Time) {
+	t.Helper()
+	sp, err := newSpool(t.TempDir(), maxBytes, maxAge)
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sp.now = func() time.Time { return now }
+	return sp, &now
+}
+
+func TestSpoolWriteAndReplay(t *testing.T) {
+	sp, _ := newTestSpool(t, defaultSpoolMaxBytes, defaultSpoolMaxAge)
+
+	require.NoError(t, sp.write([][]byte{[]byte(`{"ts":"2026-01-01T00:00:00Z","n":1}`)}))
+	require.NoError(t, sp.write([][]byte{
+		[]byte(`{"ts":"2026-01-01T00:01:00Z","n":2}`),
+		[]byte(`{"ts":"2026-01-01T00:01:00Z","n":3}`),
+	}))
+	assert.Equal(t, 3, sp.depth())
+
+	var replayed []string
+	sent, err := sp.replay(func(events [][]byte) ([][]byte, error) {
+		for _, e := range events {
+			replayed = append(replayed, string(e))
+		}
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, sent)
+	assert.Equal(t, []string{
+		`{"ts":"2026-01-01T00:00:00Z","n":1}`,
+		`{"ts":"2026-01-01T00:01:00Z","n":2}`,
+		`{"ts":"2026-01-01T00:01:00Z","n":3}`,
+	}, replayed)
+	assert.Equal(t, 0, sp.depth())
+}
+
+func TestSpoolReplayBackoff(t *testing.T) {
+	sp, now := newTestSpool(t, defaultSpoolMaxBytes, defaultSpoolMaxAge)
+	require.NoError(t, sp.write([][]byte{[]byte(`{"n":1}`)}))
+
+	calls := 0
+	failing := func(events [][]byte) ([][]byte, error) {
+		calls++
+		return events, errors.New("endpoint down")
+	}
+
+	_, err := sp.replay(failing)
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, now.Add(spoolRetryBase), sp.nextAttempt)
+
+	// Within the backoff window nothing is attempted.
+	sent, err := sp.replay(failing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Equal(t, 1, calls)
+
+	// The next failure doubles the delay.
+	*now = now.Add(spoolRetryBase)
+	_, err = sp.replay(failing)
+	require.Error(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, now.Add(2*spoolRetryBase), sp.nextAttempt)
+
+	// Repeated failures are capped at the maximum delay.
+	for range 20 {
+		sp.recordFailure()
+	}
+	assert.Equal(t, now.Add(spoolRetryMax), sp.nextAttempt)
+
+	// A success clears the backoff and the event is still there to replay.
+	sp.recordSuccess()
+	sent, err = sp.replay(func([][]byte) ([][]byte, error) { return nil, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+}
+
+func TestSpoolReplayPartialDelivery(t *testing.T) {
+	sp, _ := newTestSpool(t, defaultSpoolMaxBytes, defaultSpoolMaxAge)
+	require.NoError(t, sp.write([][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`), []byte(`{"n":3}`)}))
+	require.NoError(t, sp.write([][]byte{[]byte(`{"n":4}`)}))
+
+	sent, err := sp.replay(func(events [][]byte) ([][]byte, error) {
+		return events[2:], errors.New("partial failure")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, 2, sp.depth())
+
+	sp.recordSuccess()
+	var replayed []string
+	_, err = sp.replay(func(events [][]byte) ([][]byte, error) {
+		for _, e := range events {
+			replayed = append(replayed, string(e))
+		}
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"n":3}`, `{"n":4}`}, replayed)
+}
+
+func TestSpoolLimits(t *testing.T) {
+	t.Run("drops segments older than max age", func(t *testing.T) {
+		sp, now := newTestSpool(t, defaultSpoolMaxBytes, time.Hour)
+		require.NoError(t, sp.write([][]byte{[]byte(`{"n":1}`)}))
+
+		*now = now.Add(2 * time.Hour)
+		require.NoError(t, sp.write([][]byte{[]byte(`{"n":2}`)}))
+
+		assert.Equal(t, 1, sp.depth())
+	})
+
+	t.Run("drops oldest segments beyond max size", func(t *testing.T) {
+		event := []byte(`{"n":"0123456789"}`)
+		segmentSize := int64(len(event) + 1)
+		sp, _ := newTestSpool(t, 2*segmentSize, defaultSpoolMaxAge)
+
+		for range 3 {
+			require.NoError(t, sp.write([][]byte{event}))
+		}
+		assert.Equal(t, 2, sp.depth())
+	})
+
+	t.Run("ignores unrelated files", func(t *testing.T) {
+		sp, _ := newTestSpool(t, defaultSpoolMaxBytes, defaultSpoolMaxAge)
+		require.NoError(t, os.WriteFile(sp.dir+"/README", []byte("hi"), 0o600))
+		require.NoError(t, sp.write([][]byte{[]byte(`{"n":1}`)}))
+		assert.Equal(t, 1, sp.depth())
+	})
+}
+
+func TestLoadSpool(t *testing.T) {
+	t.Run("uses config values", func(t *testing.T) {
+		viper.Reset()
+		dir := t.TempDir()
+		viper.Set("agent.spool.dir", dir)
+		viper.Set("agent.spool.max_bytes", 1024)
+		viper.Set("agent.spool.max_age", "2h")
+
+		sp, err := loadSpool()
+		require.NoError(t, err)
+		require.NotNil(t, sp)
+		assert.Equal(t, dir, sp.dir)
+		assert.Equal(t, int64(1024), sp.maxBytes)
+		assert.Equal(t, 2*time.Hour, sp.maxAge)
+	})
+
+	t.Run("applies defaults", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.spool.dir", t.TempDir())
+
+		sp, err := loadSpool()
+		require.NoError(t, err)
+		assert.Equal(t, int64(defaultSpoolMaxBytes), sp.maxBytes)
+		assert.Equal(t, defaultSpoolMaxAge, sp.maxAge)
+	})
+
+	t.Run("can be disabled", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.spool.enabled", false)
+
+		sp, err := loadSpool()
+		require.NoError(t, err)
+		assert.Nil(t, sp)
+	})
+
+	t.Run("disables spooling when the default directory is unavailable", func(t *testing.T) {
+		viper.Reset()
+		for _, name := range []string{"STATE_DIRECTORY", "HOME", "XDG_CACHE_HOME", "LocalAppData"} {
+			t.Setenv(name, "")
+		}
+
+		sp, err := loadSpool()
+		require.NoError(t, err)
+		assert.Nil(t, sp)
+	})
+
+	t.Run("fails when the configured directory can't be created", func(t *testing.T) {
+		viper.Reset()
+		file := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(file, nil, 0o600))
+		viper.Set("agent.spool.dir", filepath.Join(file, "spool"))
+
+		_, err := loadSpool()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error creating spool directory")
+	})
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsdMaxPacketSize = 65535
+	// statsdMaxMetrics caps the number of distinct metrics held between
+	// flushes, and statsdMaxSamples the timer values kept per metric for
+	// percentiles.
+	statsdMaxMetrics = 10000
+	statsdMaxSamples = 10000
+	// statsdGaugeExpiry is how many collections a gauge keeps reporting its
+	// last value without being updated. After that it's forgotten, so gauges
+	// that are no longer sent don't take up room under statsdMaxMetrics.
+	statsdGaugeExpiry = 10
+)
+
+// statsdTypes maps StatsD type codes to the metric_type reported in events.
+var statsdTypes = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "histogram",
+	"d":  "distribution",
+	"s":  "set",
+}
+
+// statsdEventFields are the fields the agent sets on StatsD events. Tags with
+// the same name are renamed with an "exported_" prefix.
+var statsdEventFields = map[string]bool{
+	"ts":          true,
+	"event_type":  true,
+	"host":        true,
+	"metric":      true,
+	"metric_type": true,
+	"value":       true,
+	"rate":        true,
+	"count":       true,
+	"sum":         true,
+	"min":         true,
+	"max":         true,
+	"mean":        true,
+	"p50":         true,
+	"p90":         true,
+	"p95":         true,
+	"p99":         true,
+}
+
+// statsdCollector receives StatsD and DogStatsD metrics over UDP and reports
+// what it aggregated since the previous collection as "report.statsd"
+// events. Gauges keep reporting their last value until they expire.
+type statsdCollector struct {
+	hostname string
+	address  string
+
+	conn      net.PacketConn
+	done      chan struct{}
+	listenErr error
+
+	mu        sync.Mutex
+	metrics   map[string]*statsdMetric
+	lastFlush time.Time
+	invalid   int
+	dropped   int
+}
+
+// statsdMetric is the aggregate of one metric name, type, and tag set.
+type statsdMetric struct {
+	name  string
+	typ   string
+	tags  map[string]string
+	value float64 // counter total or gauge value
+	idle  int     // collections since a gauge was last updated
+
+	count   float64
+	sum     float64
+	min     float64
+	max     float64
+	samples []float64
+	set     map[string]struct{}
+}
+
+// statsdSample is a single parsed StatsD line.
+type statsdSample struct {
+	name  string
+	typ   string
+	value string
+	rate  float64
+	tags  map[string]string
+}
+
+func newStatsdCollector(hostname, address string) *statsdCollector {
+	return &statsdCollector{
+		hostname:  hostname,
+		address:   address,
+		metrics:   make(map[string]*statsdMetric),
+		lastFlush: time.Now(),
+	}
+}
+
+func (c *statsdCollector) listen() error {
+	if c.address == "" {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", c.address)
+	if err != nil {
+		c.listenErr = fmt.Errorf("error listening for StatsD metrics on %s: %w", c.address, err)
+		return c.listenErr
+	}
+	c.conn = conn
+	c.done = make(chan struct{})
+	go c.serve()
+	return nil
+}
+
+func (c *statsdCollector) serve() {
+	defer close(c.done)
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		c.handlePacket(string(buf[:n]))
+	}
+}
+
+func (c *statsdCollector) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// handlePacket aggregates every line in a packet.
+func (c *statsdCollector) handlePacket(packet string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// DogStatsD events and service checks aren't metrics.
+		if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+			continue
+		}
+		sample, err := parseStatsdLine(line)
+		if err != nil {
+			c.invalid++
+			continue
+		}
+		if err := c.add(sample); err != nil {
+			c.invalid++
+		}
+	}
+}
+
+// add folds a sample into its aggregate. The caller must hold c.mu.
+func (c *statsdCollector) add(sample statsdSample) error {
+	key := sample.typ + "\xff" + sample.name + "\xff" + labelsKey(sample.tags)
+	m, ok := c.metrics[key]
+	if !ok {
+		if len(c.metrics) >= statsdMaxMetrics {
+			c.dropped++
+			return nil
+		}
+		m = &statsdMetric{name: sample.name, typ: sample.typ, tags: sample.tags}
+		c.metrics[key] = m
+	}
+
+	if sample.typ == "s" {
+		if m.set == nil {
+			m.set = make(map[string]struct{})
+		}
+		m.set[sample.value] = struct{}{}
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(sample.value, 64)
+	if err != nil || !isFinite(value) {
+		return fmt.Errorf("invalid value %q", sample.value)
+	}
+
+	switch sample.typ {
+	case "c":
+		m.value += value / sample.rate
+	case "g":
+		m.idle = 0
+		// A leading sign adjusts the gauge rather than setting it.
+		if strings.HasPrefix(sample.value, "+") || strings.HasPrefix(sample.value, "-") {
+			m.value += value
+		} else {
+			m.value = value
+		}
+	default:
+		if m.count == 0 || value < m.min {
+			m.min = value
+		}
+		if m.count == 0 || value > m.max {
+			m.max = value
+		}
+		m.count += 1 / sample.rate
+		m.sum += value / sample.rate
+		if len(m.samples) < statsdMaxSamples {
+			m.samples = append(m.samples, value)
+		}
+	}
+	return nil
+}
+
+// parseStatsdLine parses a line such as
+// `page.views:1|c|@0.5|#env:prod,region:us` into its parts.
+func parseStatsdLine(line string) (statsdSample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return statsdSample{}, fmt.Errorf("invalid StatsD line %q", line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 || parts[0] == "" {
+		return statsdSample{}, fmt.Errorf("invalid StatsD line %q", line)
+	}
+	sample := statsdSample{name: name, value: parts[0], typ: parts[1], rate: 1}
+	if _, ok := statsdTypes[sample.typ]; !ok {
+		return statsdSample{}, fmt.Errorf("unknown StatsD metric type %q", sample.typ)
+	}
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return statsdSample{}, fmt.Errorf("invalid sample rate %q", part)
+			}
+			sample.rate = rate
+		case strings.HasPrefix(part, "#"):
+			sample.tags = make(map[string]string)
+			for _, tag := range strings.Split(part[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				key, value, ok := strings.Cut(tag, ":")
+				if !ok {
+					value = "true"
+				}
+				sample.tags[key] = value
+			}
+		}
+	}
+
+	return sample, nil
+}
+
+func (c *statsdCollector) collect(timestamp string) ([]any, error) {
+	if c.listenErr != nil {
+		return nil, c.listenErr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	seconds := now.Sub(c.lastFlush).Seconds()
+	c.lastFlush = now
+
+	keys := make([]string, 0, len(c.metrics))
+	for key := range c.metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payloads []any
+	for _, key := range keys {
+		m := c.metrics[key]
+		event := map[string]any{
+			"ts":          timestamp,
+			"event_type":  "report.statsd",
+			"host":        c.hostname,
+			"metric":      m.name,
+			"metric_type": statsdTypes[m.typ],
+		}
+		for name, value := range m.tags {
+			if statsdEventFields[name] {
+				name = "exported_" + name
+			}
+			event[name] = value
+		}
+
+		switch m.typ {
+		case "c":
+			event["value"] = m.value
+			if seconds > 0 {
+				event["rate"] = math.Round(m.value/seconds*100) / 100
+			}
+		case "g":
+			event["value"] = m.value
+		case "s":
+			event["value"] = len(m.set)
+		default:
+			sort.Float64s(m.samples)
+			event["count"] = m.count
+			event["sum"] = m.sum
+			event["min"] = m.min
+			event["max"] = m.max
+			event["mean"] = math.Round(m.sum/m.count*100) / 100
+			event["p50"] = percentile(m.samples, 50)
+			event["p90"] = percentile(m.samples, 90)
+			event["p95"] = percentile(m.samples, 95)
+			event["p99"] = percentile(m.samples, 99)
+		}
+		payloads = append(payloads, event)
+
+		if m.typ == "g" {
+			m.idle++
+		}
+		if m.typ != "g" || m.idle >= statsdGaugeExpiry {
+			delete(c.metrics, key)
+		}
+	}
+
+	var err error
+	if c.invalid > 0 || c.dropped > 0 {
+		err = fmt.Errorf(
+			"ignored %d malformed StatsD lines and %d metrics over the limit of %d",
+			c.invalid, c.dropped, statsdMaxMetrics,
+		)
+		c.invalid, c.dropped = 0, 0
+	}
+	return payloads, err
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected statsdSample
+	}{
+		{
+			line:     "page.views:1|c",
+			expected: statsdSample{name: "page.views", value: "1", typ: "c", rate: 1},
+		},
+		{
+			line: "page.views:2|c|@0.5|#env:prod,canary",
+			expected: statsdSample{
+				name: "page.views", value: "2", typ: "c", rate: 0.5,
+				tags: map[string]string{"env": "prod", "canary": "true"},
+			},
+		},
+		{
+			line:     "queue.depth:-3|g",
+			expected: statsdSample{name: "queue.depth", value: "-3", typ: "g", rate: 1},
+		},
+		{
+			line: "db.query:12.5|ms|#table:users|c:abc123|T1700000000",
+			expected: statsdSample{
+				name: "db.query", value: "12.5", typ: "ms", rate: 1,
+				tags: map[string]string{"table": "users"},
+			},
+		},
+		{
+			line:     "users.unique:alice|s",
+			expected: statsdSample{name: "users.unique", value: "alice", typ: "s", rate: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			sample, err := parseStatsdLine(tt.line)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, sample)
+		})
+	}
+
+	for _, line := range []string{"no-value", ":1|c", "metric:1", "metric:1|x", "metric:1|c|@2"} {
+		_, err := parseStatsdLine(line)
+		assert.Error(t, err, "expected error for %q", line)
+	}
+}
+
+// sendStatsd sends a packet to the collector's socket and waits until the
+// collector has received something.
+func sendStatsd(t *testing.T, c *statsdCollector, packet string) {
+	t.Helper()
+	conn, err := net.Dial("udp", c.conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close() // nolint:errcheck
+
+	_, err = conn.Write([]byte(packet))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.metrics) > 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestStatsdCollector(t *testing.T) {
+	c := newStatsdCollector("test-host", "")
+	for _, packet := range []string{
+		"page.views:1|c|#env:prod\npage.views:2|c|@0.5|#env:prod",
+		"queue.depth:10|g",
+		"queue.depth:-3|g",
+		"db.query:10|ms\ndb.query:20|ms\ndb.query:30|ms\ndb.query:40|h",
+		"users:alice|s\nusers:bob|s\nusers:alice|s",
+		"bogus line",
+		"_e{5,4}:title|text",
+	} {
+		c.handlePacket(packet)
+	}
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ignored 1 malformed StatsD lines")
+
+	events := map[string]map[string]any{}
+	for _, p := range payloads {
+		event := p.(map[string]any)
+		assert.Equal(t, "report.statsd", event["event_type"])
+		assert.Equal(t, "test-host", event["host"])
+		assert.Equal(t, "2026-01-01T00:00:00Z", event["ts"])
+		events[event["metric"].(string)] = event
+	}
+	require.Len(t, events, 4)
+
+	views := events["page.views"]
+	assert.Equal(t, "counter", views["metric_type"])
+	assert.Equal(t, float64(5), views["value"], "sampled counts are scaled up")
+	assert.Equal(t, "prod", views["env"])
+	assert.Contains(t, views, "rate")
+
+	assert.Equal(t, float64(7), events["queue.depth"]["value"])
+
+	query := events["db.query"]
+	assert.Equal(t, "timer", query["metric_type"])
+	assert.Equal(t, float64(3), query["count"])
+	assert.Equal(t, float64(60), query["sum"])
+	assert.Equal(t, float64(10), query["min"])
+	assert.Equal(t, float64(30), query["max"])
+	assert.Equal(t, float64(20), query["mean"])
+	assert.Equal(t, float64(20), query["p50"])
+	assert.Equal(t, float64(30), query["p99"])
+
+	assert.Equal(t, 2, events["users"]["value"])
+
+	// Histograms are aggregated separately from timers of the same name.
+	var histograms int
+	for _, p := range payloads {
+		if p.(map[string]any)["metric_type"] == "histogram" {
+			histograms++
+		}
+	}
+	assert.Equal(t, 1, histograms)
+
+	// Only gauges carry over to the next interval.
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "queue.depth", payloads[0].(map[string]any)["metric"])
+
+	// Until they go without updates for statsdGaugeExpiry collections.
+	for i := 2; i < statsdGaugeExpiry; i++ {
+		payloads, err = c.collect("2026-01-01T00:01:00Z")
+		require.NoError(t, err)
+		require.Len(t, payloads, 1)
+	}
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	assert.Empty(t, payloads)
+}
+
+func TestStatsdCollectorListens(t *testing.T) {
+	c := newStatsdCollector("test-host", "127.0.0.1:0")
+	require.NoError(t, c.listen())
+	sendStatsd(t, c, "deploys:1|c")
+	require.NoError(t, c.close())
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "deploys", payloads[0].(map[string]any)["metric"])
+}
+
+func TestStatsdCollectorWithoutAddress(t *testing.T) {
+	c := newStatsdCollector("test-host", "")
+	require.NoError(t, c.listen())
+	require.NoError(t, c.close())
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Empty(t, payloads)
+}
+
+func TestStatsdCollectorListenError(t *testing.T) {
+	c := newStatsdCollector("test-host", "256.0.0.1:8125")
+	require.Error(t, c.listen())
+	_, err := c.collect("2026-01-01T00:00:00Z")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error listening for StatsD metrics")
+}
+
+func TestStopCollectorsDrainsListeners(t *testing.T) {
+	c := newStatsdCollector("test-host", "127.0.0.1:0")
+	a := &agent{
+		hostname:        "test-host",
+		interval:        time.Hour,
+		collectors:      []scheduledCollector{{name: "statsd", collector: c}},
+		collectorErrors: map[string]string{},
+		collectorRuns:   map[string]time.Time{},
+	}
+
+	var wg sync.WaitGroup
+	stop := a.startCollectors(context.Background(), &wg)
+	sendStatsd(t, c, "jobs.processed:1|c")
+	stop()
+	wg.Wait()
+
+	require.Len(t, a.pending, 1)
+	assert.Equal(t, "jobs.processed", a.pending[0].(map[string]any)["metric"])
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const statusShutdownTimeout = 5 * time.Second
+
+// agentStatus is the JSON document served at /status.
+type agentStatus struct {
+	Healthy             bool                       `json:"healthy"`
+	Hostname            string                     `json:"hostname"`
+	Version             string                     `json:"version"`
+	StartedAt           string                     `json:"started_at"`
+	UptimeSeconds       int64                      `json:"uptime_seconds"`
+	IntervalSeconds     int                        `json:"interval_seconds"`
+	LastSuccessfulSend  *string                    `json:"last_successful_send"`
+	LastSendError       string                     `json:"last_send_error,omitempty"`
+	ConsecutiveFailures int                        `json:"consecutive_failures"`
+	QueuedEvents        int                        `json:"queued_events"`
+	SpooledEvents       int                        `json:"spooled_events"`
+	Collectors          map[string]collectorStatus `json:"collectors"`
+}
+
+// collectorStatus is a collector's entry in agentStatus. LastRun is null
+// until the collector has finished a collection.
+type collectorStatus struct {
+	LastRun   *string `json:"last_run"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+// recordDelivery updates the delivery status after sending events. err is
+// the send error, if any, whether or not the events were then spooled.
+func (a *agent) recordDelivery(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		a.delivery.failures++
+		a.delivery.lastError = err.Error()
+		return
+	}
+	a.delivery.failures = 0
+	a.delivery.lastError = ""
+	a.delivery.lastSuccess = time.Now()
+}
+
+// status reports on the agent's delivery and collectors. It's healthy as
+// long as the most recent attempt to send events succeeded.
+func (a *agent) status() agentStatus {
+	a.mu.Lock()
+	status := agentStatus{
+		Healthy:             a.delivery.failures == 0,
+		Hostname:            a.hostname,
+		Version:             Version,
+		StartedAt:           a.startedAt.UTC().Format(time.RFC3339),
+		UptimeSeconds:       int64(time.Since(a.startedAt).Seconds()),
+		IntervalSeconds:     int(a.interval / time.Second),
+		LastSendError:       a.delivery.lastError,
+		ConsecutiveFailures: a.delivery.failures,
+		QueuedEvents:        len(a.pending),
+		Collectors:          make(map[string]collectorStatus, len(a.collectors)),
+	}
+	destinations := a.destinations
+	if !a.delivery.lastSuccess.IsZero() {
+		ts := a.delivery.lastSuccess.UTC().Format(time.RFC3339)
+		status.LastSuccessfulSend = &ts
+	}
+	for _, c := range a.collectors {
+		var cs collectorStatus
+		if at, ok := a.collectorRuns[c.name]; ok {
+			ts := at.UTC().Format(time.RFC3339)
+			cs.LastRun = &ts
+		}
+		cs.LastError = a.collectorErrors[c.name]
+		status.Collectors[c.name] = cs
+	}
+	a.mu.Unlock()
+
+	if status.Version == "" {
+		status.Version = "dev"
+	}
+	for _, d := range destinations {
+		if d.spool != nil {
+			status.SpooledEvents += d.spool.depth()
+		}
+	}
+	return status
+}
+
+// statusHandler serves /healthz, which responds 200 while the agent is
+// healthy and 503 otherwise, and the JSON /status document.
+func (a *agent) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !a.status().Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "unhealthy") // nolint:errcheck
+			return
+		}
+		fmt.Fprintln(w, "ok") // nolint:errcheck
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(a.status()) // nolint:errcheck
+	})
+	return mux
+}
+
+// serveStatus starts the status endpoint on address. The returned function
+// shuts it down.
+func (a *agent) serveStatus(address string) (func(), error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error starting status endpoint on %s: %w", address, err)
+	}
+	server := &http.Server{Handler: a.statusHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "Error serving status endpoint: %v\n", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx) // nolint:errcheck
+	}, nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getStatus(t *testing.T, handler http.Handler) agentStatus {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var status agentStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	return status
+}
+
+func getHealthz(handler http.Handler) int {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	return rec.Code
+}
+
+func TestAgentStatusEndpoint(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+
+	a := newAgent("test-host", nil, nil)
+	a.collectors = []scheduledCollector{
+		{name: "ok", collector: funcCollector(func(string) ([]any, error) {
+			return []any{map[string]string{"event_type": "test"}}, nil
+		})},
+		{name: "broken", collector: funcCollector(func(string) ([]any, error) {
+			return nil, errors.New("boom")
+		})},
+	}
+	handler := a.statusHandler()
+
+	// Nothing has been sent yet.
+	status := getStatus(t, handler)
+	assert.True(t, status.Healthy)
+	assert.Equal(t, "test-host", status.Hostname)
+	assert.Nil(t, status.LastSuccessfulSend)
+	assert.Nil(t, status.Collectors["ok"].LastRun)
+	assert.Equal(t, http.StatusOK, getHealthz(handler))
+
+	failing.Store(true)
+	require.Error(t, a.reportMetrics())
+	require.Error(t, a.reportMetrics())
+
+	status = getStatus(t, handler)
+	assert.False(t, status.Healthy)
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.Contains(t, status.LastSendError, "500")
+	assert.NotNil(t, status.Collectors["ok"].LastRun)
+	assert.Empty(t, status.Collectors["ok"].LastError)
+	assert.Equal(t, "boom", status.Collectors["broken"].LastError)
+	assert.Equal(t, http.StatusServiceUnavailable, getHealthz(handler))
+
+	failing.Store(false)
+	require.Error(t, a.reportMetrics(), "the broken collector still fails")
+
+	status = getStatus(t, handler)
+	assert.True(t, status.Healthy)
+	assert.Zero(t, status.ConsecutiveFailures)
+	assert.Empty(t, status.LastSendError)
+	assert.NotNil(t, status.LastSuccessfulSend)
+	assert.Equal(t, http.StatusOK, getHealthz(handler))
+}
+
+func TestAgentStatusQueueDepth(t *testing.T) {
+	viper.Reset()
+	sp, err := newSpool(t.TempDir(), defaultSpoolMaxBytes, defaultSpoolMaxAge)
+	require.NoError(t, err)
+	require.NoError(t, sp.write([][]byte{[]byte(`{"a":1}`), []byte(`{"a":2}`)}))
+
+	a := newAgent("test-host", nil, sp)
+	a.pending = []any{map[string]string{"event_type": "test"}}
+
+	status := a.status()
+	assert.Equal(t, 1, status.QueuedEvents)
+	assert.Equal(t, 2, status.SpooledEvents)
+}
+
+func TestAgentServeStatus(t *testing.T) {
+	a := newAgent("test-host", nil, nil)
+	stop, err := a.serveStatus("127.0.0.1:0")
+	require.NoError(t, err)
+	stop()
+
+	_, err = a.serveStatus("256.0.0.1:8787")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error starting status endpoint")
+}
@@ -0,0 +1,450 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	syslogMaxMessageSize = 64 * 1024
+	// syslogMaxMessages caps the messages held between flushes.
+	syslogMaxMessages = 10000
+	// syslogDefaultRateLimit is the default number of messages accepted per
+	// second.
+	syslogDefaultRateLimit = 1000
+	// syslogMaxClockSkew is how far a message's timestamp may be from the
+	// agent's clock before the time it was received is used instead, for
+	// senders whose clocks aren't set.
+	syslogMaxClockSkew = 24 * time.Hour
+)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// syslogConfig is the "agent.syslog" section of the config file. An empty
+// address disables that protocol.
+type syslogConfig struct {
+	udpAddress string
+	tcpAddress string
+	rateLimit  int
+}
+
+// loadSyslogConfig reads the "agent.syslog" section of the config file.
+func loadSyslogConfig() (syslogConfig, error) {
+	cfg := syslogConfig{
+		udpAddress: viper.GetString("agent.syslog.udp"),
+		tcpAddress: viper.GetString("agent.syslog.tcp"),
+		rateLimit:  syslogDefaultRateLimit,
+	}
+	if viper.IsSet("agent.syslog.rate_limit") {
+		cfg.rateLimit = viper.GetInt("agent.syslog.rate_limit")
+		if cfg.rateLimit <= 0 {
+			return syslogConfig{}, fmt.Errorf(
+				"invalid agent.syslog.rate_limit %d: must be a positive number of messages per second",
+				cfg.rateLimit,
+			)
+		}
+	}
+	return cfg, nil
+}
+
+// syslogMessage is a parsed RFC 5424 or RFC 3164 message. Fields the sender
+// left out are empty, and timestamp is zero if it was missing or invalid.
+type syslogMessage struct {
+	timestamp      time.Time
+	facility       string
+	severity       string
+	hostname       string
+	app            string
+	procID         string
+	msgID          string
+	structuredData string
+	msg            string
+}
+
+// syslogCollector receives syslog messages over UDP and TCP and reports each
+// one as a "report.syslog" event. Messages over the rate limit, or beyond
+// syslogMaxMessages between collections, are dropped. The number received
+// and dropped since the previous collection are reported in a
+// "report.syslog.stats" event.
+type syslogCollector struct {
+	hostname string
+	config   syslogConfig
+
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+	wg          sync.WaitGroup
+	listenErr   error
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	messages []syslogMessage
+	tokens   float64
+	lastFill time.Time
+	dropped  int
+}
+
+func newSyslogCollector(hostname string, config syslogConfig) *syslogCollector {
+	return &syslogCollector{
+		hostname: hostname,
+		config:   config,
+		conns:    make(map[net.Conn]struct{}),
+		tokens:   float64(config.rateLimit),
+		lastFill: time.Now(),
+	}
+}
+
+func (c *syslogCollector) listen() error {
+	if c.config.udpAddress != "" {
+		conn, err := net.ListenPacket("udp", c.config.udpAddress)
+		if err != nil {
+			c.listenErr = fmt.Errorf("error listening for syslog messages on udp %s: %w", c.config.udpAddress, err)
+			return c.listenErr
+		}
+		c.udpConn = conn
+		c.wg.Add(1)
+		go c.serveUDP()
+	}
+
+	if c.config.tcpAddress != "" {
+		ln, err := net.Listen("tcp", c.config.tcpAddress)
+		if err != nil {
+			c.listenErr = fmt.Errorf("error listening for syslog messages on tcp %s: %w", c.config.tcpAddress, err)
+			c.close() // nolint:errcheck
+			return c.listenErr
+		}
+		c.tcpListener = ln
+		c.wg.Add(1)
+		go c.serveTCP()
+	}
+	return nil
+}
+
+func (c *syslogCollector) serveUDP() {
+	defer c.wg.Done()
+	buf := make([]byte, syslogMaxMessageSize)
+	for {
+		n, _, err := c.udpConn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		c.receive(strings.TrimRight(string(buf[:n]), "\r\n\x00"))
+	}
+}
+
+func (c *syslogCollector) serveTCP() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.tcpListener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		c.conns[conn] = struct{}{}
+		c.mu.Unlock()
+
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			defer func() {
+				c.mu.Lock()
+				delete(c.conns, conn)
+				c.mu.Unlock()
+				conn.Close() // nolint:errcheck
+			}()
+			c.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn reads messages from a TCP connection, framed either by octet
+// counting or by newlines (RFC 6587).
+func (c *syslogCollector) serveConn(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, syslogMaxMessageSize)
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+
+		var msg string
+		if first[0] >= '1' && first[0] <= '9' {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if err != nil || n > syslogMaxMessageSize {
+				return
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			msg = string(buf)
+		} else {
+			line, err := r.ReadSlice('\n')
+			if err != nil && !errors.Is(err, bufio.ErrBufferFull) && len(line) == 0 {
+				return
+			}
+			msg = string(line)
+			if errors.Is(err, bufio.ErrBufferFull) {
+				// Skip the rest of an overlong message.
+				for errors.Is(err, bufio.ErrBufferFull) {
+					_, err = r.ReadSlice('\n')
+				}
+			}
+		}
+
+		msg = strings.TrimRight(msg, "\r\n\x00")
+		if msg != "" {
+			c.receive(msg)
+		}
+	}
+}
+
+func (c *syslogCollector) close() error {
+	var errs []error
+	if c.udpConn != nil {
+		errs = append(errs, c.udpConn.Close())
+	}
+	if c.tcpListener != nil {
+		errs = append(errs, c.tcpListener.Close())
+	}
+	c.mu.Lock()
+	for conn := range c.conns {
+		errs = append(errs, conn.Close())
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// receive parses and queues a message, or drops it if the agent is over the
+// rate limit or already holding syslogMaxMessages.
+func (c *syslogCollector) receive(raw string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(c.config.rateLimit)
+	c.tokens = min(c.tokens+now.Sub(c.lastFill).Seconds()*rate, rate)
+	c.lastFill = now
+
+	if c.tokens < 1 || len(c.messages) >= syslogMaxMessages {
+		c.dropped++
+		return
+	}
+	c.tokens--
+	c.messages = append(c.messages, parseSyslogMessage(raw))
+}
+
+// parseSyslogMessage parses an RFC 5424 or RFC 3164 message. It's lenient:
+// anything it can't make sense of ends up in msg.
+func parseSyslogMessage(raw string) syslogMessage {
+	// RFC 3164 says messages without a priority are user.notice.
+	priority := 13
+	rest := raw
+	if strings.HasPrefix(raw, "<") {
+		if end := strings.IndexByte(raw, '>'); end > 1 && end <= 4 {
+			if p, err := strconv.Atoi(raw[1:end]); err == nil && p >= 0 && p < len(syslogFacilities)*8 {
+				priority = p
+				rest = raw[end+1:]
+			}
+		}
+	}
+
+	m := syslogMessage{
+		facility: syslogFacilities[priority/8],
+		severity: syslogSeverities[priority%8],
+	}
+	if after, ok := strings.CutPrefix(rest, "1 "); ok {
+		parseRFC5424(&m, after)
+	} else {
+		parseRFC3164(&m, rest, time.Now())
+	}
+	return m
+}
+
+// parseRFC5424 parses the part of an RFC 5424 message after "<PRI>1 ":
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG.
+func parseRFC5424(m *syslogMessage, s string) {
+	fields := make([]string, 5)
+	for i := range fields {
+		var field string
+		field, s, _ = strings.Cut(s, " ")
+		if field != "-" {
+			fields[i] = field
+		}
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+		m.timestamp = ts
+	}
+	m.hostname, m.app, m.procID, m.msgID = fields[1], fields[2], fields[3], fields[4]
+
+	if strings.HasPrefix(s, "[") {
+		end := structuredDataEnd(s)
+		m.structuredData = s[:end]
+		s = strings.TrimPrefix(s[end:], " ")
+	} else if s == "-" || strings.HasPrefix(s, "- ") {
+		s = strings.TrimPrefix(s[1:], " ")
+	}
+	m.msg = strings.TrimPrefix(s, "\ufeff") // UTF-8 BOM
+}
+
+// structuredDataEnd returns the index just past the last SD-ELEMENT at the
+// start of s, skipping escaped characters inside quoted parameter values.
+func structuredDataEnd(s string) int {
+	inElement, inQuote := false, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case inQuote && s[i] == '\\':
+			i++
+		case s[i] == '"' && inElement:
+			inQuote = !inQuote
+		case inQuote:
+		case s[i] == '[' && !inElement:
+			inElement = true
+		case s[i] == ']' && inElement:
+			inElement = false
+			if i+1 >= len(s) || s[i+1] != '[' {
+				return i + 1
+			}
+		case !inElement:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// parseRFC3164 parses the part of a BSD syslog message after "<PRI>":
+// "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG". Senders often leave out the
+// timestamp or hostname, so only the tag is looked for without them.
+func parseRFC3164(m *syslogMessage, s string, now time.Time) {
+	if len(s) >= 16 && s[15] == ' ' {
+		if ts, err := time.ParseInLocation(time.Stamp, s[:15], time.Local); err == nil {
+			m.timestamp = rfc3164Time(ts, now)
+			s = s[16:]
+			if host, after, ok := strings.Cut(s, " "); ok && !strings.HasSuffix(host, ":") {
+				m.hostname = host
+				s = after
+			}
+		}
+	}
+
+	// The tag ends at the first character that can't be part of it.
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return r == ':' || r == '[' || r == ' '
+	})
+	if end > 0 && end <= 48 {
+		tag := s[:end]
+		after := s[end:]
+		if strings.HasPrefix(after, "[") {
+			if pid, tail, ok := strings.Cut(after[1:], "]"); ok {
+				m.procID = pid
+				after = tail
+			}
+		}
+		if strings.HasPrefix(after, ":") {
+			m.app = tag
+			s = strings.TrimPrefix(after[1:], " ")
+		}
+	}
+	m.msg = s
+}
+
+// rfc3164Time places an RFC 3164 timestamp, which has no year, in the year
+// that puts it closest to now: the current one, or the previous one for a
+// December message received in January.
+func rfc3164Time(ts, now time.Time) time.Time {
+	year := now.Year()
+	if ts.Month() > now.Month()+1 {
+		year--
+	}
+	return time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, ts.Location())
+}
+
+func (c *syslogCollector) collect(timestamp string) ([]any, error) {
+	if c.listenErr != nil {
+		return nil, c.listenErr
+	}
+	if c.config.udpAddress == "" && c.config.tcpAddress == "" {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	messages, dropped := c.messages, c.dropped
+	c.messages, c.dropped = nil, 0
+	c.mu.Unlock()
+
+	received, _ := time.Parse(time.RFC3339, timestamp)
+	payloads := make([]any, 0, len(messages)+1)
+	for _, m := range messages {
+		ts := timestamp
+		if !m.timestamp.IsZero() && m.timestamp.Sub(received).Abs() <= syslogMaxClockSkew {
+			ts = m.timestamp.UTC().Format(time.RFC3339)
+		}
+		event := map[string]any{
+			"ts":         ts,
+			"event_type": "report.syslog",
+			"host":       c.hostname,
+			"facility":   m.facility,
+			"severity":   m.severity,
+			"msg":        m.msg,
+		}
+		for name, value := range map[string]string{
+			"hostname":        m.hostname,
+			"app":             m.app,
+			"proc_id":         m.procID,
+			"msg_id":          m.msgID,
+			"structured_data": m.structuredData,
+		} {
+			if value != "" {
+				event[name] = value
+			}
+		}
+		payloads = append(payloads, event)
+	}
+
+	payloads = append(payloads, syslogStatsPayload{
+		Ts:       timestamp,
+		Event:    "report.syslog.stats",
+		Host:     c.hostname,
+		Received: len(messages) + dropped,
+		Dropped:  dropped,
+	})
+	return payloads, nil
+}
+
+// syslogStatsPayload counts the syslog messages received since the
+// previous collection, including those dropped over the limits.
+type syslogStatsPayload struct {
+	Ts       string `json:"ts"`
+	Event    string `json:"event_type"`
+	Host     string `json:"host"`
+	Received int    `json:"received"`
+	Dropped  int    `json:"dropped"`
+}
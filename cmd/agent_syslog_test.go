@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyslogMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected syslogMessage
+	}{
+		{
+			name: "RFC 5424",
+			raw:  `<165>1 2026-10-11T22:14:15.003Z router1 evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\]lication"] An application event`,
+			expected: syslogMessage{
+				facility: "local4", severity: "notice", hostname: "router1", app: "evntslog",
+				procID: "1234", msgID: "ID47", timestamp: time.Date(2026, 10, 11, 22, 14, 15, 3000000, time.UTC),
+				structuredData: `[exampleSDID@32473 iut="3" eventSource="App\]lication"]`,
+				msg:            "An application event",
+			},
+		},
+		{
+			name: "RFC 5424 with nil fields",
+			raw:  "<34>1 - mymachine su - - - \ufeff'su root' failed",
+			expected: syslogMessage{
+				facility: "auth", severity: "crit", hostname: "mymachine", app: "su",
+				msg: "'su root' failed",
+			},
+		},
+		{
+			name: "RFC 3164",
+			raw:  "<30>Oct  9 22:33:20 ups01 upsd[512]: UPS on battery",
+			expected: syslogMessage{
+				facility: "daemon", severity: "info", hostname: "ups01", app: "upsd",
+				procID: "512", msg: "UPS on battery",
+				timestamp: rfc3164Time(time.Date(0, 10, 9, 22, 33, 20, 0, time.Local), time.Now()),
+			},
+		},
+		{
+			name: "RFC 3164 without a timestamp or hostname",
+			raw:  "<11>switch: port 4 link down",
+			expected: syslogMessage{
+				facility: "user", severity: "err", app: "switch", msg: "port 4 link down",
+			},
+		},
+		{
+			name: "no priority",
+			raw:  "just some text",
+			expected: syslogMessage{
+				facility: "user", severity: "notice", msg: "just some text",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := parseSyslogMessage(tt.raw)
+			assert.True(t, tt.expected.timestamp.Equal(m.timestamp), "timestamp %s", m.timestamp)
+			tt.expected.timestamp = m.timestamp
+			assert.Equal(t, tt.expected, m)
+		})
+	}
+}
+
+func TestRFC3164Time(t *testing.T) {
+	ts := func(month time.Month, day int) time.Time {
+		return time.Date(0, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	now := time.Date(2027, time.January, 1, 0, 0, 30, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, time.December, 31, 12, 0, 0, 0, time.UTC), rfc3164Time(ts(time.December, 31), now))
+	assert.Equal(t, time.Date(2027, time.January, 1, 12, 0, 0, 0, time.UTC), rfc3164Time(ts(time.January, 1), now))
+
+	now = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, time.October, 9, 12, 0, 0, 0, time.UTC), rfc3164Time(ts(time.October, 9), now))
+}
+
+func TestLoadSyslogConfig(t *testing.T) {
+	viper.Reset()
+	cfg, err := loadSyslogConfig()
+	require.NoError(t, err)
+	assert.Equal(t, syslogConfig{rateLimit: syslogDefaultRateLimit}, cfg)
+
+	viper.Set("agent.syslog.udp", "0.0.0.0:514")
+	viper.Set("agent.syslog.rate_limit", 50)
+	cfg, err = loadSyslogConfig()
+	require.NoError(t, err)
+	assert.Equal(t, syslogConfig{udpAddress: "0.0.0.0:514", rateLimit: 50}, cfg)
+
+	viper.Set("agent.syslog.rate_limit", 0)
+	_, err = loadSyslogConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid agent.syslog.rate_limit")
+}
+
+// waitForSyslog waits until the collector has queued n messages.
+func waitForSyslog(t *testing.T, c *syslogCollector, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.messages) >= n
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestSyslogCollector(t *testing.T) {
+	c := newSyslogCollector("test-host", syslogConfig{
+		udpAddress: "127.0.0.1:0",
+		tcpAddress: "127.0.0.1:0",
+		rateLimit:  syslogDefaultRateLimit,
+	})
+	require.NoError(t, c.listen())
+
+	udp, err := net.Dial("udp", c.udpConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer udp.Close() // nolint:errcheck
+	_, err = udp.Write([]byte("<30>Oct  9 22:33:20 ups01 upsd[512]: UPS on battery\n"))
+	require.NoError(t, err)
+	waitForSyslog(t, c, 1)
+
+	tcp, err := net.Dial("tcp", c.tcpListener.Addr().String())
+	require.NoError(t, err)
+	defer tcp.Close() // nolint:errcheck
+	// One newline-delimited and one octet-counted message (RFC 6587).
+	framed := "<165>1 - router1 bgpd - - - neighbor 10.0.0.2 up"
+	_, err = fmt.Fprintf(tcp, "<11>switch: port 4 link down\n%d %s", len(framed), framed)
+	require.NoError(t, err)
+	waitForSyslog(t, c, 3)
+
+	require.NoError(t, c.close())
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 4)
+	assert.Equal(t, map[string]any{
+		"ts":         "2026-01-01T00:00:00Z", // The RFC 3164 timestamp is too far off.
+		"event_type": "report.syslog",
+		"host":       "test-host",
+		"facility":   "daemon",
+		"severity":   "info",
+		"hostname":   "ups01",
+		"app":        "upsd",
+		"proc_id":    "512",
+		"msg":        "UPS on battery",
+	}, payloads[0])
+	assert.Equal(t, "port 4 link down", payloads[1].(map[string]any)["msg"])
+	assert.Equal(t, "neighbor 10.0.0.2 up", payloads[2].(map[string]any)["msg"])
+	assert.Equal(t, "bgpd", payloads[2].(map[string]any)["app"])
+	assert.Equal(t, syslogStatsPayload{
+		Ts:       "2026-01-01T00:00:00Z",
+		Event:    "report.syslog.stats",
+		Host:     "test-host",
+		Received: 3,
+	}, payloads[3])
+
+	payloads, err = c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, 0, payloads[0].(syslogStatsPayload).Received)
+}
+
+func TestSyslogCollectorTimestamps(t *testing.T) {
+	c := newSyslogCollector("test-host", syslogConfig{udpAddress: "127.0.0.1:0", rateLimit: syslogDefaultRateLimit})
+	c.receive("<14>1 2026-01-01T00:00:05.250Z router1 app - - - in range")
+	c.receive("<14>1 1970-01-01T00:00:00Z router1 app - - - unset clock")
+	c.receive("<14>app: no timestamp")
+
+	payloads, err := c.collect("2026-01-01T00:01:00Z")
+	require.NoError(t, err)
+	require.Len(t, payloads, 4)
+	assert.Equal(t, "2026-01-01T00:00:05Z", payloads[0].(map[string]any)["ts"])
+	assert.Equal(t, "2026-01-01T00:01:00Z", payloads[1].(map[string]any)["ts"])
+	assert.Equal(t, "2026-01-01T00:01:00Z", payloads[2].(map[string]any)["ts"])
+}
+
+func TestSyslogCollectorRateLimit(t *testing.T) {
+	c := newSyslogCollector("test-host", syslogConfig{udpAddress: "127.0.0.1:0", rateLimit: 5})
+	for range 20 {
+		c.receive("<14>app: hello")
+	}
+
+	payloads, err := c.collect("2026-01-01T00:00:00Z")
+	require.NoError(t, err)
+	require.NotEmpty(t, payloads)
+	stats := payloads[len(payloads)-1].(syslogStatsPayload)
+	assert.Equal(t, 20, stats.Received)
+	assert.Equal(t, 20-(len(payloads)-1), stats.Dropped)
+	assert.GreaterOrEqual(t, len(payloads)-1, 5)
+	assert.Less(t, len(payloads)-1, 20)
+}
+
+func TestSyslogCollectorListenError(t *testing.T) {
+	c := newSyslogCollector("test-host", syslogConfig{
+		udpAddress: "127.0.0.1:0",
+		tcpAddress: "256.0.0.1:514",
+		rateLimit:  syslogDefaultRateLimit,
+	})
+	require.Error(t, c.listen())
+	_, err := c.collect("2026-01-01T00:00:00Z")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error listening for syslog messages")
+}
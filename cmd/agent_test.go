@@ -1,18 +1,53 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// decodeEvents reads every event from an NDJSON request body.
+func decodeEvents(t *testing.T, r *http.Request) []map[string]interface{} {
+	t.Helper()
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body = zr
+	}
+
+	var events []map[string]interface{}
+	decoder := json.NewDecoder(body)
+	for {
+		var event map[string]interface{}
+		if err := decoder.Decode(&event); err == io.EOF {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
 func TestParseTags(t *testing.T) {
 	t.Run("parses valid key=value tags", func(t *testing.T) {
 		input := []string{"environment=stage", "role=web-1"}
@@ -59,6 +94,14 @@ func TestParseTags(t *testing.T) {
 		}
 	})
 
+	t.Run("rejects fields set by collector events", func(t *testing.T) {
+		for _, key := range []string{"process", "pid", "job", "metric", "source", "app", "trace_id", "os"} {
+			_, err := parseTags([]string{key + "=foo"})
+			require.Error(t, err, "expected error for reserved key %q", key)
+			assert.Contains(t, err.Error(), "reserved metric field")
+		}
+	})
+
 	t.Run("allows host as a tag key", func(t *testing.T) {
 		result, err := parseTags([]string{"host=custom"})
 		require.NoError(t, err)
@@ -196,7 +239,7 @@ func TestMetricsCollection(t *testing.T) {
 		hostname, err := os.Hostname()
 		require.NoError(t, err)
 
-		err = reportMetrics(hostname, nil)
+		err = newAgent(hostname, nil, nil).reportMetrics()
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "error sending metrics")
 	})
@@ -213,14 +256,8 @@ func TestMetricsCollection(t *testing.T) {
 			receivedAPIKey = r.Header.Get("X-API-Key")
 			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
 
-			// Parse and validate payload
-			var event map[string]interface{}
-			decoder := json.NewDecoder(r.Body)
-			err := decoder.Decode(&event)
-			require.NoError(t, err)
-
-			// Store the event
-			receivedEvents = append(receivedEvents, event)
+			// Parse and store the batched events
+			receivedEvents = append(receivedEvents, decodeEvents(t, r)...)
 
 			w.WriteHeader(http.StatusOK)
 		}))
@@ -235,7 +272,7 @@ func TestMetricsCollection(t *testing.T) {
 		interval = 1 // 1 second
 
 		// Run the agent command
-		err := reportMetrics("test-host", nil)
+		err := newAgent("test-host", nil, nil).reportMetrics()
 		require.NoError(t, err)
 
 		// Wait for metrics to be reported
@@ -295,88 +332,37 @@ func TestMetricsCollection(t *testing.T) {
 	})
 }
 
-func TestSendMetricWithTags(t *testing.T) {
-	t.Run("tags are merged into event JSON", func(t *testing.T) {
-		var receivedEvent map[string]interface{}
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			decoder := json.NewDecoder(r.Body)
-			err := decoder.Decode(&receivedEvent)
-			require.NoError(t, err)
-			w.WriteHeader(http.StatusOK)
-		}))
-		defer server.Close()
-
-		viper.Reset()
-		viper.Set("api_key", "test-key")
-		viper.Set("endpoint", server.URL)
-
-		payload := cpuPayload{
-			Ts:    "2026-01-01T00:00:00Z",
-			Event: "report.system.cpu",
-			Host:  "auto-hostname",
-		}
-		tags := map[string]string{"environment": "stage", "role": "web-1"}
-
-		err := sendMetric(payload, tags)
+func TestBuildEventWithTags(t *testing.T) {
+	payload := cpuPayload{
+		Ts:    "2026-01-01T00:00:00Z",
+		Event: "report.system.cpu",
+		Host:  "auto-hostname",
+	}
+	build := func(tags map[string]string) map[string]interface{} {
+		t.Helper()
+		data, err := buildEvent(payload, tags)
 		require.NoError(t, err)
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &event))
+		return event
+	}
 
-		assert.Equal(t, "stage", receivedEvent["environment"])
-		assert.Equal(t, "web-1", receivedEvent["role"])
-		assert.Equal(t, "auto-hostname", receivedEvent["host"])
+	t.Run("tags are merged into event JSON", func(t *testing.T) {
+		event := build(map[string]string{"environment": "stage", "role": "web-1"})
+		assert.Equal(t, "stage", event["environment"])
+		assert.Equal(t, "web-1", event["role"])
+		assert.Equal(t, "auto-hostname", event["host"])
 	})
 
 	t.Run("host tag overrides struct host field", func(t *testing.T) {
-		var receivedEvent map[string]interface{}
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			decoder := json.NewDecoder(r.Body)
-			err := decoder.Decode(&receivedEvent)
-			require.NoError(t, err)
-			w.WriteHeader(http.StatusOK)
-		}))
-		defer server.Close()
-
-		viper.Reset()
-		viper.Set("api_key", "test-key")
-		viper.Set("endpoint", server.URL)
-
-		payload := cpuPayload{
-			Ts:    "2026-01-01T00:00:00Z",
-			Event: "report.system.cpu",
-			Host:  "auto-hostname",
-		}
-		tags := map[string]string{"host": "custom-host"}
-
-		err := sendMetric(payload, tags)
-		require.NoError(t, err)
-
-		assert.Equal(t, "custom-host", receivedEvent["host"])
+		event := build(map[string]string{"host": "custom-host"})
+		assert.Equal(t, "custom-host", event["host"])
 	})
 
 	t.Run("works with no tags", func(t *testing.T) {
-		var receivedEvent map[string]interface{}
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			decoder := json.NewDecoder(r.Body)
-			err := decoder.Decode(&receivedEvent)
-			require.NoError(t, err)
-			w.WriteHeader(http.StatusOK)
-		}))
-		defer server.Close()
-
-		viper.Reset()
-		viper.Set("api_key", "test-key")
-		viper.Set("endpoint", server.URL)
-
-		payload := cpuPayload{
-			Ts:    "2026-01-01T00:00:00Z",
-			Event: "report.system.cpu",
-			Host:  "auto-hostname",
-		}
-
-		err := sendMetric(payload, nil)
-		require.NoError(t, err)
-
-		assert.Equal(t, "auto-hostname", receivedEvent["host"])
-		assert.Equal(t, "report.system.cpu", receivedEvent["event_type"])
+		event := build(nil)
+		assert.Equal(t, "auto-hostname", event["host"])
+		assert.Equal(t, "report.system.cpu", event["event_type"])
 	})
 }
 
@@ -384,11 +370,7 @@ func TestReportMetricsWithTags(t *testing.T) {
 	t.Run("tags appear in all submitted events", func(t *testing.T) {
 		var receivedEvents []map[string]interface{}
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var event map[string]interface{}
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&event); err == nil {
-				receivedEvents = append(receivedEvents, event)
-			}
+			receivedEvents = append(receivedEvents, decodeEvents(t, r)...)
 			w.WriteHeader(http.StatusOK)
 		}))
 		defer server.Close()
@@ -398,7 +380,7 @@ func TestReportMetricsWithTags(t *testing.T) {
 		viper.Set("endpoint", server.URL)
 
 		tags := map[string]string{"environment": "stage", "role": "web-1"}
-		err := reportMetrics("auto-host", tags)
+		err := newAgent("auto-host", tags, nil).reportMetrics()
 		require.NoError(t, err)
 
 		// Should have at least CPU + memory + 1 disk = 3 events
@@ -418,11 +400,7 @@ func TestReportMetricsWithTags(t *testing.T) {
 	t.Run("host tag overrides hostname in all events", func(t *testing.T) {
 		var receivedEvents []map[string]interface{}
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var event map[string]interface{}
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&event); err == nil {
-				receivedEvents = append(receivedEvents, event)
-			}
+			receivedEvents = append(receivedEvents, decodeEvents(t, r)...)
 			w.WriteHeader(http.StatusOK)
 		}))
 		defer server.Close()
@@ -432,7 +410,7 @@ func TestReportMetricsWithTags(t *testing.T) {
 		viper.Set("endpoint", server.URL)
 
 		tags := map[string]string{"host": "custom-host", "environment": "prod"}
-		err := reportMetrics("auto-host", tags)
+		err := newAgent("auto-host", tags, nil).reportMetrics()
 		require.NoError(t, err)
 
 		require.GreaterOrEqual(t, len(receivedEvents), 3)
@@ -446,3 +424,669 @@ func TestReportMetricsWithTags(t *testing.T) {
 		}
 	})
 }
+
+func TestReportMetricsSpool(t *testing.T) {
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+
+	sp, err := newSpool(t.TempDir(), defaultSpoolMaxBytes, defaultSpoolMaxAge)
+	require.NoError(t, err)
+	viper.Set("endpoint", "http://invalid-endpoint")
+	a := newAgent("test-host", nil, sp)
+
+	t.Run("failed events are spooled instead of dropped", func(t *testing.T) {
+
+		err := a.reportMetrics()
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, sp.depth(), 3)
+	})
+
+	t.Run("spooled events are replayed with their original timestamps", func(t *testing.T) {
+		var receivedEvents []map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			receivedEvents = append(receivedEvents, decodeEvents(t, r)...)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		a.destinations[0].sender.endpoint = server.URL
+
+		spooled := sp.depth()
+		time.Sleep(time.Second)
+
+		err := a.reportMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, 0, sp.depth())
+		require.Greater(t, len(receivedEvents), spooled)
+
+		timestamps := map[string]bool{}
+		for _, event := range receivedEvents {
+			timestamps[event["ts"].(string)] = true
+		}
+		assert.Len(t, timestamps, 2, "expected live and replayed events to keep distinct timestamps")
+	})
+}
+
+func TestAgentFlushCheckpoints(t *testing.T) {
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	statePath := filepath.Join(dir, "offsets.json")
+	appendFile(t, path, "existing\n")
+
+	logs := newLogCollector("test-host", []logSource{
+		{Name: "app", Paths: []string{path}, StartAt: "beginning"},
+	}, statePath)
+	defer logs.close() // nolint:errcheck
+
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	viper.Set("endpoint", server.URL)
+
+	a := newAgent("test-host", nil, nil)
+	c := scheduledCollector{name: "logs", collector: logs}
+
+	a.collect(c, "2026-01-01T00:00:00Z")
+	require.Error(t, a.flush("2026-01-01T00:00:00Z"))
+	_, err := os.Stat(statePath)
+	assert.True(t, os.IsNotExist(err), "offsets are saved after an undelivered batch")
+
+	status = http.StatusOK
+	a.collect(c, "2026-01-01T00:01:00Z")
+	a.mu.Lock()
+	assert.Len(t, a.pending, 1, "lines from the undelivered batch are read again")
+	a.mu.Unlock()
+	require.NoError(t, a.flush("2026-01-01T00:01:00Z"))
+	state, err := readLogState(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("existing\n")), state[path].Offset)
+}
+
+// blockingWriter fails every write once release is closed, signaling
+// started when the first write begins.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.started <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return 0, errors.New("disk full")
+}
+
+func TestAgentCollectWaitsForDelivery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "first\n")
+
+	logs := newLogCollector("test-host", []logSource{
+		{Name: "app", Paths: []string{path}, StartAt: "beginning"},
+	}, filepath.Join(dir, "offsets.json"))
+	defer logs.close() // nolint:errcheck
+
+	sink := &blockingWriter{started: make(chan struct{}, 1), release: make(chan struct{})}
+	a := newAgent("test-host", nil, nil)
+	a.sink = sink
+	c := scheduledCollector{name: "logs", collector: logs}
+	require.NoError(t, a.collect(c, "2026-01-01T00:00:00Z"))
+
+	flushed := make(chan error)
+	go func() { flushed <- a.flush("2026-01-01T00:00:00Z") }()
+	<-sink.started
+
+	// A line logged while the batch is being delivered isn't collected
+	// until the batch fails and is rolled back, so it's only read once.
+	appendFile(t, path, "second\n")
+	collected := make(chan error)
+	go func() { collected <- a.collect(c, "2026-01-01T00:01:00Z") }()
+	select {
+	case <-collected:
+		t.Fatal("collected while a batch was being delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(sink.release)
+	require.Error(t, <-flushed)
+	require.NoError(t, <-collected)
+	require.NoError(t, a.collect(c, "2026-01-01T00:02:00Z"))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var messages []string
+	for _, p := range a.pending {
+		messages = append(messages, p.(map[string]any)["message"].(string))
+	}
+	assert.Equal(t, []string{"first", "second"}, messages)
+}
+
+func TestLoadAgentSettings(t *testing.T) {
+	originalInterval := interval
+	defer func() { interval = originalInterval }()
+
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{Use: "agent"}
+		cmd.Flags().IntVarP(&interval, "interval", "i", 60, "Reporting interval in seconds")
+		cmd.Flags().StringVar(&agentCheckIn, "check-in-id", "", "Check-in ID")
+		cmd.Flags().StringVar(&agentSlug, "check-in-slug", "", "Check-in slug")
+		return cmd
+	}
+
+	t.Run("uses the default interval", func(t *testing.T) {
+		viper.Reset()
+		settings, err := loadAgentSettings(newCmd(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, settings.interval)
+	})
+
+	t.Run("uses the config interval when the flag is not set", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.interval", 15)
+		settings, err := loadAgentSettings(newCmd(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, 15*time.Second, settings.interval)
+	})
+
+	t.Run("flag takes precedence over config", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.interval", 15)
+		cmd := newCmd()
+		require.NoError(t, cmd.Flags().Set("interval", "30"))
+		settings, err := loadAgentSettings(cmd, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 30*time.Second, settings.interval)
+	})
+
+	t.Run("rejects non-positive intervals", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.interval", 0)
+		_, err := loadAgentSettings(newCmd(), nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid interval")
+	})
+
+	t.Run("merges flag tags over config tags", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.tags", map[string]interface{}{"environment": "config", "role": "web"})
+		settings, err := loadAgentSettings(newCmd(), map[string]string{"environment": "flag"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"environment": "flag", "role": "web"}, settings.tags)
+	})
+
+	t.Run("loads collector and disk filter options", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.collectors.network.enabled", false)
+		viper.Set("agent.disk.fstypes.include", []string{"ext4", "xfs"})
+		viper.Set("agent.disk.mountpoints.exclude", []string{"/boot*"})
+		settings, err := loadAgentSettings(newCmd(), nil)
+		require.NoError(t, err)
+		assert.False(t, settings.collectors["network"].enabled)
+		assert.Equal(t, []string{"ext4", "xfs"}, settings.fstypeFilter.include)
+		assert.Equal(t, defaultFstypeExclude, settings.fstypeFilter.exclude)
+		assert.Equal(t, []string{"/boot*"}, settings.mountpointFilter.exclude)
+	})
+
+	t.Run("loads the check-in from config", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("endpoint", "https://api.example.com")
+		viper.Set("api_key", "test-key")
+		viper.Set("agent.check_in.slug", "metrics-agent")
+		settings, err := loadAgentSettings(newCmd(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "https://api.example.com/v1/check_in/test-key/metrics-agent", settings.heartbeatURL)
+	})
+
+	t.Run("check-in flags take precedence over config", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("endpoint", "https://api.example.com")
+		viper.Set("agent.check_in.slug", "metrics-agent")
+		cmd := newCmd()
+		require.NoError(t, cmd.Flags().Set("check-in-id", "XyZZy"))
+		settings, err := loadAgentSettings(cmd, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "https://api.example.com/v1/check_in/XyZZy", settings.heartbeatURL)
+	})
+
+	t.Run("uses the first destination's project for the check-in without a global API key", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("endpoint", "https://api.example.com")
+		viper.Set("agent.check_in.slug", "metrics-agent")
+		viper.Set("agent.destinations", []any{
+			map[string]any{"name": "eu", "api_key": "eu-key", "endpoint": "https://eu.example.com"},
+			map[string]any{"name": "us", "api_key": "us-key"},
+		})
+		settings, err := loadAgentSettings(newCmd(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "https://eu.example.com/v1/check_in/eu-key/metrics-agent", settings.heartbeatURL)
+	})
+
+	t.Run("rejects a check-in with both an ID and a slug", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("agent.check_in.id", "XyZZy")
+		viper.Set("agent.check_in.slug", "metrics-agent")
+		_, err := loadAgentSettings(newCmd(), nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot specify both check-in ID and slug")
+	})
+}
+
+func TestAgentCheckIn(t *testing.T) {
+	var failing atomic.Bool
+	var checkIns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/check_in/XyZZy" {
+			assert.Equal(t, http.MethodGet, r.Method)
+			checkIns.Add(1)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+
+	a := newAgent("test-host", nil, nil)
+	a.interval = 10 * time.Millisecond
+	a.collectors = nil
+	a.heartbeatURL = server.URL + "/v1/check_in/XyZZy"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.run(ctx, nil, nil) }()
+
+	require.Eventually(t, func() bool {
+		return checkIns.Load() >= 2
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// Once delivery fails, the agent stops checking in.
+	failing.Store(true)
+	require.Eventually(t, func() bool {
+		return a.status().ConsecutiveFailures >= 2
+	}, 5*time.Second, 5*time.Millisecond)
+	missed := checkIns.Load()
+	require.Eventually(t, func() bool {
+		return a.status().ConsecutiveFailures >= 4
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, missed, checkIns.Load())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestAgentCheckInInBackground(t *testing.T) {
+	release := make(chan struct{})
+	var checkIns, batches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/check_in/XyZZy" {
+			checkIns.Add(1)
+			<-release
+		} else {
+			batches.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+
+	a := newAgent("test-host", nil, nil)
+	a.interval = 10 * time.Millisecond
+	a.collectors = nil
+	a.heartbeatURL = server.URL + "/v1/check_in/XyZZy"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.run(ctx, nil, nil) }()
+
+	// Reporting carries on while a check-in hangs, without starting more.
+	require.Eventually(t, func() bool {
+		return batches.Load() >= 5
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(1), checkIns.Load())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestAgentRun(t *testing.T) {
+	var mu sync.Mutex
+	var receivedEvents []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events := decodeEvents(t, r)
+		mu.Lock()
+		receivedEvents = append(receivedEvents, events...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+
+	t.Run("finishes the report in progress and stops when canceled", func(t *testing.T) {
+		a := newAgent("test-host", nil, nil)
+		a.interval = 10 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- a.run(ctx, nil, nil) }()
+
+		// cpu.Percent blocks for a second, so the first report is still in
+		// progress when the agent is told to stop.
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("agent did not stop")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.GreaterOrEqual(t, len(receivedEvents), 3, "in-flight report was not completed")
+	})
+
+	t.Run("applies reloaded settings", func(t *testing.T) {
+		a := newAgent("test-host", map[string]string{"role": "old"}, nil)
+		a.interval = time.Hour
+
+		reload := make(chan os.Signal)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- a.run(ctx, reload, func() (agentSettings, error) {
+				return agentSettings{
+					interval: 30 * time.Second,
+					tags:     map[string]string{"role": "new"},
+				}, nil
+			})
+		}()
+
+		reload <- syscall.SIGHUP
+		cancel()
+		require.NoError(t, <-done)
+
+		assert.Equal(t, 30*time.Second, a.interval)
+		assert.Equal(t, map[string]string{"role": "new"}, a.tags)
+	})
+
+	t.Run("delivers pending log lines once across a reload", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "app.log")
+		require.NoError(t, os.WriteFile(logPath, []byte("one\n"), 0o600))
+
+		settings := agentSettings{
+			interval:     time.Hour,
+			collectors:   make(map[string]collectorConfig),
+			logSources:   []logSource{{Name: "app", Paths: []string{logPath}, StartAt: "beginning"}},
+			logStatePath: filepath.Join(dir, "offsets.json"),
+		}
+		for _, name := range collectorNames {
+			settings.collectors[name] = collectorConfig{enabled: name == "logs", interval: 10 * time.Millisecond}
+		}
+
+		var out bytes.Buffer
+		a := newAgent("test-host", nil, nil)
+		a.sink = &out
+		a.apply(settings)
+
+		reload := make(chan os.Signal)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- a.run(ctx, reload, func() (agentSettings, error) { return settings, nil })
+		}()
+
+		require.Eventually(t, func() bool {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			return len(a.pending) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		reload <- syscall.SIGHUP
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		require.NoError(t, <-done)
+
+		assert.Equal(t, 1, strings.Count(out.String(), `"message":"one"`))
+	})
+
+	t.Run("keeps current settings when reload fails", func(t *testing.T) {
+		a := newAgent("test-host", map[string]string{"role": "old"}, nil)
+		a.interval = time.Hour
+
+		reload := make(chan os.Signal)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- a.run(ctx, reload, func() (agentSettings, error) {
+				return agentSettings{}, errors.New("bad config")
+			})
+		}()
+
+		reload <- syscall.SIGHUP
+		cancel()
+		require.NoError(t, <-done)
+
+		assert.Equal(t, time.Hour, a.interval)
+		assert.Equal(t, map[string]string{"role": "old"}, a.tags)
+	})
+}
+
+func TestRereadConfigFile(t *testing.T) {
+	viper.Reset()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte("agent:\n  interval: 10\n"), 0o600))
+	viper.SetConfigFile(configPath)
+	require.NoError(t, viper.ReadInConfig())
+	assert.Equal(t, 10, viper.GetInt("agent.interval"))
+
+	require.NoError(t, os.WriteFile(configPath, []byte("agent:\n  interval: 20\n"), 0o600))
+	require.NoError(t, rereadConfigFile())
+	assert.Equal(t, 20, viper.GetInt("agent.interval"))
+
+	require.NoError(t, os.Remove(configPath))
+	require.Error(t, rereadConfigFile())
+}
+
+func TestAgentHealthEvent(t *testing.T) {
+	var receivedEvents []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedEvents = append(receivedEvents, decodeEvents(t, r)...)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("api_key", "test-key")
+	viper.Set("endpoint", server.URL)
+
+	failing := true
+	a := newAgent("test-host", nil, nil)
+	a.collectors = []scheduledCollector{
+		{
+			name: "working",
+			collector: funcCollector(func(ts string) ([]any, error) {
+				return []any{map[string]string{"ts": ts, "event_type": "test.working"}}, nil
+			}),
+		},
+		{
+			name: "broken",
+			collector: funcCollector(func(ts string) ([]any, error) {
+				if failing {
+					return nil, errors.New("load average unavailable")
+				}
+				return []any{map[string]string{"ts": ts, "event_type": "test.broken"}}, nil
+			}),
+		},
+	}
+
+	findHealth := func() map[string]interface{} {
+		for _, event := range receivedEvents {
+			if event["event_type"] == "report.agent.health" {
+				return event
+			}
+		}
+		return nil
+	}
+
+	t.Run("reports failed collectors without dropping the others", func(t *testing.T) {
+		err := a.reportMetrics()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "broken collector: load average unavailable")
+
+		require.Len(t, receivedEvents, 2)
+		assert.Equal(t, "test.working", receivedEvents[0]["event_type"])
+
+		health := findHealth()
+		require.NotNil(t, health)
+		assert.Equal(t, "test-host", health["host"])
+		assert.Equal(t, false, health["healthy"])
+		assert.Equal(t, []interface{}{"working", "broken"}, health["collectors"])
+		assert.Equal(t, []interface{}{"broken"}, health["failed_collectors"])
+		assert.Equal(t, map[string]interface{}{"broken": "load average unavailable"}, health["collector_errors"])
+	})
+
+	t.Run("clears the failure once the collector recovers", func(t *testing.T) {
+		receivedEvents = nil
+		failing = false
+		require.NoError(t, a.reportMetrics())
+
+		require.Len(t, receivedEvents, 3)
+		health := findHealth()
+		require.NotNil(t, health)
+		assert.Equal(t, true, health["healthy"])
+		assert.Equal(t, []interface{}{}, health["failed_collectors"])
+		assert.NotContains(t, health, "collector_errors")
+	})
+}
+
+func TestAgentDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("dry run sent events to Honeybadger")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	readEvents := func(t *testing.T, data []byte) []map[string]any {
+		var events []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var event map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &event))
+			events = append(events, event)
+		}
+		return events
+	}
+
+	t.Run("writes events with tags to the sink", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("api_key", "test-key")
+		viper.Set("endpoint", server.URL)
+
+		var out bytes.Buffer
+		a := newAgent("test-host", map[string]string{"environment": "stage", "host": "web-1"}, nil)
+		a.sink = &out
+		a.collectors = []scheduledCollector{
+			{name: "ok", collector: funcCollector(func(timestamp string) ([]any, error) {
+				return []any{map[string]string{"ts": timestamp, "event_type": "test", "host": "test-host"}}, nil
+			})},
+			{name: "broken", collector: funcCollector(func(string) ([]any, error) {
+				return nil, errors.New("boom")
+			})},
+		}
+
+		err := a.reportMetrics()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "broken collector: boom")
+
+		events := readEvents(t, out.Bytes())
+		require.Len(t, events, 2)
+		assert.Equal(t, "test", events[0]["event_type"])
+		assert.Equal(t, "report.agent.health", events[1]["event_type"])
+		for _, event := range events {
+			assert.Equal(t, "stage", event["environment"])
+			assert.Equal(t, "web-1", event["host"])
+		}
+		assert.True(t, a.status().Healthy)
+	})
+
+	t.Run("--once writes one report to the output file", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("endpoint", server.URL)
+		viper.Set("agent.tags", map[string]any{"environment": "stage"})
+		for _, name := range collectorNames {
+			viper.Set("agent.collectors."+name+".enabled", name == "memory")
+		}
+		output := filepath.Join(t.TempDir(), "events.ndjson")
+		agentOnce, agentOutput = true, output
+		defer func() { agentOnce, agentOutput = false, "" }()
+
+		require.NoError(t, agentCmd.RunE(agentCmd, []string{}))
+
+		data, err := os.ReadFile(output) // #nosec G304
+		require.NoError(t, err)
+		events := readEvents(t, data)
+		require.Len(t, events, 2)
+		assert.Equal(t, "report.system.memory", events[0]["event_type"])
+		assert.Equal(t, "stage", events[0]["environment"])
+		assert.Equal(t, []any{"memory"}, events[1]["collectors"])
+	})
+
+	t.Run("--once doesn't save log read positions", func(t *testing.T) {
+		viper.Reset()
+		dir := t.TempDir()
+		t.Setenv("STATE_DIRECTORY", dir)
+		logPath := filepath.Join(dir, "app.log")
+		appendFile(t, logPath, "hello\n")
+		viper.Set("agent.logs", []map[string]any{
+			{"name": "app", "paths": []string{logPath}, "start_at": "beginning"},
+		})
+		for _, name := range collectorNames {
+			viper.Set("agent.collectors."+name+".enabled", name == "logs")
+		}
+		output := filepath.Join(dir, "events.ndjson")
+		agentOnce, agentOutput = true, output
+		defer func() { agentOnce, agentOutput = false, "" }()
+
+		require.NoError(t, agentCmd.RunE(agentCmd, []string{}))
+
+		data, err := os.ReadFile(output) // #nosec G304
+		require.NoError(t, err)
+		assert.Equal(t, "hello", readEvents(t, data)[0]["message"])
+		_, err = os.Stat(filepath.Join(dir, "log-offsets.json"))
+		assert.True(t, os.IsNotExist(err), "log read positions were saved")
+	})
+}
+
+func TestAgentPrime(t *testing.T) {
+	network := &networkCollector{hostname: "test-host"}
+	a := newAgent("test-host", nil, nil)
+	a.collectors = []scheduledCollector{
+		{name: "memory", collector: &memoryCollector{hostname: "test-host"}},
+		{name: "network", collector: network},
+	}
+	assert.True(t, a.prime())
+	assert.NotNil(t, network.previous.counters, "network baseline wasn't recorded")
+
+	a.collectors = a.collectors[:1]
+	assert.False(t, a.prime())
+}
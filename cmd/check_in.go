@@ -27,56 +27,78 @@ Example:
   hb check-in --id XyZZy
   hb check-in --slug daily-backup --api-key your-project-api-key`,
 	RunE: func(_ *cobra.Command, _ []string) error {
-		if checkInCmdID == "" && checkInCmdSlug == "" {
-			return fmt.Errorf("either check-in ID (--id) or slug (--slug) is required")
-		}
-		if checkInCmdID != "" && checkInCmdSlug != "" {
-			return fmt.Errorf("cannot specify both check-in ID and slug")
+		url, err := checkInURL(checkInCmdID, checkInCmdSlug, "--id", "--slug")
+		if err != nil {
+			return err
 		}
 
-		// API key is only required when using slug
-		apiKey := viper.GetString("api_key")
-		if checkInCmdSlug != "" && apiKey == "" {
-			return fmt.Errorf(
-				"API key is required when using --slug. " +
-					"Set it using --api-key flag or HONEYBADGER_API_KEY environment variable",
-			)
+		if err := sendCheckIn(url); err != nil {
+			return err
 		}
 
-		apiEndpoint := viper.GetString("endpoint")
-		var url string
-		if checkInCmdID != "" {
-			url = fmt.Sprintf("%s/v1/check_in/%s", apiEndpoint, checkInCmdID)
-		} else {
-			url = fmt.Sprintf("%s/v1/check_in/%s/%s", apiEndpoint, apiKey, checkInCmdSlug)
-		}
+		fmt.Fprintln(os.Stderr, "Check-in reported to Honeybadger")
 
-		// Create request with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
-		defer cancel()
+		return nil
+	},
+}
 
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-		if err != nil {
-			return fmt.Errorf("error creating request: %w", err)
-		}
+// checkInURL returns the Reporting API URL for a check-in identified by
+// either its ID or its slug, using the global API key and endpoint. Slugs
+// also need the project API key. idFlag and slugFlag name the options id
+// and slug came from, for error messages.
+func checkInURL(id, slug, idFlag, slugFlag string) (string, error) {
+	return projectCheckInURL(id, slug, viper.GetString("api_key"), viper.GetString("endpoint"), idFlag, slugFlag)
+}
 
-		// Send request
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to send check-in to Honeybadger: %w", err)
-		}
-		defer resp.Body.Close() // nolint:errcheck
+// projectCheckInURL is checkInURL for the project with apiKey, reached at
+// apiEndpoint.
+func projectCheckInURL(id, slug, apiKey, apiEndpoint, idFlag, slugFlag string) (string, error) {
+	if id == "" && slug == "" {
+		return "", fmt.Errorf("either check-in ID (%s) or slug (%s) is required", idFlag, slugFlag)
+	}
+	if id != "" && slug != "" {
+		return "", fmt.Errorf("cannot specify both check-in ID and slug")
+	}
 
-		// Check response status
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
-		}
+	// API key is only required when using slug
+	if slug != "" && apiKey == "" {
+		return "", fmt.Errorf(
+			"API key is required when using %s. "+
+				"Set it using --api-key flag or HONEYBADGER_API_KEY environment variable",
+			slugFlag,
+		)
+	}
 
-		fmt.Fprintln(os.Stderr, "Check-in reported to Honeybadger")
+	if id != "" {
+		return fmt.Sprintf("%s/v1/check_in/%s", apiEndpoint, id), nil
+	}
+	return fmt.Sprintf("%s/v1/check_in/%s/%s", apiEndpoint, apiKey, slug), nil
+}
 
-		return nil
-	},
+// sendCheckIn reports a successful check-in to url.
+func sendCheckIn(url string) error {
+	// Create request with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Send request
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send check-in to Honeybadger: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
+	}
+	return nil
 }
 
 func init() {
@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	eventsFields    []string
+	eventsTags      []string
+	eventsFile      string
+	eventsEventType string
+)
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:     "events",
+	Short:   "Send events to Insights",
+	GroupID: GroupReportingAPI,
+	Long:    `Send custom events to Honeybadger Insights through the Reporting API.`,
+}
+
+// eventsSendCmd represents the events send command
+var eventsSendCmd = &cobra.Command{
+	Use:   "send [json...]",
+	Short: "Send events to Insights",
+	Long: `Send one or more events to Honeybadger Insights.
+
+Events can be given as JSON object arguments, built from --field flags, or
+read as newline-delimited JSON from a file (--file) or stdin. Stdin is read
+when no other input is given, unless it's a terminal; use --file - to type
+events in. Events without a "ts" field get the current
+time, and --tag key=value pairs are added to every event, overriding fields
+with the same name.
+
+Field values that are valid JSON numbers, booleans, or null are sent as
+such; anything else is sent as a string.
+
+Examples:
+  hb events send '{"event_type": "backup.finished", "bytes": 1048576}'
+  hb events send --event-type deploy.step --field step=migrate --field seconds=42
+  hb events send --file events.ndjson --tag environment=production
+  ./emit-events.sh | hb events send`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		apiKey := viper.GetString("api_key")
+		if apiKey == "" {
+			return fmt.Errorf(
+				"API key is required. Set it using --api-key flag or HONEYBADGER_API_KEY environment variable",
+			)
+		}
+
+		tags, err := parseTags(eventsTags)
+		if err != nil {
+			return err
+		}
+
+		var events [][]byte
+		for i, arg := range args {
+			event, err := parseEventJSON([]byte(arg), tags)
+			if err != nil {
+				return fmt.Errorf("invalid event in argument %d: %w", i+1, err)
+			}
+			events = append(events, event)
+		}
+
+		if len(eventsFields) > 0 || eventsEventType != "" {
+			event, err := buildFieldEvent(eventsEventType, eventsFields, tags)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+
+		if eventsFile == "" && len(events) == 0 && isTerminal(cmd.InOrStdin()) {
+			return fmt.Errorf("no events to send. Pass events as arguments, with --field, or with --file")
+		}
+
+		if eventsFile != "" || len(events) == 0 {
+			var r io.Reader = cmd.InOrStdin()
+			if eventsFile != "" && eventsFile != "-" {
+				f, err := os.Open(eventsFile) // #nosec G304
+				if err != nil {
+					return fmt.Errorf("error opening events file: %w", err)
+				}
+				defer f.Close() // nolint:errcheck
+				r = f
+			}
+			read, err := readEvents(r, tags)
+			if err != nil {
+				return err
+			}
+			events = append(events, read...)
+		}
+
+		if len(events) == 0 {
+			return fmt.Errorf("no events to send")
+		}
+
+		sender := &eventSender{
+			endpoint: viper.GetString("endpoint"),
+			apiKey:   apiKey,
+			maxBytes: defaultBatchMaxBytes,
+			client:   &http.Client{Timeout: 30 * time.Second},
+		}
+		undelivered, err := sender.send(events)
+		if err != nil {
+			sent := len(events) - len(undelivered)
+			if dropped, _ := splitDropped(err); dropped != nil {
+				sent -= dropped.count
+			}
+			return fmt.Errorf("sent %d of %d events: %w", sent, len(events), err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Sent %d events to Honeybadger\n", len(events))
+		return nil
+	},
+}
+
+// isTerminal reports whether r is a terminal, rather than a pipe or file
+// events could be read from.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// readEvents reads newline-delimited JSON events from r, skipping blank
+// lines.
+func readEvents(r io.Reader, tags map[string]string) ([][]byte, error) {
+	var events [][]byte
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			event, perr := parseEventJSON(data, tags)
+			if perr != nil {
+				return nil, fmt.Errorf("invalid event on line %d: %w", line, perr)
+			}
+			events = append(events, event)
+		}
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading events: %w", err)
+		}
+	}
+}
+
+// parseEventJSON checks that data is a JSON object, adds "ts" if it's
+// missing, and merges in tags. Other values are kept exactly as given.
+func parseEventJSON(data []byte, tags map[string]string) ([]byte, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(data, &event); err != nil || event == nil {
+		return nil, fmt.Errorf("must be a JSON object")
+	}
+	return finishEvent(event, tags)
+}
+
+// buildFieldEvent builds an event from key=value --field flags.
+func buildFieldEvent(eventType string, fields []string, tags map[string]string) ([]byte, error) {
+	event := make(map[string]json.RawMessage, len(fields)+1)
+	if eventType != "" {
+		event["event_type"], _ = json.Marshal(eventType)
+	}
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid field %q: must be in key=value format", field)
+		}
+		event[key] = fieldValue(value)
+	}
+	return finishEvent(event, tags)
+}
+
+// fieldValue returns value as JSON, keeping numbers, booleans, and null and
+// quoting anything else.
+func fieldValue(value string) json.RawMessage {
+	var v any
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		switch v.(type) {
+		case float64, bool, nil:
+			return json.RawMessage(value)
+		}
+	}
+	quoted, _ := json.Marshal(value)
+	return quoted
+}
+
+// finishEvent adds "ts" to event if it's missing and merges in tags,
+// overriding any existing fields.
+func finishEvent(event map[string]json.RawMessage, tags map[string]string) ([]byte, error) {
+	if _, ok := event["ts"]; !ok {
+		event["ts"], _ = json.Marshal(time.Now().UTC().Format(time.RFC3339))
+	}
+	for k, v := range tags {
+		tagJSON, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling tag %q: %w", k, err)
+		}
+		event[k] = tagJSON
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling event: %w", err)
+	}
+	return data, nil
+}
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+	eventsCmd.AddCommand(eventsSendCmd)
+
+	eventsSendCmd.Flags().StringVar(&eventsEventType, "event-type", "", "Event type of the event built from --field flags")
+	eventsSendCmd.Flags().StringArrayVarP(
+		&eventsFields, "field", "f", nil,
+		"Event field in key=value format (repeatable)",
+	)
+	eventsSendCmd.Flags().StringVar(
+		&eventsFile, "file", "",
+		"Read newline-delimited JSON events from a file (- for stdin)",
+	)
+	eventsSendCmd.Flags().StringArrayVarP(
+		&eventsTags, "tag", "t", nil,
+		"Tag in key=value format added to every event (repeatable, e.g. --tag environment=stage)",
+	)
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsSendCommand(t *testing.T) {
+	eventsPath := filepath.Join(t.TempDir(), "events.ndjson")
+	require.NoError(t, os.WriteFile(eventsPath, []byte(
+		`{"event_type": "backup.finished", "bytes": 12345678901234567890}`+"\n\n"+
+			`{"event_type": "backup.started", "ts": "2026-01-01T00:00:00Z"}`+"\n",
+	), 0o600))
+
+	tests := []struct {
+		name           string
+		args           []string
+		stdin          string
+		apiKey         string
+		expectedEvents []map[string]any
+		errorContains  string
+	}{
+		{
+			name:   "JSON arguments",
+			args:   []string{`{"event_type": "a"}`, `{"event_type": "b", "n": 1}`},
+			apiKey: "test-api-key",
+			expectedEvents: []map[string]any{
+				{"event_type": "a"},
+				{"event_type": "b", "n": float64(1)},
+			},
+		},
+		{
+			name: "fields and tags",
+			args: []string{
+				"--event-type", "deploy.step",
+				"-f", "step=migrate", "-f", "seconds=42", "-f", "ok=true", "-f", "version=007",
+				"--tag", "environment=production", "--tag", "step=override",
+			},
+			apiKey: "test-api-key",
+			expectedEvents: []map[string]any{{
+				"event_type":  "deploy.step",
+				"step":        "override",
+				"seconds":     float64(42),
+				"ok":          true,
+				"version":     "007",
+				"environment": "production",
+			}},
+		},
+		{
+			name:   "file",
+			args:   []string{"--file", eventsPath},
+			apiKey: "test-api-key",
+			expectedEvents: []map[string]any{
+				{"event_type": "backup.finished", "bytes": float64(12345678901234567890)},
+				{"event_type": "backup.started", "ts": "2026-01-01T00:00:00Z"},
+			},
+		},
+		{
+			name:   "stdin",
+			stdin:  `{"event_type": "a"}` + "\n" + `{"event_type": "b"}`,
+			apiKey: "test-api-key",
+			expectedEvents: []map[string]any{
+				{"event_type": "a"},
+				{"event_type": "b"},
+			},
+		},
+		{
+			name:          "missing api key",
+			args:          []string{`{"event_type": "a"}`},
+			errorContains: "API key is required",
+		},
+		{
+			name:          "line that isn't an object",
+			stdin:         `{"event_type": "a"}` + "\n" + `["b"]` + "\n",
+			apiKey:        "test-api-key",
+			errorContains: "invalid event on line 2: must be a JSON object",
+		},
+		{
+			name:          "argument that isn't JSON",
+			args:          []string{"event_type=a"},
+			apiKey:        "test-api-key",
+			errorContains: "invalid event in argument 1",
+		},
+		{
+			name:          "reserved tag",
+			args:          []string{"--tag", "ts=now", `{"event_type": "a"}`},
+			apiKey:        "test-api-key",
+			errorContains: `"ts" is a reserved metric field`,
+		},
+		{
+			name:          "malformed field",
+			args:          []string{"--field", "step"},
+			apiKey:        "test-api-key",
+			errorContains: `invalid field "step"`,
+		},
+		{
+			name:          "no events",
+			stdin:         "\n",
+			apiKey:        "test-api-key",
+			errorContains: "no events to send",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v1/events", r.URL.Path)
+				assert.Equal(t, tt.apiKey, r.Header.Get("X-API-Key"))
+				received = append(received, decodeEvents(t, r)...)
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer server.Close()
+
+			viper.Reset()
+			if tt.apiKey != "" {
+				viper.Set("api_key", tt.apiKey)
+			}
+			viper.Set("endpoint", server.URL)
+
+			// Create a new command for each test to reset flags
+			cmd := &cobra.Command{Use: "send"}
+			cmd.Flags().StringVar(&eventsEventType, "event-type", "", "")
+			cmd.Flags().StringArrayVarP(&eventsFields, "field", "f", nil, "")
+			cmd.Flags().StringVar(&eventsFile, "file", "", "")
+			cmd.Flags().StringArrayVarP(&eventsTags, "tag", "t", nil, "")
+			cmd.RunE = eventsSendCmd.RunE
+			cmd.SetArgs(tt.args)
+			cmd.SetIn(strings.NewReader(tt.stdin))
+
+			err := cmd.Execute()
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				assert.Empty(t, received)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Len(t, received, len(tt.expectedEvents))
+			for i, expected := range tt.expectedEvents {
+				if _, ok := expected["ts"]; !ok {
+					assert.NotEmpty(t, received[i]["ts"])
+					delete(received[i], "ts")
+				}
+				assert.Equal(t, expected, received[i])
+			}
+		})
+	}
+
+	t.Run("server error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		viper.Reset()
+		viper.Set("api_key", "test-api-key")
+		viper.Set("endpoint", server.URL)
+		eventsFields, eventsTags, eventsFile, eventsEventType = nil, nil, "", ""
+
+		err := eventsSendCmd.RunE(eventsSendCmd, []string{`{"event_type": "a"}`})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "sent 0 of 1 events")
+		assert.Contains(t, err.Error(), "403")
+	})
+
+	t.Run("doesn't wait for events on a terminal", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("uses /dev/null as a character device")
+		}
+		// /dev/null is a character device, like a terminal.
+		devNull, err := os.Open(os.DevNull)
+		require.NoError(t, err)
+		defer devNull.Close() // nolint:errcheck
+
+		viper.Reset()
+		viper.Set("api_key", "test-api-key")
+		eventsFields, eventsTags, eventsFile, eventsEventType = nil, nil, "", ""
+		cmd := &cobra.Command{Use: "send", RunE: eventsSendCmd.RunE}
+		cmd.SetArgs(nil)
+		cmd.SetIn(devNull)
+
+		err = cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Pass events as arguments")
+	})
+}
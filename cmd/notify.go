@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/honeybadger-io/cli/internal/crash"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const notifierURL = "https://github.com/honeybadger-io/cli"
+
+var (
+	notifyClass         string
+	notifyMessage       string
+	notifyBacktrace     string
+	notifyBacktraceFile string
+	notifyContext       []string
+	notifyComponent     string
+	notifyAction        string
+	notifyFingerprint   string
+	notifyEnvironment   string
+)
+
+// noticePayload is an error report for the Reporting API's notices
+// endpoint.
+type noticePayload struct {
+	Notifier noticeNotifier `json:"notifier"`
+	Error    noticeError    `json:"error"`
+	Request  noticeRequest  `json:"request"`
+	Server   noticeServer   `json:"server"`
+}
+
+type noticeNotifier struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Version string `json:"version"`
+}
+
+type noticeError struct {
+	Class       string        `json:"class"`
+	Message     string        `json:"message"`
+	Backtrace   []noticeFrame `json:"backtrace"`
+	Fingerprint string        `json:"fingerprint,omitempty"`
+}
+
+type noticeFrame struct {
+	Number string `json:"number"`
+	File   string `json:"file"`
+	Method string `json:"method"`
+}
+
+type noticeRequest struct {
+	Context   map[string]json.RawMessage `json:"context,omitempty"`
+	Component string                     `json:"component,omitempty"`
+	Action    string                     `json:"action,omitempty"`
+}
+
+type noticeServer struct {
+	EnvironmentName string `json:"environment_name,omitempty"`
+	Hostname        string `json:"hostname,omitempty"`
+	ProjectRoot     string `json:"project_root,omitempty"`
+	Pid             int    `json:"pid"`
+}
+
+// newNotice builds a notice for an error raised on this host.
+func newNotice(class, message string, backtrace []noticeFrame) noticePayload {
+	version := Version
+	if version == "" {
+		version = "dev"
+	}
+	hostname, _ := os.Hostname()
+	projectRoot, _ := os.Getwd()
+	if backtrace == nil {
+		backtrace = []noticeFrame{}
+	}
+	return noticePayload{
+		Notifier: noticeNotifier{Name: "honeybadger-cli", URL: notifierURL, Version: version},
+		Error:    noticeError{Class: class, Message: message, Backtrace: backtrace},
+		Server:   noticeServer{Hostname: hostname, ProjectRoot: projectRoot, Pid: os.Getpid()},
+	}
+}
+
+// notifyCmd represents the notify command
+var notifyCmd = &cobra.Command{
+	Use:     "notify",
+	Short:   "Report an error to Honeybadger",
+	GroupID: GroupReportingAPI,
+	Long: `Report an error (notice) to Honeybadger's Reporting API.
+Errors are grouped into faults by class, message, and backtrace, or by
+--fingerprint when it's given.
+
+The backtrace can be given with --backtrace or read from a file with
+--backtrace-file (- for stdin). If it contains a Go panic, Python traceback,
+Ruby or Java stack trace, or Node.js error, that crash's frames are used,
+along with its class and message unless --class or --message is given. If
+it contains more than one, the last is used, since that's usually the one
+that stopped the program.
+Otherwise the backtrace is read one frame per line: Ruby ("file:line:in
+'method'"), Python ("File "file", line N, in method"), and JavaScript
+("at method (file:line:col)") frames are recognized, and other lines are
+kept as the frame's file.
+
+Example:
+  hb notify --class BackupFailed --message "pg_dump exited with status 1"
+  hb notify -c ImportError -m "bad row 42" --context rows=41 --component importer --action nightly
+  ./import.rb 2> error.log || hb notify -c ImportError -m "import failed" --backtrace-file error.log`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		apiKey := viper.GetString("api_key")
+		if apiKey == "" {
+			return fmt.Errorf(
+				"API key is required. Set it using --api-key flag or HONEYBADGER_API_KEY environment variable",
+			)
+		}
+		backtrace := notifyBacktrace
+		if notifyBacktraceFile != "" {
+			var r io.Reader = cmd.InOrStdin()
+			if notifyBacktraceFile != "-" {
+				f, err := os.Open(notifyBacktraceFile) // #nosec G304
+				if err != nil {
+					return fmt.Errorf("error opening backtrace file: %w", err)
+				}
+				defer f.Close() // nolint:errcheck
+				r = f
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("error reading backtrace: %w", err)
+			}
+			backtrace = string(data)
+		}
+
+		class, message := notifyClass, notifyMessage
+		var frames []noticeFrame
+		if crashes := crash.Parse(backtrace); len(crashes) > 0 {
+			c := crashes[len(crashes)-1]
+			frames = crashFrames(c)
+			if class == "" && message == "" {
+				class, message = c.Class, c.Message
+			}
+		} else {
+			frames = parseBacktrace(backtrace)
+		}
+		if class == "" && message == "" {
+			return fmt.Errorf("an error class (--class) or message (--message) is required")
+		}
+		if class == "" {
+			class = "Error"
+		}
+		notice := newNotice(class, message, frames)
+		notice.Error.Fingerprint = notifyFingerprint
+		notice.Request.Component = notifyComponent
+		notice.Request.Action = notifyAction
+		notice.Server.EnvironmentName = notifyEnvironment
+		for _, field := range notifyContext {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("invalid context %q: must be in key=value format", field)
+			}
+			if notice.Request.Context == nil {
+				notice.Request.Context = make(map[string]json.RawMessage)
+			}
+			notice.Request.Context[key] = fieldValue(value)
+		}
+
+		id, err := sendNotice(notice)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Error reported to Honeybadger (notice ID: %s)\n", id)
+		return nil
+	},
+}
+
+// sendNotice posts notice to the Reporting API and returns its ID.
+func sendNotice(notice noticePayload) (string, error) {
+	jsonPayload, err := json.Marshal(notice)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling notice: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		fmt.Sprintf("%s/v1/notices", viper.GetString("endpoint")),
+		bytes.NewBuffer(jsonPayload),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-Key", viper.GetString("api_key"))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("error parsing response: %w", err)
+	}
+	return result.ID, nil
+}
+
+var (
+	rubyFramePattern   = regexp.MustCompile("^(.+?):(\\d+)(?::in [`'](.*)')?$")
+	pythonFramePattern = regexp.MustCompile(`^File "(.+)", line (\d+)(?:, in (.+))?$`)
+	jsFramePattern     = regexp.MustCompile(`^at (?:(.+?) \()?(.+?):(\d+)(?::\d+)?\)?$`)
+)
+
+// parseBacktrace parses a backtrace with one frame per line. Lines that
+// aren't in a recognized format become frames with just a file.
+func parseBacktrace(text string) []noticeFrame {
+	var frames []noticeFrame
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		frames = append(frames, parseFrame(line))
+	}
+	return frames
+}
+
+// crashFrames converts a crash's stack frames to notice frames.
+func crashFrames(c crash.Crash) []noticeFrame {
+	frames := make([]noticeFrame, 0, len(c.Frames))
+	for _, f := range c.Frames {
+		frame := noticeFrame{File: f.File, Method: f.Function}
+		if f.Line > 0 {
+			frame.Number = strconv.Itoa(f.Line)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// parseFrame parses a single backtrace line.
+func parseFrame(line string) noticeFrame {
+	if m := pythonFramePattern.FindStringSubmatch(line); m != nil {
+		return noticeFrame{File: m[1], Number: m[2], Method: m[3]}
+	}
+	if m := jsFramePattern.FindStringSubmatch(line); m != nil {
+		return noticeFrame{File: m[2], Number: m[3], Method: m[1]}
+	}
+	if m := rubyFramePattern.FindStringSubmatch(line); m != nil {
+		return noticeFrame{File: m[1], Number: m[2], Method: m[3]}
+	}
+	return noticeFrame{File: line}
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.Flags().StringVarP(&notifyClass, "class", "c", "", "Error class (default \"Error\")")
+	notifyCmd.Flags().StringVarP(&notifyMessage, "message", "m", "", "Error message")
+	notifyCmd.Flags().StringVar(&notifyBacktrace, "backtrace", "", "Backtrace, one frame per line")
+	notifyCmd.Flags().StringVar(
+		&notifyBacktraceFile, "backtrace-file", "",
+		"Read the backtrace from a file (- for stdin)",
+	)
+	notifyCmd.Flags().StringArrayVar(
+		&notifyContext, "context", nil,
+		"Context in key=value format (repeatable)",
+	)
+	notifyCmd.Flags().StringVar(&notifyComponent, "component", "", "Component (e.g. the job or script name)")
+	notifyCmd.Flags().StringVar(&notifyAction, "action", "", "Action within the component")
+	notifyCmd.Flags().StringVar(&notifyFingerprint, "fingerprint", "", "Custom fingerprint for grouping errors")
+	notifyCmd.Flags().StringVarP(&notifyEnvironment, "environment", "e", "", "Environment name")
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyCommand(t *testing.T) {
+	backtraceFile := filepath.Join(t.TempDir(), "error.log")
+	require.NoError(t, os.WriteFile(backtraceFile, []byte(
+		"/app/import.rb:42:in `parse_row'\n/app/import.rb:10:in `<main>'\n",
+	), 0o600))
+
+	tests := []struct {
+		name          string
+		args          []string
+		stdin         string
+		apiKey        string
+		status        int
+		check         func(t *testing.T, notice noticePayload)
+		errorContains string
+	}{
+		{
+			name: "all options",
+			args: []string{
+				"--class", "ImportError", "--message", "bad row",
+				"--context", "rows=41", "--context", "file=users.csv",
+				"--component", "importer", "--action", "nightly",
+				"--fingerprint", "import-bad-row", "--environment", "production",
+				"--backtrace-file", backtraceFile,
+			},
+			apiKey: "test-api-key",
+			status: http.StatusCreated,
+			check: func(t *testing.T, notice noticePayload) {
+				assert.Equal(t, "honeybadger-cli", notice.Notifier.Name)
+				assert.Equal(t, "ImportError", notice.Error.Class)
+				assert.Equal(t, "bad row", notice.Error.Message)
+				assert.Equal(t, "import-bad-row", notice.Error.Fingerprint)
+				assert.Equal(t, []noticeFrame{
+					{File: "/app/import.rb", Number: "42", Method: "parse_row"},
+					{File: "/app/import.rb", Number: "10", Method: "<main>"},
+				}, notice.Error.Backtrace)
+				assert.Equal(t, map[string]json.RawMessage{
+					"rows": json.RawMessage("41"),
+					"file": json.RawMessage(`"users.csv"`),
+				}, notice.Request.Context)
+				assert.Equal(t, "importer", notice.Request.Component)
+				assert.Equal(t, "nightly", notice.Request.Action)
+				assert.Equal(t, "production", notice.Server.EnvironmentName)
+				assert.NotZero(t, notice.Server.Pid)
+			},
+		},
+		{
+			name:   "backtrace from stdin",
+			args:   []string{"-m", "boom", "--backtrace-file", "-"},
+			stdin:  "  at main (/app/index.js:3:9)\n",
+			apiKey: "test-api-key",
+			status: http.StatusCreated,
+			check: func(t *testing.T, notice noticePayload) {
+				assert.Equal(t, "Error", notice.Error.Class)
+				assert.Equal(t, []noticeFrame{
+					{File: "/app/index.js", Number: "3", Method: "main"},
+				}, notice.Error.Backtrace)
+			},
+		},
+		{
+			name: "crash from stdin",
+			args: []string{"--backtrace-file", "-"},
+			stdin: "Traceback (most recent call last):\n" +
+				"  File \"/app/job.py\", line 12, in <module>\n" +
+				"    main()\n" +
+				"  File \"/app/job.py\", line 8, in main\n" +
+				"    raise ValueError(\"bad row\")\n" +
+				"ValueError: bad row\n",
+			apiKey: "test-api-key",
+			status: http.StatusCreated,
+			check: func(t *testing.T, notice noticePayload) {
+				assert.Equal(t, "ValueError", notice.Error.Class)
+				assert.Equal(t, "bad row", notice.Error.Message)
+				assert.Equal(t, []noticeFrame{
+					{File: "/app/job.py", Number: "8", Method: "main"},
+					{File: "/app/job.py", Number: "12", Method: "<module>"},
+				}, notice.Error.Backtrace)
+			},
+		},
+		{
+			name: "last of several crashes",
+			args: []string{"--backtrace-file", "-"},
+			stdin: "panic: first [recovered]\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.main()\n" +
+				"\t/app/main.go:5 +0x1d\n\n" +
+				"Traceback (most recent call last):\n" +
+				"  File \"/app/job.py\", line 4, in main\n" +
+				"IndexError: list index out of range\n\n" +
+				"During handling of the above exception, another exception occurred:\n\n" +
+				"Traceback (most recent call last):\n" +
+				"  File \"/app/job.py\", line 9, in <module>\n" +
+				"RuntimeError: import failed\n",
+			apiKey: "test-api-key",
+			status: http.StatusCreated,
+			check: func(t *testing.T, notice noticePayload) {
+				assert.Equal(t, "RuntimeError", notice.Error.Class)
+				assert.Equal(t, "import failed", notice.Error.Message)
+				assert.Equal(t, []noticeFrame{
+					{File: "/app/job.py", Number: "9", Method: "<module>"},
+				}, notice.Error.Backtrace)
+			},
+		},
+		{
+			name:   "no backtrace",
+			args:   []string{"-c", "BackupFailed"},
+			apiKey: "test-api-key",
+			status: http.StatusCreated,
+			check: func(t *testing.T, notice noticePayload) {
+				assert.Equal(t, []noticeFrame{}, notice.Error.Backtrace)
+			},
+		},
+		{
+			name:          "missing api key",
+			args:          []string{"-c", "BackupFailed"},
+			errorContains: "API key is required",
+		},
+		{
+			name:          "missing class and message",
+			args:          []string{},
+			apiKey:        "test-api-key",
+			errorContains: "an error class (--class) or message (--message) is required",
+		},
+		{
+			name:          "malformed context",
+			args:          []string{"-c", "BackupFailed", "--context", "rows"},
+			apiKey:        "test-api-key",
+			errorContains: `invalid context "rows"`,
+		},
+		{
+			name:          "server error",
+			args:          []string{"-c", "BackupFailed"},
+			apiKey:        "bad-key",
+			status:        http.StatusForbidden,
+			errorContains: "unexpected status code: 403",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received *noticePayload
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "POST", r.Method)
+				assert.Equal(t, "/v1/notices", r.URL.Path)
+				assert.Equal(t, tt.apiKey, r.Header.Get("X-API-Key"))
+				var notice noticePayload
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&notice))
+				received = &notice
+				w.WriteHeader(tt.status)
+				if tt.status == http.StatusCreated {
+					_, _ = w.Write([]byte(`{"id":"7b4f3a2c-0000-4000-8000-000000000000"}`))
+				}
+			}))
+			defer server.Close()
+
+			viper.Reset()
+			if tt.apiKey != "" {
+				viper.Set("api_key", tt.apiKey)
+			}
+			viper.Set("endpoint", server.URL)
+
+			// Create a new command for each test to reset flags
+			cmd := &cobra.Command{Use: "notify"}
+			cmd.Flags().StringVarP(&notifyClass, "class", "c", "", "")
+			cmd.Flags().StringVarP(&notifyMessage, "message", "m", "", "")
+			cmd.Flags().StringVar(&notifyBacktrace, "backtrace", "", "")
+			cmd.Flags().StringVar(&notifyBacktraceFile, "backtrace-file", "", "")
+			cmd.Flags().StringArrayVar(&notifyContext, "context", nil, "")
+			cmd.Flags().StringVar(&notifyComponent, "component", "", "")
+			cmd.Flags().StringVar(&notifyAction, "action", "", "")
+			cmd.Flags().StringVar(&notifyFingerprint, "fingerprint", "", "")
+			cmd.Flags().StringVarP(&notifyEnvironment, "environment", "e", "", "")
+			cmd.RunE = notifyCmd.RunE
+			cmd.SetArgs(tt.args)
+			cmd.SetIn(strings.NewReader(tt.stdin))
+
+			err := cmd.Execute()
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, received)
+			tt.check(t, *received)
+		})
+	}
+}
+
+func TestParseBacktrace(t *testing.T) {
+	frames := parseBacktrace(strings.Join([]string{
+		"/app/lib/job.rb:12:in `perform'",
+		"  File \"/app/job.py\", line 7, in run",
+		"    at Object.<anonymous> (/app/job.js:5:11)",
+		"    at /app/node_modules/lib.js:1:1",
+		"/usr/local/bin/backup.sh:3",
+		"",
+		"something unrecognized",
+	}, "\n"))
+
+	assert.Equal(t, []noticeFrame{
+		{File: "/app/lib/job.rb", Number: "12", Method: "perform"},
+		{File: "/app/job.py", Number: "7", Method: "run"},
+		{File: "/app/job.js", Number: "5", Method: "Object.<anonymous>"},
+		{File: "/app/node_modules/lib.js", Number: "1"},
+		{File: "/usr/local/bin/backup.sh", Number: "3"},
+		{File: "something unrecognized"},
+	}, frames)
+}
@@ -9,9 +9,15 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/honeybadger-io/cli/internal/crash"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -20,13 +26,22 @@ const (
 	maxOutputSize = 16 * 1024 // 16KB max combined for stdout/stderr sent to API
 	httpTimeout   = 30 * time.Second
 	truncatedMsg  = "\n[output truncated]"
+
+	crashOutputSize = 256 * 1024 // 256KB of stderr kept for finding crashes
+	maxCrashNotices = 10         // notices sent per run, to limit noisy output
+
+	runWaitDelay = 5 * time.Second // how long to wait for output after the command exits
 )
 
 var (
-	checkInID   string
-	slug        string
-	runExitCode int       // stores exit code from wrapped command
-	exitFunc    = os.Exit // injectable for testing
+	checkInID        string
+	slug             string
+	runExitCode      int // stores exit code from wrapped command
+	runNotifyCrashes bool
+	runTimeout       time.Duration
+	runRetries       int
+	runRetryDelay    time.Duration
+	exitFunc         = os.Exit // injectable for testing
 )
 
 type checkInPayload struct {
@@ -36,6 +51,10 @@ type checkInPayload struct {
 		Stdout   string `json:"stdout,omitempty"`
 		Stderr   string `json:"stderr,omitempty"`
 		ExitCode int    `json:"exit_code"`
+		// Lock is set when the run used --lock.
+		Lock *checkInLock `json:"lock,omitempty"`
+		// Usage is the resources used by the command's final attempt.
+		Usage *runUsage `json:"usage,omitempty"`
 	} `json:"check_in"`
 }
 
@@ -91,6 +110,24 @@ func (b *limitedBuffer) String() string {
 	return b.buffer.String()
 }
 
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max  int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if over := len(b.data) - b.max; over > 0 {
+		b.data = append(b.data[:0], b.data[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.data)
+}
+
 // runCmd represents the run command
 var runCmd = &cobra.Command{
 	Use:   "run [command]",
@@ -99,62 +136,144 @@ var runCmd = &cobra.Command{
 This command executes the provided command, captures its output and execution time,
 and reports the results using either a check-in ID or slug.
 
+With --notify-crashes, when the command fails its stderr is searched for
+Go panics, Python tracebacks, Ruby and Java stack traces, and Node.js
+errors, and each one found is also reported as an error (notice) with its
+class, message, and backtrace. This requires an API key.
+
+With --timeout, the command (and any processes it started) is killed if it
+runs too long, and the check-in is reported as an error. With --retries, a
+failed command is run again after --retry-delay, and only the final attempt
+is reported; the duration covers every attempt. SIGINT and SIGTERM are
+forwarded to the command, and the check-in is still sent when it exits. A
+second signal kills it.
+
+Commands that time out exit with status 124, and commands interrupted or
+killed by a signal with 128 plus the signal number.
+
+With --lock, the command only runs while holding the named lock file, so
+that runs of a job that take longer than its schedule don't overlap. If
+another run holds the lock, hb waits up to --lock-wait for it and then skips
+the command, reporting the check-in with --lock-status and --lock-message
+and exiting with status 0 for "success" or 1 for "error". If the lock can't
+be taken at all, the run is skipped and reported as an error. Lock files
+are kept in the user's cache directory unless --lock-dir is given. Lock
+details, including whether it was held by another run, are sent with the
+check-in.
+
+The check-in includes the command's resource usage: user and system CPU
+time, and on Linux and macOS its peak memory (max RSS), block I/O
+operations, and the signal that terminated it, if any. With --job-event,
+the same details are also sent as a report.job Insights event with the
+check-in's slug or ID, for charting jobs over time. This requires an API
+key.
+
 Example:
   hb run --id check-123 -- /usr/local/bin/backup.sh
   hb run --slug daily-backup -- pg_dump -U postgres mydb
+  hb run --slug hourly-sync --timeout 50m --retries 2 --retry-delay 1m -- ./sync.sh
+  hb run --slug hourly-sync --lock hourly-sync --lock-wait 5m -- ./sync.sh
+  hb run --slug nightly-report --job-event -- ./report.sh
+  hb run --slug nightly-import --notify-crashes -- python import.py
 
 Note: Shell operators such as ">" are interpreted by your shell before hb runs,
 so redirection works as usual. If you need more complex shell features, wrap
 them in a shell script and invoke that script with "hb run".`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(_ *cobra.Command, args []string) error {
-		if checkInID == "" && slug == "" {
-			return fmt.Errorf("either check-in ID (--id) or slug (--slug) is required")
+		url, err := checkInURL(checkInID, slug, "--id", "--slug")
+		if err != nil {
+			return err
 		}
-		if checkInID != "" && slug != "" {
-			return fmt.Errorf("cannot specify both check-in ID and slug")
+		if runNotifyCrashes && viper.GetString("api_key") == "" {
+			return fmt.Errorf(
+				"API key is required with --notify-crashes. Set it using --api-key flag or HONEYBADGER_API_KEY environment variable",
+			)
 		}
-
-		// API key is only required when using slug
-		apiKey := viper.GetString("api_key")
-		if slug != "" && apiKey == "" {
+		if runJobEvent && viper.GetString("api_key") == "" {
 			return fmt.Errorf(
-				"API key is required when using --slug. " +
-					"Set it using --api-key flag or HONEYBADGER_API_KEY environment variable",
+				"API key is required with --job-event. Set it using --api-key flag or HONEYBADGER_API_KEY environment variable",
 			)
 		}
 
-		// Prepare command execution
-		command := args[0]
-		var cmdArgs []string
-		if len(args) > 1 {
-			cmdArgs = args[1:]
+		if runRetries < 0 {
+			return fmt.Errorf("--retries must not be negative")
+		}
+		var lockFile string
+		if runLock != "" {
+			if runLockStatus != "success" && runLockStatus != "error" {
+				return fmt.Errorf("invalid --lock-status %q: must be success or error", runLockStatus)
+			}
+			lockFile, err = lockPath(runLockDir, runLock)
+			if err != nil {
+				return err
+			}
 		}
 
-		execCmd := exec.Command(command, cmdArgs...) // nolint:gosec
+		// Forward SIGINT and SIGTERM to the command instead of exiting, so
+		// that the check-in is still sent.
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
 
-		// Use MultiWriter to stream output in real-time while capturing it
-		limiter := &sharedLimiter{remaining: maxOutputSize}
-		stdout := &limitedBuffer{limiter: limiter}
-		stderr := &limitedBuffer{limiter: limiter}
-		execCmd.Stdout = io.MultiWriter(os.Stdout, stdout)
-		execCmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+		var lock *checkInLock
+		if lockFile != "" {
+			f, info, sig, err := acquireRunLock(lockFile, runLock, runLockWait, signals)
+			lock = &info
+			if f == nil {
+				payload, exitCode := skippedRunPayload(info, sig, err)
+				fmt.Fprintln(os.Stderr, payload.CheckIn.Stderr)
+				sendRunCheckIn(url, payload)
+				runExitCode = exitCode
+				if runExitCode != 0 {
+					exitFunc(runExitCode)
+				}
+				return nil
+			}
+			defer f.Close() // nolint:errcheck
+		}
 
-		// Execute command and measure duration
+		var (
+			stdout, stderr *limitedBuffer
+			crashOutput    *tailBuffer
+			result         runResult
+		)
 		startTime := time.Now()
-		execErr := execCmd.Run()
-		durationMs := time.Since(startTime).Milliseconds()
+		for attempt := 1; ; attempt++ {
+			// Only the final attempt's output is reported.
+			limiter := &sharedLimiter{remaining: maxOutputSize}
+			stdout = &limitedBuffer{limiter: limiter}
+			stderr = &limitedBuffer{limiter: limiter}
+			crashOutput = &tailBuffer{max: crashOutputSize}
 
-		// Determine exit code
-		runExitCode = 0
-		if execErr != nil {
-			if exitErr, ok := execErr.(*exec.ExitError); ok {
-				runExitCode = exitErr.ExitCode()
-			} else {
-				// For non-exit errors (like command not found), use -1
-				runExitCode = -1
+			// Use MultiWriter to stream output in real-time while capturing it
+			stderrWriter := io.MultiWriter(os.Stderr, stderr)
+			if runNotifyCrashes {
+				stderrWriter = io.MultiWriter(os.Stderr, stderr, crashOutput)
+			}
+			result = runAttempt(args, io.MultiWriter(os.Stdout, stdout), stderrWriter, signals)
+			if result.succeeded() || result.signal != nil || attempt > runRetries {
+				break
+			}
+
+			fmt.Fprintf(
+				os.Stderr, "Command failed (%s), retrying in %s (attempt %d of %d)\n",
+				result.reason(), runRetryDelay, attempt+1, runRetries+1,
+			)
+			timer := time.NewTimer(runRetryDelay)
+			select {
+			case <-timer.C:
+				continue
+			case sig := <-signals:
+				timer.Stop()
+				result.signal = sig
 			}
+			break
 		}
+		durationMs := time.Since(startTime).Milliseconds()
+
+		// Determine exit code
+		runExitCode = result.exitCode()
 
 		// Prepare payload
 		payload := checkInPayload{}
@@ -162,68 +281,25 @@ them in a shell script and invoke that script with "hb run".`,
 		payload.CheckIn.Stdout = stdout.String()
 		payload.CheckIn.Stderr = stderr.String()
 		payload.CheckIn.ExitCode = runExitCode
+		payload.CheckIn.Lock = lock
+		payload.CheckIn.Usage = result.usage
 
-		if execErr != nil {
-			payload.CheckIn.Status = "error"
-		} else {
+		if result.succeeded() {
 			payload.CheckIn.Status = "success"
-		}
-
-		jsonPayload, err := json.Marshal(payload)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to marshal check-in payload: %v\n", err)
 		} else {
-			apiEndpoint := viper.GetString("endpoint")
-			var url string
-			if checkInID != "" {
-				url = fmt.Sprintf("%s/v1/check_in/%s", apiEndpoint, checkInID)
-			} else {
-				url = fmt.Sprintf("%s/v1/check_in/%s/%s", apiEndpoint, apiKey, slug)
+			payload.CheckIn.Status = "error"
+			if result.timedOut || result.signal != nil {
+				payload.CheckIn.Stderr += "\n[hb: " + result.reason() + "]"
 			}
+		}
 
-			// Create request with timeout
-			ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
-			defer cancel()
+		sendRunCheckIn(url, payload)
+		if runJobEvent {
+			sendJobEvent(args, payload)
+		}
 
-			req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to create check-in request: %v\n", err)
-			} else {
-				req.Header.Set("Content-Type", "application/json")
-
-				// Send request
-				resp, err := http.DefaultClient.Do(req)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Failed to report check-in to Honeybadger: %v\n", err)
-				} else {
-					defer resp.Body.Close() // nolint:errcheck
-					if resp.StatusCode != http.StatusOK {
-						body, err := io.ReadAll(resp.Body)
-						if err != nil {
-							fmt.Fprintf(
-								os.Stderr,
-								"Unexpected status code: %d; failed to read response body: %v\n",
-								resp.StatusCode,
-								err,
-							)
-						} else {
-							fmt.Fprintf(
-								os.Stderr,
-								"Unexpected status code: %d, body: %s\n",
-								resp.StatusCode,
-								body,
-							)
-						}
-					} else {
-						fmt.Fprintf(
-							os.Stderr,
-							"Check-in reported to Honeybadger (duration: %dms, status: %s)\n",
-							durationMs,
-							payload.CheckIn.Status,
-						)
-					}
-				}
-			}
+		if runNotifyCrashes && !result.succeeded() {
+			reportCrashes(crashOutput.String(), args, runExitCode)
 		}
 
 		// Exit with the same code as the wrapped command
@@ -234,8 +310,243 @@ them in a shell script and invoke that script with "hb run".`,
 	},
 }
 
+// sendRunCheckIn reports payload to the check-in at url. Failures are
+// printed rather than returned so that they don't change the command's
+// exit code.
+func sendRunCheckIn(url string, payload checkInPayload) {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to marshal check-in payload: %v\n", err)
+		return
+	}
+
+	// Create request with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create check-in request: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send request
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to report check-in to Honeybadger: %v\n", err)
+		return
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fmt.Fprintf(
+				os.Stderr,
+				"Unexpected status code: %d; failed to read response body: %v\n",
+				resp.StatusCode,
+				err,
+			)
+		} else {
+			fmt.Fprintf(
+				os.Stderr,
+				"Unexpected status code: %d, body: %s\n",
+				resp.StatusCode,
+				body,
+			)
+		}
+		return
+	}
+	fmt.Fprintf(
+		os.Stderr,
+		"Check-in reported to Honeybadger (duration: %dms, status: %s)\n",
+		payload.CheckIn.Duration,
+		payload.CheckIn.Status,
+	)
+}
+
+// runResult is the outcome of one attempt at running the command.
+type runResult struct {
+	err      error
+	timedOut bool
+	usage    *runUsage
+	// signal is the signal that was forwarded to the command, if any.
+	signal os.Signal
+}
+
+// succeeded reports whether the command ran to completion and exited with
+// status 0.
+func (r runResult) succeeded() bool {
+	return r.err == nil && !r.timedOut && r.signal == nil
+}
+
+// exitCode returns the exit code for hb to exit with. Like timeout(1),
+// commands that time out exit with 124, and like a shell, commands
+// interrupted or killed by a signal exit with 128 plus the signal number.
+func (r runResult) exitCode() int {
+	if r.timedOut {
+		return 124
+	}
+	if sig, ok := r.signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	if r.err == nil {
+		return 0
+	}
+	if exitErr, ok := r.err.(*exec.ExitError); ok {
+		// A signal hb didn't forward, such as SIGKILL from the OOM killer.
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	// For non-exit errors (like command not found), use -1
+	return -1
+}
+
+// reason describes why the command failed.
+func (r runResult) reason() string {
+	switch {
+	case r.timedOut:
+		return fmt.Sprintf("timed out after %s", runTimeout)
+	case r.signal != nil:
+		return fmt.Sprintf("interrupted by signal: %s", r.signal)
+	case r.err != nil:
+		return r.err.Error()
+	}
+	return "success"
+}
+
+// runAttempt runs the command in args once, killing its process group if it
+// runs longer than --timeout. Signals received on signals are forwarded to
+// the command; if a second one arrives before it exits, it's killed.
+func runAttempt(args []string, stdout, stderr io.Writer, signals <-chan os.Signal) runResult {
+	ctx := context.Background()
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runTimeout)
+		defer cancel()
+	}
+
+	var timedOut atomic.Bool
+	execCmd := exec.CommandContext(ctx, args[0], args[1:]...) // nolint:gosec
+	execCmd.Stdout = stdout
+	execCmd.Stderr = stderr
+	setProcessGroup(execCmd)
+	execCmd.Cancel = func() error {
+		err := killProcessGroup(execCmd.Process)
+		if err == nil {
+			timedOut.Store(true)
+		}
+		return err
+	}
+	// Don't wait forever for output from background processes the command
+	// left running after it exited or was killed.
+	execCmd.WaitDelay = runWaitDelay
+
+	var result runResult
+	if err := execCmd.Start(); err != nil {
+		result.err = err
+		return result
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- execCmd.Wait()
+	}()
+	for {
+		select {
+		case err := <-done:
+			result.err = err
+			result.timedOut = timedOut.Load()
+			result.usage = processUsage(execCmd.ProcessState)
+			return result
+		case sig := <-signals:
+			var err error
+			if result.signal == nil {
+				err = signalProcessGroup(execCmd.Process, sig)
+			} else {
+				err = killProcessGroup(execCmd.Process)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to forward %s to command: %v\n", sig, err)
+			}
+			result.signal = sig
+		}
+	}
+}
+
+// reportCrashes sends a notice for each crash found in the output of the
+// command in args. Failures are printed rather than returned so that they
+// don't change the command's exit code.
+func reportCrashes(output string, args []string, exitCode int) {
+	crashes := crash.Parse(output)
+	if len(crashes) > maxCrashNotices {
+		fmt.Fprintf(os.Stderr, "Found %d crashes, reporting the first %d\n", len(crashes), maxCrashNotices)
+		crashes = crashes[:maxCrashNotices]
+	}
+	quote := func(s string) json.RawMessage {
+		data, _ := json.Marshal(s)
+		return data
+	}
+	for _, c := range crashes {
+		class := c.Class
+		if class == "" {
+			class = "Error"
+		}
+		notice := newNotice(class, c.Message, crashFrames(c))
+		notice.Request.Component = filepath.Base(args[0])
+		notice.Request.Context = map[string]json.RawMessage{
+			"command":   quote(strings.Join(args, " ")),
+			"exit_code": json.RawMessage(fmt.Sprint(exitCode)),
+			"language":  quote(c.Language),
+		}
+		if checkInID != "" {
+			notice.Request.Context["check_in_id"] = quote(checkInID)
+		}
+		if slug != "" {
+			notice.Request.Context["check_in_slug"] = quote(slug)
+		}
+
+		id, err := sendNotice(notice)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to report %s to Honeybadger: %v\n", class, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s reported to Honeybadger (notice ID: %s)\n", class, id)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(runCmd)
 	runCmd.Flags().StringVarP(&checkInID, "id", "i", "", "Check-in ID to report")
 	runCmd.Flags().StringVarP(&slug, "slug", "s", "", "Check-in slug to report")
+	runCmd.Flags().BoolVar(
+		&runNotifyCrashes, "notify-crashes", false,
+		"Report crashes found in the command's stderr as errors when it fails",
+	)
+	runCmd.Flags().DurationVar(
+		&runTimeout, "timeout", 0,
+		"Kill the command if it runs longer than this (e.g. 30m, default no timeout)",
+	)
+	runCmd.Flags().IntVar(&runRetries, "retries", 0, "Number of times to retry the command if it fails")
+	runCmd.Flags().DurationVar(&runRetryDelay, "retry-delay", 10*time.Second, "Time to wait between retries")
+	runCmd.Flags().BoolVar(
+		&runJobEvent, "job-event", false,
+		"Also send a report.job Insights event with the run's status, duration, and resource usage",
+	)
+	runCmd.Flags().StringVar(&runLock, "lock", "", "Name of a lock to hold while running, to prevent overlapping runs")
+	runCmd.Flags().StringVar(&runLockDir, "lock-dir", "", "Directory for lock files (default the user cache directory)")
+	runCmd.Flags().DurationVar(
+		&runLockWait, "lock-wait", 0,
+		"How long to wait for the lock before skipping the run (default don't wait)",
+	)
+	runCmd.Flags().StringVar(
+		&runLockStatus, "lock-status", "error",
+		"Check-in status to report when the run is skipped because the lock is held (success or error)",
+	)
+	runCmd.Flags().StringVar(
+		&runLockMessage, "lock-message", "",
+		"Message to report when the run is skipped because the lock is held",
+	)
 }
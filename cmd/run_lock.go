@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// lockPollInterval is how often a run waiting for a lock tries to take it.
+const lockPollInterval = 100 * time.Millisecond
+
+var (
+	runLock        string
+	runLockDir     string
+	runLockWait    time.Duration
+	runLockStatus  string
+	runLockMessage string
+)
+
+// lockNamePattern limits lock names to characters that are safe in a file
+// name.
+var lockNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// checkInLock describes the --lock taken for a run, so that overlapping
+// runs show up in the check-in.
+type checkInLock struct {
+	Name string `json:"name"`
+	// Acquired is false when the run was skipped because another run held
+	// the lock.
+	Acquired bool `json:"acquired"`
+	// Contended is true when another run held the lock when this one
+	// started.
+	Contended bool  `json:"contended"`
+	WaitedMs  int64 `json:"waited_ms"`
+	// HolderPid and HeldSince identify the run that held the lock, when
+	// it was contended.
+	HolderPid int    `json:"holder_pid,omitempty"`
+	HeldSince string `json:"held_since,omitempty"`
+}
+
+// lockHolder is written to a lock file by the run holding the lock.
+type lockHolder struct {
+	Pid       int    `json:"pid"`
+	StartedAt string `json:"started_at"`
+}
+
+// lockPath returns the path of the lock file for the named lock, in dir or
+// by default in the user's cache directory, where other users can't take
+// or tamper with it.
+func lockPath(dir, name string) (string, error) {
+	if !lockNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid lock name %q: may only contain letters, digits, '.', '_', and '-'", name)
+	}
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to determine lock directory, use --lock-dir: %w", err)
+		}
+		dir = filepath.Join(cacheDir, "honeybadger-cli", "locks")
+	}
+	return filepath.Join(dir, "hb-"+name+".lock"), nil
+}
+
+// acquireRunLock takes the lock file at path, waiting up to wait for
+// another run to release it. The returned file holds the lock until it's
+// closed, and is nil if the lock couldn't be taken in time, if a signal
+// arrived while waiting, in which case the signal is returned, or on error.
+func acquireRunLock(path, name string, wait time.Duration, signals <-chan os.Signal) (*os.File, checkInLock, os.Signal, error) {
+	info := checkInLock{Name: name}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, info, nil, fmt.Errorf("error creating lock directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600) // #nosec G304
+	if err != nil {
+		return nil, info, nil, fmt.Errorf("error opening lock file %s: %w", path, err)
+	}
+
+	start := time.Now()
+	deadline := start.Add(wait)
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close() // nolint:errcheck
+			return nil, info, nil, fmt.Errorf("error locking %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+		if !info.Contended {
+			info.Contended = true
+			if holder, ok := readLockHolder(path); ok {
+				info.HolderPid = holder.Pid
+				info.HeldSince = holder.StartedAt
+			}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			info.WaitedMs = time.Since(start).Milliseconds()
+			f.Close() // nolint:errcheck
+			return nil, info, nil, nil
+		}
+		timer := time.NewTimer(min(lockPollInterval, remaining))
+		select {
+		case <-timer.C:
+		case sig := <-signals:
+			timer.Stop()
+			info.WaitedMs = time.Since(start).Milliseconds()
+			f.Close() // nolint:errcheck
+			return nil, info, sig, nil
+		}
+	}
+	info.Acquired = true
+	info.WaitedMs = time.Since(start).Milliseconds()
+
+	// Record who holds the lock for runs that find it held. This is
+	// informational, so failures are ignored.
+	holder, _ := json.Marshal(lockHolder{Pid: os.Getpid(), StartedAt: time.Now().UTC().Format(time.RFC3339)})
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt(holder, 0)
+	}
+	return f, info, nil, nil
+}
+
+// readLockHolder reads the run holding the lock from the lock file at path.
+func readLockHolder(path string) (lockHolder, bool) {
+	var holder lockHolder
+	data, err := os.ReadFile(path) // #nosec G304
+	if err != nil || json.Unmarshal(data, &holder) != nil || holder.Pid == 0 {
+		return holder, false
+	}
+	return holder, true
+}
+
+// skippedRunPayload builds the check-in for a run that didn't start because
+// its lock was held or couldn't be taken, and returns it along with the exit
+// code. A run whose lock couldn't be taken, or that was interrupted while
+// waiting, is reported as an error; otherwise the status is --lock-status,
+// and the exit code is 0 for "success" and 1 otherwise, like flock(1).
+func skippedRunPayload(lock checkInLock, sig os.Signal, err error) (checkInPayload, int) {
+	payload := checkInPayload{}
+	payload.CheckIn.Lock = &lock
+	payload.CheckIn.Duration = lock.WaitedMs
+
+	if err != nil {
+		payload.CheckIn.Status = "error"
+		payload.CheckIn.ExitCode = 1
+		payload.CheckIn.Stderr = fmt.Sprintf("[hb: skipped: %v]", err)
+		return payload, payload.CheckIn.ExitCode
+	}
+
+	if sig != nil {
+		result := runResult{signal: sig}
+		payload.CheckIn.Status = "error"
+		payload.CheckIn.ExitCode = result.exitCode()
+		payload.CheckIn.Stderr = fmt.Sprintf("[hb: %s while waiting for lock %q]", result.reason(), lock.Name)
+		return payload, payload.CheckIn.ExitCode
+	}
+
+	message := runLockMessage
+	if message == "" {
+		message = fmt.Sprintf("skipped: lock %q is held by another run", lock.Name)
+		if lock.HolderPid != 0 {
+			message += fmt.Sprintf(" (pid %d, since %s)", lock.HolderPid, lock.HeldSince)
+		}
+	}
+	payload.CheckIn.Status = runLockStatus
+	payload.CheckIn.Stderr = "[hb: " + message + "]"
+	if runLockStatus != "success" {
+		payload.CheckIn.ExitCode = 1
+	}
+	return payload, payload.CheckIn.ExitCode
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockPath(t *testing.T) {
+	path, err := lockPath("/var/lock", "nightly-backup")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/var/lock", "hb-nightly-backup.lock"), path)
+
+	cacheDir, err := os.UserCacheDir()
+	require.NoError(t, err)
+	path, err = lockPath("", "nightly-backup")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cacheDir, "honeybadger-cli", "locks", "hb-nightly-backup.lock"), path)
+
+	_, err = lockPath("", "../backup")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid lock name "../backup"`)
+}
+
+func TestAcquireRunLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "hb-job.lock")
+
+	held, info, sig, err := acquireRunLock(path, "job", 0, nil)
+	require.NoError(t, err)
+	require.NotNil(t, held)
+	assert.Nil(t, sig)
+	assert.Equal(t, checkInLock{Name: "job", Acquired: true}, info)
+
+	var holder lockHolder
+	data, err := os.ReadFile(path) // nolint:gosec
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &holder))
+	assert.Equal(t, os.Getpid(), holder.Pid)
+
+	t.Run("skips when the lock is held", func(t *testing.T) {
+		f, info, sig, err := acquireRunLock(path, "job", 0, nil)
+		require.NoError(t, err)
+		assert.Nil(t, f)
+		assert.Nil(t, sig)
+		assert.False(t, info.Acquired)
+		assert.True(t, info.Contended)
+		assert.Equal(t, os.Getpid(), info.HolderPid)
+		assert.Equal(t, holder.StartedAt, info.HeldSince)
+	})
+
+	t.Run("stops waiting on a signal", func(t *testing.T) {
+		signals := make(chan os.Signal, 1)
+		signals <- os.Interrupt
+		f, info, sig, err := acquireRunLock(path, "job", time.Minute, signals)
+		require.NoError(t, err)
+		assert.Nil(t, f)
+		assert.Equal(t, os.Interrupt, sig)
+		assert.False(t, info.Acquired)
+	})
+
+	t.Run("waits for the lock to be released", func(t *testing.T) {
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			held.Close() // nolint:errcheck
+		}()
+		f, info, sig, err := acquireRunLock(path, "job", 10*time.Second, nil)
+		require.NoError(t, err)
+		require.NotNil(t, f)
+		defer f.Close() // nolint:errcheck
+		assert.Nil(t, sig)
+		assert.True(t, info.Acquired)
+		assert.True(t, info.Contended)
+		assert.GreaterOrEqual(t, info.WaitedMs, int64(100))
+	})
+}
+
+func TestRunLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+	script := writeRunScript(t, "touch "+ran+"\n")
+
+	t.Run("reports the lock with the check-in", func(t *testing.T) {
+		checkIns, exitCode := runCheckIns(t, "--lock", "job", "--lock-dir", dir, script)
+		require.Len(t, checkIns, 1)
+		assert.Equal(t, "success", checkIns[0].CheckIn.Status)
+		assert.Equal(t, &checkInLock{Name: "job", Acquired: true}, checkIns[0].CheckIn.Lock)
+		assert.Equal(t, 0, exitCode)
+		assert.FileExists(t, ran)
+		require.NoError(t, os.Remove(ran))
+	})
+
+	lockFile := filepath.Join(dir, "hb-job.lock")
+	held, _, _, err := acquireRunLock(lockFile, "job", 0, nil)
+	require.NoError(t, err)
+	require.NotNil(t, held)
+	defer held.Close() // nolint:errcheck
+
+	t.Run("skips with an error when the lock is held", func(t *testing.T) {
+		checkIns, exitCode := runCheckIns(t, "--lock", "job", "--lock-dir", dir, "--lock-wait", "50ms", script)
+		require.Len(t, checkIns, 1)
+		checkIn := checkIns[0].CheckIn
+		assert.Equal(t, "error", checkIn.Status)
+		assert.Contains(t, checkIn.Stderr, `[hb: skipped: lock "job" is held by another run (pid `)
+		require.NotNil(t, checkIn.Lock)
+		assert.False(t, checkIn.Lock.Acquired)
+		assert.True(t, checkIn.Lock.Contended)
+		assert.Equal(t, os.Getpid(), checkIn.Lock.HolderPid)
+		assert.GreaterOrEqual(t, checkIn.Lock.WaitedMs, int64(50))
+		assert.Equal(t, 1, checkIn.ExitCode)
+		assert.Equal(t, 1, exitCode)
+		assert.NoFileExists(t, ran)
+	})
+
+	t.Run("skips with a custom status and message", func(t *testing.T) {
+		checkIns, exitCode := runCheckIns(
+			t, "--lock", "job", "--lock-dir", dir,
+			"--lock-status", "success", "--lock-message", "previous sync still running", script,
+		)
+		require.Len(t, checkIns, 1)
+		assert.Equal(t, "success", checkIns[0].CheckIn.Status)
+		assert.Equal(t, "[hb: previous sync still running]", checkIns[0].CheckIn.Stderr)
+		assert.Equal(t, 0, exitCode)
+		assert.NoFileExists(t, ran)
+	})
+
+	t.Run("reports an error when the lock can't be taken", func(t *testing.T) {
+		notDir := filepath.Join(dir, "not-a-dir")
+		require.NoError(t, os.WriteFile(notDir, nil, 0o600))
+		checkIns, exitCode := runCheckIns(t, "--lock", "job", "--lock-dir", notDir, script)
+		require.Len(t, checkIns, 1)
+		checkIn := checkIns[0].CheckIn
+		assert.Equal(t, "error", checkIn.Status)
+		assert.Contains(t, checkIn.Stderr, "[hb: skipped: error creating lock directory: ")
+		assert.Equal(t, &checkInLock{Name: "job"}, checkIn.Lock)
+		assert.Equal(t, 1, exitCode)
+		assert.NoFileExists(t, ran)
+	})
+
+	t.Run("rejects an invalid status", func(t *testing.T) {
+		viper.Reset()
+		err := newRunCmd("--id", "check-123", "--lock", "job", "--lock-status", "skipped", script).Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid --lock-status "skipped"`)
+	})
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLockFile takes an exclusive lock on f without waiting. It returns
+// false if another process holds it. The lock is released when f is closed.
+//
+// Windows locks are mandatory, so a byte far past the end of the file is
+// locked rather than its contents, which other runs read to find the
+// holder.
+func tryLockFile(f *os.File) (bool, error) {
+	overlapped := &windows.Overlapped{OffsetHigh: 0x7fffffff}
+	err := windows.LockFileEx(
+		windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, overlapped,
+	)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -3,12 +3,16 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -19,11 +23,13 @@ import (
 // testCheckInPayload mirrors checkInPayload but with int64 Duration for test assertions
 type testCheckInPayload struct {
 	CheckIn struct {
-		Status   string `json:"status"`
-		Duration int64  `json:"duration,omitempty"`
-		Stdout   string `json:"stdout,omitempty"`
-		Stderr   string `json:"stderr,omitempty"`
-		ExitCode int    `json:"exit_code"`
+		Status   string       `json:"status"`
+		Duration int64        `json:"duration,omitempty"`
+		Stdout   string       `json:"stdout,omitempty"`
+		Stderr   string       `json:"stderr,omitempty"`
+		ExitCode int          `json:"exit_code"`
+		Lock     *checkInLock `json:"lock,omitempty"`
+		Usage    *runUsage    `json:"usage,omitempty"`
 	} `json:"check_in"`
 }
 
@@ -250,13 +256,9 @@ exit 42
 			viper.Set("endpoint", server.URL)
 
 			// Create a new command for each test to avoid flag conflicts
-			cmd := &cobra.Command{Use: "run"}
-			cmd.Flags().StringVarP(&checkInID, "id", "i", "", "Check-in ID to report")
-			cmd.Flags().StringVarP(&slug, "slug", "s", "", "Check-in slug to report")
-			cmd.RunE = runCmd.RunE
+			cmd := newRunCmd(tt.args...)
 
 			// Execute command
-			cmd.SetArgs(tt.args)
 			err := cmd.Execute()
 
 			if tt.expectedError {
@@ -270,6 +272,260 @@ exit 42
 	}
 }
 
+// newRunCmd returns a run command with every flag reset to its default.
+func newRunCmd(args ...string) *cobra.Command {
+	cmd := &cobra.Command{Use: "run"}
+	cmd.Flags().StringVarP(&checkInID, "id", "i", "", "")
+	cmd.Flags().StringVarP(&slug, "slug", "s", "", "")
+	cmd.Flags().BoolVar(&runNotifyCrashes, "notify-crashes", false, "")
+	cmd.Flags().DurationVar(&runTimeout, "timeout", 0, "")
+	cmd.Flags().IntVar(&runRetries, "retries", 0, "")
+	cmd.Flags().DurationVar(&runRetryDelay, "retry-delay", 10*time.Second, "")
+	cmd.Flags().BoolVar(&runJobEvent, "job-event", false, "")
+	cmd.Flags().StringVar(&runLock, "lock", "", "")
+	cmd.Flags().StringVar(&runLockDir, "lock-dir", "", "")
+	cmd.Flags().DurationVar(&runLockWait, "lock-wait", 0, "")
+	cmd.Flags().StringVar(&runLockStatus, "lock-status", "error", "")
+	cmd.Flags().StringVar(&runLockMessage, "lock-message", "", "")
+	cmd.RunE = runCmd.RunE
+	cmd.SetArgs(args)
+	return cmd
+}
+
+// writeRunScript writes a shell script for hb run tests.
+func writeRunScript(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "job.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+content), 0o700)) // nolint:gosec
+	return path
+}
+
+// runCheckIns runs the command built from args against a test server and
+// returns the check-ins it received and the exit code hb exited with.
+func runCheckIns(t *testing.T, args ...string) ([]testCheckInPayload, int) {
+	t.Helper()
+	originalClient := http.DefaultClient
+	originalExitFunc := exitFunc
+	defer func() {
+		http.DefaultClient = originalClient
+		exitFunc = originalExitFunc
+	}()
+
+	var mu sync.Mutex
+	var checkIns []testCheckInPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload testCheckInPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		mu.Lock()
+		checkIns = append(checkIns, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	http.DefaultClient = server.Client()
+
+	viper.Reset()
+	viper.Set("endpoint", server.URL)
+	exitCode := 0
+	exitFunc = func(code int) { exitCode = code }
+
+	require.NoError(t, newRunCmd(append([]string{"--id", "check-123"}, args...)...).Execute())
+	mu.Lock()
+	defer mu.Unlock()
+	return checkIns, exitCode
+}
+
+func TestRunTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	// The background sleep keeps stdout open, so this only finishes quickly
+	// if the whole process group is killed.
+	script := writeRunScript(t, "echo started\nsleep 30 &\nsleep 30\n")
+
+	start := time.Now()
+	checkIns, exitCode := runCheckIns(t, "--timeout", "200ms", script)
+	assert.Less(t, time.Since(start), runWaitDelay)
+
+	require.Len(t, checkIns, 1)
+	assert.Equal(t, "error", checkIns[0].CheckIn.Status)
+	assert.Contains(t, checkIns[0].CheckIn.Stdout, "started")
+	assert.Contains(t, checkIns[0].CheckIn.Stderr, "[hb: timed out after 200ms]")
+	assert.Equal(t, 124, checkIns[0].CheckIn.ExitCode)
+	assert.Equal(t, 124, exitCode)
+}
+
+func TestRunRetries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+
+	t.Run("reports once after a retry succeeds", func(t *testing.T) {
+		attempts := filepath.Join(t.TempDir(), "attempts")
+		// Fails on the first two attempts.
+		script := writeRunScript(t, fmt.Sprintf(`echo x >> %q
+n=$(wc -l < %q)
+echo "attempt $n"
+[ "$n" -ge 3 ]
+`, attempts, attempts))
+
+		checkIns, exitCode := runCheckIns(t, "--retries", "3", "--retry-delay", "10ms", script)
+		require.Len(t, checkIns, 1)
+		assert.Equal(t, "success", checkIns[0].CheckIn.Status)
+		assert.Equal(t, "attempt 3\n", checkIns[0].CheckIn.Stdout)
+		assert.Equal(t, 0, exitCode)
+	})
+
+	t.Run("reports the final failure", func(t *testing.T) {
+		attempts := filepath.Join(t.TempDir(), "attempts")
+		script := writeRunScript(t, fmt.Sprintf("echo x >> %q\nexit 3\n", attempts))
+
+		checkIns, exitCode := runCheckIns(t, "--retries", "2", "--retry-delay", "10ms", script)
+		require.Len(t, checkIns, 1)
+		assert.Equal(t, "error", checkIns[0].CheckIn.Status)
+		assert.Equal(t, 3, checkIns[0].CheckIn.ExitCode)
+		assert.Equal(t, 3, exitCode)
+		data, err := os.ReadFile(attempts) // nolint:gosec
+		require.NoError(t, err)
+		assert.Equal(t, "x\nx\nx\n", string(data))
+	})
+
+	t.Run("rejects negative retries", func(t *testing.T) {
+		viper.Reset()
+		err := newRunCmd("--id", "check-123", "--retries", "-1", "true").Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--retries must not be negative")
+	})
+}
+
+func TestRunForwardsSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script and SIGTERM")
+	}
+	started := filepath.Join(t.TempDir(), "started")
+	script := writeRunScript(t, fmt.Sprintf(`trap 'echo "got TERM" >&2; exit 3' TERM
+touch %q
+sleep 30 &
+wait $!
+`, started))
+
+	// Send SIGTERM to hb once the command is running.
+	go func() {
+		for i := 0; i < 500; i++ {
+			if _, err := os.Stat(started); err == nil {
+				p, _ := os.FindProcess(os.Getpid())
+				_ = p.Signal(syscall.SIGTERM)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	checkIns, exitCode := runCheckIns(t, "--retries", "2", "--retry-delay", "10ms", script)
+	require.Len(t, checkIns, 1)
+	assert.Equal(t, "error", checkIns[0].CheckIn.Status)
+	assert.Contains(t, checkIns[0].CheckIn.Stderr, "got TERM")
+	assert.Contains(t, checkIns[0].CheckIn.Stderr, "[hb: interrupted by signal: terminated]")
+	assert.Equal(t, 143, exitCode)
+}
+
+func TestRunKilledBySignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script and SIGKILL")
+	}
+	script := writeRunScript(t, "kill -KILL $$\n")
+
+	checkIns, exitCode := runCheckIns(t, script)
+	require.Len(t, checkIns, 1)
+	assert.Equal(t, "error", checkIns[0].CheckIn.Status)
+	assert.Equal(t, 137, checkIns[0].CheckIn.ExitCode)
+	assert.Equal(t, 137, exitCode)
+}
+
+func TestRunNotifyCrashes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	originalClient := http.DefaultClient
+	originalExitFunc := exitFunc
+	defer func() {
+		http.DefaultClient = originalClient
+		exitFunc = originalExitFunc
+	}()
+
+	scriptPath := filepath.Join(t.TempDir(), "import.sh")
+	require.NoError(t, os.WriteFile(scriptPath, []byte(`#!/bin/sh
+echo "Importing rows" >&2
+echo "Traceback (most recent call last):" >&2
+echo '  File "/app/import.py", line 12, in <module>' >&2
+echo '    main()' >&2
+echo '  File "/app/import.py", line 8, in main' >&2
+echo '    raise ValueError("bad row")' >&2
+echo "ValueError: bad row" >&2
+exit 1
+`), 0o700)) // nolint:gosec
+
+	t.Run("reports crashes along with the check-in", func(t *testing.T) {
+		var mu sync.Mutex
+		var notices []noticePayload
+		var checkIns []testCheckInPayload
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch r.URL.Path {
+			case "/v1/notices":
+				var notice noticePayload
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&notice))
+				notices = append(notices, notice)
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"id":"7b4f3a2c-0000-4000-8000-000000000000"}`))
+			case "/v1/check_in/test-api-key/nightly-import":
+				var payload testCheckInPayload
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+				checkIns = append(checkIns, payload)
+				w.WriteHeader(http.StatusOK)
+			default:
+				t.Errorf("unexpected request to %s", r.URL.Path)
+			}
+		}))
+		defer server.Close()
+		http.DefaultClient = server.Client()
+
+		viper.Reset()
+		viper.Set("api_key", "test-api-key")
+		viper.Set("endpoint", server.URL)
+		var exitCode int
+		exitFunc = func(code int) { exitCode = code }
+
+		require.NoError(t, newRunCmd("--slug", "nightly-import", "--notify-crashes", scriptPath).Execute())
+		assert.Equal(t, 1, exitCode)
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, checkIns, 1)
+		assert.Equal(t, "error", checkIns[0].CheckIn.Status)
+		require.Len(t, notices, 1)
+		notice := notices[0]
+		assert.Equal(t, "ValueError", notice.Error.Class)
+		assert.Equal(t, "bad row", notice.Error.Message)
+		assert.Equal(t, []noticeFrame{
+			{File: "/app/import.py", Number: "8", Method: "main"},
+			{File: "/app/import.py", Number: "12", Method: "<module>"},
+		}, notice.Error.Backtrace)
+		assert.Equal(t, "import.sh", notice.Request.Component)
+		assert.Equal(t, json.RawMessage("1"), notice.Request.Context["exit_code"])
+		assert.Equal(t, json.RawMessage(`"python"`), notice.Request.Context["language"])
+		assert.Equal(t, json.RawMessage(`"nightly-import"`), notice.Request.Context["check_in_slug"])
+	})
+
+	t.Run("requires an API key", func(t *testing.T) {
+		viper.Reset()
+		err := newRunCmd("--id", "check-123", "--notify-crashes", scriptPath).Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "API key is required with --notify-crashes")
+	})
+}
+
 func TestCheckInPayloadConstruction(t *testing.T) {
 	// Create and populate payload
 	payload := checkInPayload{}
@@ -311,3 +567,12 @@ func TestLimitedBuffer(t *testing.T) {
 	assert.Equal(t, maxOutputSize, len(stdout.String())+len(stderr.String()))
 	assert.Contains(t, stderr.String(), "[output truncated]")
 }
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 8}
+	_, err := b.Write([]byte("hello, "))
+	assert.NoError(t, err)
+	_, err = b.Write([]byte("world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "o, world", b.String())
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so that it
+// and any processes it starts can be signaled together.
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup sends sig to p's process group.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+	err := syscall.Kill(-p.Pid, s)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// killProcessGroup kills p's process group.
+func killProcessGroup(p *os.Process) error {
+	return signalProcessGroup(p, syscall.SIGKILL)
+}
+
+// setSysUsage fills in the parts of usage that come from the process's
+// rusage and wait status.
+func setSysUsage(usage *runUsage, state *os.ProcessState) {
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// ru_maxrss is in bytes on macOS and kilobytes elsewhere.
+		usage.MaxRSSBytes = int64(ru.Maxrss)
+		if runtime.GOOS != "darwin" {
+			usage.MaxRSSBytes *= 1024
+		}
+		usage.BlockInputOps = int64(ru.Inblock)
+		usage.BlockOutputOps = int64(ru.Oublock)
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		usage.Signal = ws.Signal().String()
+	}
+}
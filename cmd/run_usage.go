@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var runJobEvent bool
+
+// runUsage is the resources used by a command run with hb run, from its
+// process state once it has exited.
+type runUsage struct {
+	UserCPUMs   int64 `json:"user_cpu_ms"`
+	SystemCPUMs int64 `json:"system_cpu_ms"`
+	// MaxRSSBytes and the block I/O counts aren't available on Windows.
+	MaxRSSBytes    int64 `json:"max_rss_bytes,omitempty"`
+	BlockInputOps  int64 `json:"block_input_ops,omitempty"`
+	BlockOutputOps int64 `json:"block_output_ops,omitempty"`
+	// Signal is the name of the signal that terminated the command, if
+	// any, such as "killed" or "terminated".
+	Signal string `json:"signal,omitempty"`
+}
+
+// processUsage returns the resources used by the process described by
+// state, or nil if it never started.
+func processUsage(state *os.ProcessState) *runUsage {
+	if state == nil {
+		return nil
+	}
+	usage := &runUsage{
+		UserCPUMs:   state.UserTime().Milliseconds(),
+		SystemCPUMs: state.SystemTime().Milliseconds(),
+	}
+	setSysUsage(usage, state)
+	return usage
+}
+
+// jobEvent builds the report.job Insights event for a run of the command in
+// args, keyed by its check-in.
+func jobEvent(args []string, payload checkInPayload) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	event := map[string]any{
+		"ts":          time.Now().UTC().Format(time.RFC3339),
+		"event_type":  "report.job",
+		"host":        hostname,
+		"command":     strings.Join(args, " "),
+		"status":      payload.CheckIn.Status,
+		"exit_code":   payload.CheckIn.ExitCode,
+		"duration_ms": payload.CheckIn.Duration,
+	}
+	if slug != "" {
+		event["check_in_slug"] = slug
+	} else {
+		event["check_in_id"] = checkInID
+	}
+	if usage := payload.CheckIn.Usage; usage != nil {
+		event["user_cpu_ms"] = usage.UserCPUMs
+		event["system_cpu_ms"] = usage.SystemCPUMs
+		if usage.MaxRSSBytes > 0 {
+			event["max_rss_bytes"] = usage.MaxRSSBytes
+			event["block_input_ops"] = usage.BlockInputOps
+			event["block_output_ops"] = usage.BlockOutputOps
+		}
+		if usage.Signal != "" {
+			event["signal"] = usage.Signal
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling event: %w", err)
+	}
+	return data, nil
+}
+
+// sendJobEvent sends the report.job event for a run. Failures are printed
+// rather than returned so that they don't change the command's exit code.
+func sendJobEvent(args []string, payload checkInPayload) {
+	event, err := jobEvent(args, payload)
+	if err == nil {
+		sender := &eventSender{
+			endpoint: viper.GetString("endpoint"),
+			apiKey:   viper.GetString("api_key"),
+			maxBytes: defaultBatchMaxBytes,
+			client:   &http.Client{Timeout: httpTimeout},
+		}
+		_, err = sender.send([][]byte{event})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send job event to Honeybadger: %v\n", err)
+	}
+}
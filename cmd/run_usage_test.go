@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunUsage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+
+	t.Run("reports resource usage", func(t *testing.T) {
+		checkIns, _ := runCheckIns(t, writeRunScript(t, "i=0\nwhile [ $i -lt 10000 ]; do i=$((i+1)); done\n"))
+		require.Len(t, checkIns, 1)
+		usage := checkIns[0].CheckIn.Usage
+		require.NotNil(t, usage)
+		assert.Positive(t, usage.UserCPUMs+usage.SystemCPUMs)
+		assert.Positive(t, usage.MaxRSSBytes)
+		assert.Empty(t, usage.Signal)
+	})
+
+	t.Run("reports the terminating signal", func(t *testing.T) {
+		checkIns, _ := runCheckIns(t, writeRunScript(t, "kill -TERM $$\n"))
+		require.Len(t, checkIns, 1)
+		require.NotNil(t, checkIns[0].CheckIn.Usage)
+		assert.Equal(t, "terminated", checkIns[0].CheckIn.Usage.Signal)
+	})
+
+	t.Run("omits usage when the command doesn't start", func(t *testing.T) {
+		checkIns, _ := runCheckIns(t, "nonexistent-command")
+		require.Len(t, checkIns, 1)
+		assert.Nil(t, checkIns[0].CheckIn.Usage)
+	})
+}
+
+func TestRunJobEvent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	originalClient := http.DefaultClient
+	originalExitFunc := exitFunc
+	defer func() {
+		http.DefaultClient = originalClient
+		exitFunc = originalExitFunc
+	}()
+
+	var mu sync.Mutex
+	var events []map[string]any
+	var checkIns int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/events":
+			assert.Equal(t, "test-api-key", r.Header.Get("X-API-Key"))
+			events = append(events, decodeEvents(t, r)...)
+		case "/v1/check_in/test-api-key/nightly-report":
+			checkIns++
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	http.DefaultClient = server.Client()
+	exitFunc = func(int) {}
+
+	script := writeRunScript(t, "exit 2\n")
+	viper.Reset()
+	viper.Set("api_key", "test-api-key")
+	viper.Set("endpoint", server.URL)
+	require.NoError(t, newRunCmd("--slug", "nightly-report", "--job-event", script).Execute())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, checkIns)
+	require.Len(t, events, 1)
+	event := events[0]
+	assert.Equal(t, "report.job", event["event_type"])
+	assert.Equal(t, "nightly-report", event["check_in_slug"])
+	assert.Equal(t, script, event["command"])
+	assert.Equal(t, "error", event["status"])
+	assert.Equal(t, float64(2), event["exit_code"])
+	assert.Contains(t, event, "duration_ms")
+	assert.Contains(t, event, "user_cpu_ms")
+	assert.Contains(t, event, "system_cpu_ms")
+	assert.Contains(t, event, "max_rss_bytes")
+	assert.NotEmpty(t, event["ts"])
+
+	t.Run("requires an API key", func(t *testing.T) {
+		viper.Reset()
+		err := newRunCmd("--id", "check-123", "--job-event", script).Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "API key is required with --job-event")
+	})
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows, where the command shares hb's
+// console.
+func setProcessGroup(*exec.Cmd) {}
+
+// signalProcessGroup forwards sig to p. The command already receives
+// Ctrl+C from the console it shares with hb, and other signals can't be
+// delivered, so it's killed instead.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	if sig == os.Interrupt {
+		return nil
+	}
+	return p.Kill()
+}
+
+// killProcessGroup kills p. Processes it started are left running.
+func killProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// setSysUsage does nothing on Windows, where only CPU times are available.
+func setSysUsage(*runUsage, *os.ProcessState) {}
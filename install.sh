@@ -359,9 +359,12 @@ Wants=network-online.target
 [Service]
 Type=simple
 ExecStart=${INSTALL_DIR}/${BINARY_NAME} agent --interval ${INTERVAL}
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 RestartSec=10
 Environment="HONEYBADGER_API_KEY=${API_KEY}"
+# Writable state for the agent's retry spool (exposed as $STATE_DIRECTORY)
+StateDirectory=${SERVICE_NAME}
 
 # Security hardening
 NoNewPrivileges=true
@@ -0,0 +1,301 @@
+// Package crash finds crash reports in program output, such as Go panics,
+// Python tracebacks, Ruby and Java stack traces, and Node.js errors, and
+// parses them into an error class, message, and stack frames.
+package crash
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Crash is a crash report found in program output.
+type Crash struct {
+	// Language is the runtime that printed the crash: "go", "python",
+	// "ruby", "java", or "node".
+	Language string
+	Class    string
+	Message  string
+	// Frames are ordered innermost first, where the error was raised.
+	Frames []Frame
+}
+
+// Frame is a single stack frame. Line is zero when it's unknown.
+type Frame struct {
+	File     string
+	Line     int
+	Function string
+}
+
+// parser recognizes one crash format. start reports whether a crash begins
+// at lines[i]; parse then reads it and returns the crash and the index of
+// the first line after it.
+type parser struct {
+	language string
+	start    func(lines []string, i int) bool
+	parse    func(lines []string, i int) (Crash, int)
+}
+
+var parsers = []parser{
+	{"go", startGo, parseGo},
+	{"python", startPython, parsePython},
+	{"ruby", startRuby, parseRuby},
+	{"java", startJava, parseJava},
+	{"node", startNode, parseNode},
+}
+
+// Parse returns the crashes found in output, in the order they appear.
+func Parse(output string) []Crash {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	var crashes []Crash
+	for i := 0; i < len(lines); {
+		next := i + 1
+		for _, p := range parsers {
+			if !p.start(lines, i) {
+				continue
+			}
+			crash, end := p.parse(lines, i)
+			crash.Language = p.language
+			crashes = append(crashes, crash)
+			next = max(end, i+1)
+			break
+		}
+		i = next
+	}
+	return crashes
+}
+
+// atoi returns the number in s, or zero.
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// Go: "panic: message" or "fatal error: message", followed by goroutine
+// dumps. Only the first goroutine, which is the one that crashed, is read:
+//
+//	goroutine 1 [running]:
+//	main.divide(...)
+//		/app/main.go:8
+//	main.main()
+//		/app/main.go:12 +0x1d
+var (
+	goStartPattern    = regexp.MustCompile(`^(panic|fatal error): (.*)$`)
+	goroutinePattern  = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	goLocationPattern = regexp.MustCompile(`^\t(.+?):(\d+)(?: \+0x[0-9a-f]+)?$`)
+)
+
+func startGo(lines []string, i int) bool {
+	return goStartPattern.MatchString(lines[i])
+}
+
+func parseGo(lines []string, i int) (Crash, int) {
+	m := goStartPattern.FindStringSubmatch(lines[i])
+	c := Crash{Class: m[1], Message: strings.TrimSuffix(m[2], " [recovered]")}
+	if m[1] == "panic" && strings.HasPrefix(c.Message, "runtime error: ") {
+		c.Class = "runtime.Error"
+	}
+
+	// Skip to the first goroutine, past any repanics and signal details.
+	for i++; i < len(lines) && !goroutinePattern.MatchString(lines[i]); i++ {
+		line := lines[i]
+		if line != "" && !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "[signal ") {
+			return c, i
+		}
+	}
+
+	for i++; i+1 < len(lines) && lines[i] != ""; i += 2 {
+		loc := goLocationPattern.FindStringSubmatch(lines[i+1])
+		if loc == nil {
+			break
+		}
+		// Drop the arguments, "main.f(0x1, 0x2)", and goroutine of
+		// "created by main.main in goroutine 1".
+		fn := strings.TrimPrefix(lines[i], "created by ")
+		if end := strings.LastIndex(fn, "("); end > 0 {
+			fn = fn[:end]
+		}
+		if end := strings.Index(fn, " in goroutine "); end > 0 {
+			fn = fn[:end]
+		}
+		c.Frames = append(c.Frames, Frame{File: loc[1], Line: atoi(loc[2]), Function: fn})
+	}
+	return c, min(i, len(lines))
+}
+
+// Python: frames listed outermost first, then the exception:
+//
+//	Traceback (most recent call last):
+//	  File "/app/job.py", line 12, in <module>
+//	    main()
+//	ValueError: bad row
+//
+// Chained exceptions are printed innermost first, each followed by a line
+// like "During handling of the above exception, another exception
+// occurred:" and the next traceback. Like Java causes, they're part of one
+// crash, which is the last, outermost exception.
+var (
+	pythonFramePattern     = regexp.MustCompile(`^\s+File "(.+)", line (\d+)(?:, in (.+))?$`)
+	pythonExceptionPattern = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?:: (.*))?$`)
+	pythonChainLines       = map[string]bool{
+		"During handling of the above exception, another exception occurred:":  true,
+		"The above exception was the direct cause of the following exception:": true,
+	}
+)
+
+func startPython(lines []string, i int) bool {
+	return lines[i] == "Traceback (most recent call last):"
+}
+
+func parsePython(lines []string, i int) (Crash, int) {
+	c, i := parsePythonTraceback(lines, i)
+	for {
+		j := skipBlank(lines, i)
+		if j == len(lines) || !pythonChainLines[lines[j]] {
+			return c, i
+		}
+		j = skipBlank(lines, j+1)
+		if j == len(lines) || !startPython(lines, j) {
+			return c, i
+		}
+		c, i = parsePythonTraceback(lines, j)
+	}
+}
+
+// skipBlank returns the index of the first non-empty line at or after i.
+func skipBlank(lines []string, i int) int {
+	for i < len(lines) && lines[i] == "" {
+		i++
+	}
+	return i
+}
+
+// parsePythonTraceback reads a single traceback, without any exceptions
+// chained to it.
+func parsePythonTraceback(lines []string, i int) (Crash, int) {
+	var c Crash
+	var frames []Frame
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if m := pythonFramePattern.FindStringSubmatch(line); m != nil {
+			frames = append(frames, Frame{File: m[1], Line: atoi(m[2]), Function: m[3]})
+			continue
+		}
+		if strings.HasPrefix(line, " ") {
+			// Source lines and "^^^^" markers.
+			continue
+		}
+		if m := pythonExceptionPattern.FindStringSubmatch(line); m != nil {
+			c.Class, c.Message = m[1], m[2]
+			i++
+		}
+		break
+	}
+	for j := len(frames) - 1; j >= 0; j-- {
+		c.Frames = append(c.Frames, frames[j])
+	}
+	return c, i
+}
+
+// Ruby: the innermost frame, message, and class, then the callers:
+//
+//	/app/job.rb:3:in `parse': bad row (ArgumentError)
+//		from /app/job.rb:7:in `<main>'
+//
+// Ruby 3.4 quotes methods with ' instead of ` and includes the class,
+// "in 'Importer#parse'".
+var (
+	rubyStartPattern = regexp.MustCompile("^(.+?):(\\d+):in [`'](.+?)': (.*) \\(([A-Z][\\w:]*)\\)$")
+	rubyFramePattern = regexp.MustCompile("^\\s+from (.+?):(\\d+):in [`'](.+)'$")
+)
+
+func startRuby(lines []string, i int) bool {
+	return rubyStartPattern.MatchString(lines[i])
+}
+
+func parseRuby(lines []string, i int) (Crash, int) {
+	m := rubyStartPattern.FindStringSubmatch(lines[i])
+	c := Crash{
+		Class:   m[5],
+		Message: m[4],
+		Frames:  []Frame{{File: m[1], Line: atoi(m[2]), Function: m[3]}},
+	}
+	for i++; i < len(lines); i++ {
+		f := rubyFramePattern.FindStringSubmatch(lines[i])
+		if f == nil {
+			break
+		}
+		c.Frames = append(c.Frames, Frame{File: f[1], Line: atoi(f[2]), Function: f[3]})
+	}
+	return c, i
+}
+
+// Java: the exception, then its frames, then any causes with their own
+// frames:
+//
+//	Exception in thread "main" java.lang.IllegalStateException: bad row
+//		at com.example.Importer.parse(Importer.java:42)
+//		at com.example.Main.main(Main.java:7)
+//	Caused by: java.io.IOException: closed
+//		... 2 more
+var (
+	javaStartPattern = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?((?:[a-zA-Z_$][\w$]*\.)+[A-Z][\w$]*)(?:: (.*))?$`)
+	javaFramePattern = regexp.MustCompile(`^\s+at (?:[\w.$/@]+/)?([\w$.<>]+)\(([^:)]*)(?::(\d+))?\)$`)
+)
+
+func startJava(lines []string, i int) bool {
+	return javaStartPattern.MatchString(lines[i]) && i+1 < len(lines) && javaFramePattern.MatchString(lines[i+1])
+}
+
+func parseJava(lines []string, i int) (Crash, int) {
+	m := javaStartPattern.FindStringSubmatch(lines[i])
+	c := Crash{Class: m[1], Message: m[2]}
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if f := javaFramePattern.FindStringSubmatch(line); f != nil {
+			c.Frames = append(c.Frames, Frame{File: f[2], Line: atoi(f[3]), Function: f[1]})
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			// "... 2 more" and frames in a format that isn't recognized.
+			continue
+		}
+		if strings.HasPrefix(line, "Caused by: ") {
+			// Causes are part of this crash, but their frames are skipped.
+			for i+1 < len(lines) && (strings.HasPrefix(lines[i+1], "\t") || strings.HasPrefix(lines[i+1], "Caused by: ")) {
+				i++
+			}
+			continue
+		}
+		break
+	}
+	return c, i
+}
+
+// Node.js: the error, then its frames. Node prints the throwing source line
+// first, which is skipped:
+//
+//	TypeError: Cannot read properties of undefined (reading 'id')
+//	    at parse (/app/job.js:3:15)
+//	    at Object.<anonymous> (/app/job.js:8:1)
+var (
+	nodeStartPattern = regexp.MustCompile(`^(?:Uncaught )?([A-Z]\w*(?:Error|Exception)|Error)(?: \[\w+\])?: (.*)$`)
+	nodeFramePattern = regexp.MustCompile(`^\s+at (?:(.+?) \()?(.+?)(?::(\d+):\d+)?\)?$`)
+)
+
+func startNode(lines []string, i int) bool {
+	return nodeStartPattern.MatchString(lines[i]) && i+1 < len(lines) && nodeFramePattern.MatchString(lines[i+1])
+}
+
+func parseNode(lines []string, i int) (Crash, int) {
+	m := nodeStartPattern.FindStringSubmatch(lines[i])
+	c := Crash{Class: m[1], Message: m[2]}
+	for i++; i < len(lines); i++ {
+		f := nodeFramePattern.FindStringSubmatch(lines[i])
+		if f == nil {
+			break
+		}
+		c.Frames = append(c.Frames, Frame{File: f[2], Line: atoi(f[3]), Function: f[1]})
+	}
+	return c, i
+}
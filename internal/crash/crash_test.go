@@ -0,0 +1,172 @@
+package crash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []Crash
+	}{
+		{
+			fixture: "go_panic.txt",
+			want: []Crash{{
+				Language: "go",
+				Class:    "runtime.Error",
+				Message:  "runtime error: integer divide by zero",
+				Frames: []Frame{
+					{File: "/app/main.go", Line: 8, Function: "main.divide"},
+					{File: "/app/main.go", Line: 12, Function: "main.main"},
+				},
+			}},
+		},
+		{
+			fixture: "go_recovered.txt",
+			want: []Crash{{
+				Language: "go",
+				Class:    "panic",
+				Message:  "import failed: bad row 42",
+				Frames: []Frame{
+					{File: "/app/importer/importer.go", Line: 42, Function: "github.com/example/importer.(*Importer).Parse"},
+					{File: "/app/main.go", Line: 15, Function: "main.main"},
+				},
+			}},
+		},
+		{
+			fixture: "go_fatal.txt",
+			want: []Crash{{
+				Language: "go",
+				Class:    "fatal error",
+				Message:  "all goroutines are asleep - deadlock!",
+				Frames:   []Frame{{File: "/app/main.go", Line: 6, Function: "main.main"}},
+			}},
+		},
+		{
+			fixture: "python.txt",
+			want: []Crash{{
+				Language: "python",
+				Class:    "ValueError",
+				Message:  "bad row 42",
+				Frames: []Frame{
+					{File: "/app/importer.py", Line: 3, Function: "parse"},
+					{File: "/app/job.py", Line: 8, Function: "main"},
+					{File: "/app/job.py", Line: 12, Function: "<module>"},
+				},
+			}},
+		},
+		{
+			fixture: "python_chained.txt",
+			want: []Crash{{
+				Language: "python",
+				Class:    "KeyboardInterrupt",
+				Frames:   []Frame{{File: "/app/job.py", Line: 9, Function: "<module>"}},
+			}},
+		},
+		{
+			fixture: "python_cause.txt",
+			want: []Crash{{
+				Language: "python",
+				Class:    "ImportError",
+				Message:  "bad row 42",
+				Frames: []Frame{
+					{File: "/app/importer.py", Line: 8, Function: "load"},
+					{File: "/app/job.py", Line: 12, Function: "<module>"},
+				},
+			}},
+		},
+		{
+			fixture: "ruby.txt",
+			want: []Crash{{
+				Language: "ruby",
+				Class:    "ArgumentError",
+				Message:  "bad row 42",
+				Frames: []Frame{
+					{File: "/app/lib/importer.rb", Line: 3, Function: "parse"},
+					{File: "/app/lib/importer.rb", Line: 9, Function: "block in run"},
+					{File: "/app/lib/importer.rb", Line: 8, Function: "each"},
+					{File: "/app/job.rb", Line: 5, Function: "<main>"},
+				},
+			}},
+		},
+		{
+			fixture: "ruby34.txt",
+			want: []Crash{{
+				Language: "ruby",
+				Class:    "ZeroDivisionError",
+				Message:  "divided by 0",
+				Frames: []Frame{
+					{File: "/app/job.rb", Line: 2, Function: "Integer#/"},
+					{File: "/app/job.rb", Line: 2, Function: "Object#average"},
+					{File: "/app/job.rb", Line: 5, Function: "<main>"},
+				},
+			}},
+		},
+		{
+			fixture: "java.txt",
+			want: []Crash{{
+				Language: "java",
+				Class:    "java.lang.IllegalStateException",
+				Message:  "bad row 42",
+				Frames: []Frame{
+					{File: "Importer.java", Line: 42, Function: "com.example.Importer.parse"},
+					{File: "Importer.java", Line: 30, Function: "com.example.Importer.lambda$run$0"},
+					{File: "ArrayList.java", Line: 1596, Function: "java.util.ArrayList.forEach"},
+					{File: "Main.java", Line: 7, Function: "com.example.Main.main"},
+				},
+			}},
+		},
+		{
+			fixture: "node.txt",
+			want: []Crash{{
+				Language: "node",
+				Class:    "TypeError",
+				Message:  "Cannot read properties of undefined (reading 'id')",
+				Frames: []Frame{
+					{File: "/app/job.js", Line: 3, Function: "parse"},
+					{File: "<anonymous>", Function: "Array.map"},
+					{File: "/app/job.js", Line: 8, Function: "Object.<anonymous>"},
+					{File: "node:internal/main/run_main_module", Line: 28},
+				},
+			}},
+		},
+		{
+			fixture: "no_crash.txt",
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, Parse(string(data)))
+		})
+	}
+}
+
+func TestParseMixedOutput(t *testing.T) {
+	var output string
+	for _, fixture := range []string{"python.txt", "no_crash.txt", "go_fatal.txt"} {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture))
+		require.NoError(t, err)
+		output += string(data)
+	}
+
+	crashes := Parse(output)
+	require.Len(t, crashes, 2)
+	assert.Equal(t, "ValueError", crashes[0].Class)
+	assert.Equal(t, "fatal error", crashes[1].Class)
+}
+
+func TestParseCRLF(t *testing.T) {
+	crashes := Parse("Traceback (most recent call last):\r\n  File \"job.py\", line 1, in <module>\r\nKeyError: 'id'\r\n")
+	require.Len(t, crashes, 1)
+	assert.Equal(t, "KeyError", crashes[0].Class)
+	assert.Equal(t, "'id'", crashes[0].Message)
+}